@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"src/internal/config"
+	"src/internal/sqlitedb"
+)
+
+func TestCreateUserStrictDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{"valid", `{"username":"ann","password":"pw","role":"GUEST","room":"101"}`, http.StatusCreated, ""},
+		{"misspelled field", `{"username":"ann","pasword":"pw","role":"GUEST","room":"101"}`, http.StatusBadRequest, `invalid json: unknown field "pasword"`},
+		{"extra field", `{"username":"ann","password":"pw","role":"GUEST","room":"101","admin":true}`, http.StatusBadRequest, `invalid json: unknown field "admin"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sqlitedb.Open(filepath.Join(t.TempDir(), "auth.db"), sqlitedb.Pool{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if err := initSchema(db, false); err != nil {
+				t.Fatal(err)
+			}
+			cfg := config.AuthConfig{InternalKey: "test-key"}
+			h := createUserHandler(db, cfg, usernameNormalizer(false), slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(tt.body))
+			r.Header.Set("X-Internal-Key", cfg.InternalKey)
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != tt.wantErr {
+				t.Errorf("error = %q, want %q", body.Error, tt.wantErr)
+			}
+			var n int
+			if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			if want := map[bool]int{true: 1, false: 0}[tt.wantErr == ""]; n != want {
+				t.Errorf("%d users stored, want %d", n, want)
+			}
+		})
+	}
+}
@@ -5,28 +5,36 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"golang.org/x/crypto/bcrypt"
-	_ "modernc.org/sqlite"
 
+	"src/internal/buildinfo"
 	"src/internal/config"
+	"src/internal/health"
+	"src/internal/httpjson"
+	"src/internal/logging"
+	"src/internal/sqlitedb"
 )
 
 type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	PassHash  string    `json:"-"`
-	Role      string    `json:"role"`
-	Room      string    `json:"room"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         int64     `json:"id"`
+	Username   string    `json:"username"`
+	PassHash   string    `json:"-"`
+	Role       string    `json:"role"`
+	Room       string    `json:"room"`
+	Department string    `json:"department,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 const (
@@ -35,47 +43,106 @@ const (
 	RoleAdmin = "ADMIN"
 )
 
+// Error codes sent alongside the message for user-creation failures; the
+// gateway passes them through unchanged.
+const (
+	CodeCredentialsRequired = "credentials_required"
+	CodeInvalidRole         = "invalid_role"
+	CodeRoomRequired        = "room_required"
+	CodeUsernameTaken       = "username_taken"
+	CodeInvalidDepartment   = "invalid_department"
+	CodeLastAdmin           = "last_admin"
+)
+
+// maxBatchIDs caps ?ids= lookups so one request can't ask for the whole table.
+const maxBatchIDs = 100
+
+// maxUsersPage caps ?limit= on the users listing.
+const maxUsersPage = 500
+
+// maxDepartmentLen bounds a department name.
+const maxDepartmentLen = 64
+
+// maxBulkUsers caps a bulk import. Each row costs a bcrypt hash (tens of
+// milliseconds), so this keeps a batch well inside the request timeouts.
+const maxBulkUsers = 50
+
 type LoginReq struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 type CreateUserReq struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Role     string `json:"role"`
-	Room     string `json:"room,omitempty"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Role       string `json:"role"`
+	Room       string `json:"room,omitempty"`
+	Department string `json:"department,omitempty"`
+}
+
+// BulkResult is the outcome of one row of a bulk import; Index is its
+// position in the request.
+type BulkResult struct {
+	Index int    `json:"index"`
+	OK    bool   `json:"ok"`
+	User  *User  `json:"user,omitempty"`
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
 }
 
 func main() {
 	cfg := config.LoadAuth()
-	logger := log.New(os.Stdout, "[auth] ", log.LstdFlags|log.Lmicroseconds)
+	logger := logging.New("auth", cfg.LogFormat)
+	build := buildinfo.Get("auth")
+
+	if config.WeakInternalKey(cfg.InternalKey) {
+		if config.IsProduction(cfg.Env) {
+			logging.Fatal(logger, "refusing to start: AUTH_INTERNAL_KEY is empty or the dev default", "env", cfg.Env)
+		}
+		logger.Warn("AUTH_INTERNAL_KEY is the dev default; set a real key before deploying", "env", cfg.Env)
+	}
 
 	if err := os.MkdirAll(filepath.Dir(cfg.DBPath), 0o755); err != nil {
-		logger.Fatalf("mkdir data dir: %v", err)
+		logging.Fatal(logger, "mkdir data dir", "err", err)
 	}
 
-	db, err := sql.Open("sqlite", cfg.DBPath)
+	db, err := sqlitedb.Open(cfg.DBPath, sqlitedb.Pool{
+		MaxOpenConns:    cfg.DBPool.MaxOpenConns,
+		MaxIdleConns:    cfg.DBPool.MaxIdleConns,
+		ConnMaxLifetime: cfg.DBPool.ConnMaxLifetime,
+	})
 	if err != nil {
-		logger.Fatalf("open db: %v", err)
+		logging.Fatal(logger, "open db", "err", err)
 	}
 	defer db.Close()
 
-	if err := initSchema(db); err != nil {
-		logger.Fatalf("init schema: %v", err)
+	if err := initSchema(db, cfg.UsernameCaseInsensitive); err != nil {
+		logging.Fatal(logger, "init schema", "err", err)
 	}
+	norm := usernameNormalizer(cfg.UsernameCaseInsensitive)
 
 	// bootstrap admin
 	if cfg.BootstrapAdmin {
-		_ = ensureAdmin(db, cfg.BootstrapUser, cfg.BootstrapPass)
+		_ = ensureAdmin(db, norm(cfg.BootstrapUser), cfg.BootstrapPass)
 	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.RealIP)
+	// Keeps the X-Request-Id forwarded by the gateway, so one ID traces a
+	// call through both services' logs.
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Recoverer)
+	r.Use(httpjson.LimitBody(cfg.MaxBodyBytes))
 	r.Use(middleware.Timeout(10 * time.Second))
-	r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: logger, NoColor: true}))
+	r.Use(logging.RequestLogger(logger))
+
+	r.Get("/version", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, 200, build)
+	})
+
+	// Liveness and readiness probes for orchestrators.
+	r.Get("/healthz", health.Liveness("auth"))
+	r.Get("/readyz", health.Readiness("auth", health.Check{Name: "db", Run: db.PingContext}))
 
 	r.Get("/health", func(w http.ResponseWriter, _ *http.Request) {
 		writeJSON(w, 200, map[string]string{"status": "ok", "service": "auth"})
@@ -84,11 +151,11 @@ func main() {
 	// Public: login
 	r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {
 		var req LoginReq
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeErr(w, 400, "invalid json")
+		if err := httpjson.Decode(r, &req, false); err != nil {
+			writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
 			return
 		}
-		u, err := getByUsername(db, req.Username)
+		u, err := getByUsername(db, norm(req.Username), cfg.UsernameCaseInsensitive)
 		if errors.Is(err, sql.ErrNoRows) {
 			writeErr(w, 401, "invalid credentials")
 			return
@@ -107,58 +174,88 @@ func main() {
 				"username":   u.Username,
 				"role":       u.Role,
 				"room":       u.Room,
+				"department": u.Department,
 				"created_at": u.CreatedAt,
 			},
 		})
 	})
 
 	// Internal: create user, list users (protected by internal key)
-	r.Post("/api/users", func(w http.ResponseWriter, r *http.Request) {
+	r.Post("/api/users", createUserHandler(db, cfg, norm, logger))
+
+	// Internal: create many users in one transaction. Each row is validated
+	// and inserted on its own, so a bad or duplicate row is reported in its
+	// result without aborting the others.
+	r.Post("/api/users/bulk", func(w http.ResponseWriter, r *http.Request) {
 		if !internalOK(r, cfg.InternalKey) {
 			writeErr(w, 403, "forbidden")
 			return
 		}
-		var req CreateUserReq
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeErr(w, 400, "invalid json")
-			return
-		}
-		if req.Username == "" || req.Password == "" {
-			writeErr(w, 400, "username and password required")
+		var reqs []CreateUserReq
+		if err := httpjson.Decode(r, &reqs, true); err != nil {
+			writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
 			return
 		}
-		if req.Role != RoleGuest && req.Role != RoleStaff && req.Role != RoleAdmin {
-			writeErr(w, 400, "invalid role")
+		if len(reqs) == 0 {
+			writeErr(w, 400, "no users")
 			return
 		}
-		if req.Role == RoleGuest && req.Room == "" {
-			writeErr(w, 400, "room required for guest")
+		if len(reqs) > maxBulkUsers {
+			writeErr(w, 400, "too many users (max "+strconv.Itoa(maxBulkUsers)+")")
 			return
 		}
-		if req.Role != RoleGuest && req.Room != "" {
-			req.Room = ""
-		}
 
-		ph, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-		now := time.Now().UTC()
+		// Validate and hash first: the transaction holds the write lock, and
+		// bcrypt is the slow part.
+		results := make([]BulkResult, len(reqs))
+		hashes := make([]string, len(reqs))
+		for i := range reqs {
+			results[i].Index = i
+			reqs[i].Username = norm(reqs[i].Username)
+			if _, code, msg := validateCreateUser(&reqs[i], cfg.Departments); code != "" {
+				results[i].Code, results[i].Error = code, msg
+				continue
+			}
+			hashes[i] = hashPassword(reqs[i].Password)
+		}
 
-		res, err := db.Exec(`INSERT INTO users(username, password_hash, role, room, created_at) VALUES(?,?,?,?,?)`,
-			req.Username, string(ph), req.Role, req.Room, now.Format(time.RFC3339Nano),
-		)
+		tx, err := db.BeginTx(r.Context(), nil)
 		if err != nil {
-			writeErr(w, 400, "could not create user (maybe username exists)")
+			writeErr(w, 500, "db error")
 			return
 		}
-		id, _ := res.LastInsertId()
+		defer tx.Rollback()
 
-		writeJSON(w, 201, map[string]any{
-			"user": map[string]any{
-				"id":         id,
-				"username":   req.Username,
-				"role":       req.Role,
-				"room":       req.Room,
-				"created_at": now,
-			},
+		created := 0
+		for i, req := range reqs {
+			if results[i].Code != "" {
+				continue
+			}
+			res := BulkResult{Index: i}
+			// A failed INSERT only undoes that statement, not the transaction.
+			u, err := insertUser(tx, req, hashes[i])
+			switch {
+			case err == nil:
+				res.OK, res.User = true, &u
+				created++
+			case isUniqueViolation(err):
+				res.Code, res.Error = CodeUsernameTaken, "username already exists"
+			default:
+				logging.ForRequest(logger, r).Error("bulk create user", "index", i, "err", err)
+				writeErr(w, 500, "db error")
+				return
+			}
+			results[i] = res
+		}
+		if err := tx.Commit(); err != nil {
+			logging.ForRequest(logger, r).Error("bulk create commit", "err", err)
+			writeErr(w, 500, "db error")
+			return
+		}
+		writeJSON(w, 200, map[string]any{
+			"created": created,
+			"failed":  len(reqs) - created,
+			"results": results,
 		})
 	})
 
@@ -167,15 +264,96 @@ func main() {
 			writeErr(w, 403, "forbidden")
 			return
 		}
-		role := r.URL.Query().Get("role")
+		q := ` FROM users WHERE 1=1`
+		var args []any
+		if role := r.URL.Query().Get("role"); role != "" {
+			q += ` AND role=?`
+			args = append(args, role)
+		}
+		if dept := strings.TrimSpace(r.URL.Query().Get("department")); dept != "" {
+			q += ` AND department=?`
+			args = append(args, dept)
+		}
+		// ?q= username prefix search, composable with the other filters.
+		if prefix := strings.TrimSpace(r.URL.Query().Get("q")); prefix != "" {
+			q += ` AND username LIKE ? ESCAPE '\'`
+			args = append(args, likeEscaper.Replace(prefix)+"%")
+		}
+		// ?ids=1,2,3 batch lookup
+		if raw := r.URL.Query().Get("ids"); raw != "" {
+			parts := strings.Split(raw, ",")
+			if len(parts) > maxBatchIDs {
+				writeErr(w, 400, "too many ids (max "+strconv.Itoa(maxBatchIDs)+")")
+				return
+			}
+			marks := make([]string, 0, len(parts))
+			for _, p := range parts {
+				id, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64)
+				if err != nil || id <= 0 {
+					writeErr(w, 400, "invalid ids")
+					return
+				}
+				marks = append(marks, "?")
+				args = append(args, id)
+			}
+			q += ` AND id IN (` + strings.Join(marks, ",") + `)`
+		}
+
+		// ?from=&to= RFC3339 created_at range, inclusive. created_at is
+		// stored as text, so both sides go through datetime().
+		var from, to time.Time
+		for _, p := range []struct {
+			name string
+			dst  *time.Time
+		}{{"from", &from}, {"to", &to}} {
+			if raw := r.URL.Query().Get(p.name); raw != "" {
+				t, err := time.Parse(time.RFC3339, raw)
+				if err != nil {
+					writeErr(w, 400, "invalid "+p.name+" (RFC3339)")
+					return
+				}
+				*p.dst = t
+			}
+		}
+		if !from.IsZero() && !to.IsZero() && from.After(to) {
+			writeErr(w, 400, "from must not be after to")
+			return
+		}
+		if !from.IsZero() {
+			q += ` AND datetime(created_at) >= datetime(?)`
+			args = append(args, from.UTC().Format(time.RFC3339))
+		}
+		if !to.IsZero() {
+			q += ` AND datetime(created_at) <= datetime(?)`
+			args = append(args, to.UTC().Format(time.RFC3339))
+		}
 
-		var rows *sql.Rows
-		var err error
-		if role != "" {
-			rows, err = db.Query(`SELECT id, username, role, room, created_at FROM users WHERE role=? ORDER BY id ASC`, role)
-		} else {
-			rows, err = db.Query(`SELECT id, username, role, room, created_at FROM users ORDER BY id ASC`)
+		// ?limit=&offset= paging; without limit every match is returned.
+		limit, offset := -1, 0
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxUsersPage {
+				writeErr(w, 400, "invalid limit (1-"+strconv.Itoa(maxUsersPage)+")")
+				return
+			}
+			limit = n
 		}
+		if raw := r.URL.Query().Get("offset"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				writeErr(w, 400, "invalid offset")
+				return
+			}
+			offset = n
+		}
+
+		var total int
+		if err := db.QueryRow(`SELECT COUNT(*)`+q, args...).Scan(&total); err != nil {
+			writeErr(w, 500, "db error")
+			return
+		}
+
+		rows, err := db.Query(`SELECT id, username, role, room, department, created_at`+q+` ORDER BY id ASC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
 		if err != nil {
 			writeErr(w, 500, "db error")
 			return
@@ -183,18 +361,19 @@ func main() {
 		defer rows.Close()
 
 		type outUser struct {
-			ID        int64     `json:"id"`
-			Username  string    `json:"username"`
-			Role      string    `json:"role"`
-			Room      string    `json:"room"`
-			CreatedAt time.Time `json:"created_at"`
+			ID         int64     `json:"id"`
+			Username   string    `json:"username"`
+			Role       string    `json:"role"`
+			Room       string    `json:"room"`
+			Department string    `json:"department,omitempty"`
+			CreatedAt  time.Time `json:"created_at"`
 		}
 
 		var out []outUser
 		for rows.Next() {
 			var u outUser
 			var created string
-			if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.Room, &created); err != nil {
+			if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.Room, &u.Department, &created); err != nil {
 				writeErr(w, 500, "db error")
 				return
 			}
@@ -202,7 +381,77 @@ func main() {
 			out = append(out, u)
 		}
 
-		writeJSON(w, 200, map[string]any{"users": out})
+		writeJSON(w, 200, map[string]any{"users": out, "total": total})
+	})
+
+	r.Get("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !internalOK(r, cfg.InternalKey) {
+			writeErr(w, 403, "forbidden")
+			return
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeErr(w, 400, "invalid id")
+			return
+		}
+		u, err := getByID(db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErr(w, 404, "user not found")
+			return
+		}
+		if err != nil {
+			writeErr(w, 500, "db error")
+			return
+		}
+		writeJSON(w, 200, map[string]any{"user": u})
+	})
+
+	// Internal: change a user's role.
+	r.Patch("/api/users/{id}", changeRoleHandler(db, cfg.InternalKey))
+
+	// Internal: move a staff member to another department ("" removes them
+	// from any).
+	r.Put("/api/users/{id}/department", func(w http.ResponseWriter, r *http.Request) {
+		if !internalOK(r, cfg.InternalKey) {
+			writeErr(w, 403, "forbidden")
+			return
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeErr(w, 400, "invalid id")
+			return
+		}
+		var req struct {
+			Department string `json:"department"`
+		}
+		if err := httpjson.Decode(r, &req, true); err != nil {
+			writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+			return
+		}
+		dept, msg := checkDepartment(req.Department, cfg.Departments)
+		if msg != "" {
+			writeErrCode(w, 400, CodeInvalidDepartment, msg)
+			return
+		}
+		u, err := getByID(db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErr(w, 404, "user not found")
+			return
+		}
+		if err != nil {
+			writeErr(w, 500, "db error")
+			return
+		}
+		if u.Role != RoleStaff {
+			writeErrCode(w, 400, CodeInvalidDepartment, "only staff belong to a department")
+			return
+		}
+		if _, err := db.Exec(`UPDATE users SET department=? WHERE id=?`, dept, id); err != nil {
+			writeErr(w, 500, "db error")
+			return
+		}
+		u.Department = dept
+		writeJSON(w, 200, map[string]any{"user": u})
 	})
 
 	srv := &http.Server{Addr: cfg.Addr, Handler: r}
@@ -211,9 +460,9 @@ func main() {
 	defer stop()
 
 	go func() {
-		logger.Printf("listening on %s (db=%s)", cfg.Addr, cfg.DBPath)
+		logger.Info("listening", "addr", cfg.Addr, "db", cfg.DBPath, "version", build.Version, "commit", build.Commit, "build_time", build.BuildTime)
 		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Fatalf("listen: %v", err)
+			logging.Fatal(logger, "listen", "err", err)
 		}
 	}()
 
@@ -223,11 +472,206 @@ func main() {
 	_ = srv.Shutdown(shutdownCtx)
 }
 
+// createUserHandler serves POST /api/users. Unknown fields in the body are
+// rejected, so a typo such as "pasword" is a 400 rather than a silently
+// empty field.
+func createUserHandler(db *sql.DB, cfg config.AuthConfig, norm func(string) string, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !internalOK(r, cfg.InternalKey) {
+			writeErr(w, 403, "forbidden")
+			return
+		}
+		var req CreateUserReq
+		if err := httpjson.Decode(r, &req, true); err != nil {
+			writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+			return
+		}
+		req.Username = norm(req.Username)
+		if status, code, msg := validateCreateUser(&req, cfg.Departments); code != "" {
+			writeErrCode(w, status, code, msg)
+			return
+		}
+
+		u, err := insertUser(db, req, hashPassword(req.Password))
+		if err != nil {
+			if isUniqueViolation(err) {
+				writeErrCode(w, 409, CodeUsernameTaken, "username already exists")
+				return
+			}
+			logging.ForRequest(logger, r).Error("create user", "err", err)
+			writeErr(w, 500, "db error")
+			return
+		}
+		writeJSON(w, 201, map[string]any{"user": u})
+	}
+}
+
+// changeRoleHandler serves PATCH /api/users/{id}, which changes a user's
+// role. Only GUESTs keep a room, so becoming a guest needs one and leaving
+// guest clears it; likewise only STAFF keep a department. The last ADMIN
+// can't be demoted.
+func changeRoleHandler(db *sql.DB, internalKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !internalOK(r, internalKey) {
+			writeErr(w, 403, "forbidden")
+			return
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeErr(w, 400, "invalid id")
+			return
+		}
+		var req struct {
+			Role string `json:"role"`
+			Room string `json:"room"`
+		}
+		if err := httpjson.Decode(r, &req, true); err != nil {
+			writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+			return
+		}
+		req.Room = strings.TrimSpace(req.Room)
+		if req.Role != RoleGuest && req.Role != RoleStaff && req.Role != RoleAdmin {
+			writeErrCode(w, 400, CodeInvalidRole, "invalid role")
+			return
+		}
+		if req.Role == RoleGuest && req.Room == "" {
+			writeErrCode(w, 400, CodeRoomRequired, "room required for guest")
+			return
+		}
+		if req.Role != RoleGuest {
+			req.Room = ""
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeErr(w, 500, "db error")
+			return
+		}
+		defer tx.Rollback()
+
+		var u User
+		var created string
+		err = tx.QueryRow(`SELECT id, username, role, room, department, created_at FROM users WHERE id=?`, id).
+			Scan(&u.ID, &u.Username, &u.Role, &u.Room, &u.Department, &created)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErr(w, 404, "user not found")
+			return
+		}
+		if err != nil {
+			writeErr(w, 500, "db error")
+			return
+		}
+		u.CreatedAt = parseTime(created)
+		if u.Role == RoleAdmin && req.Role != RoleAdmin {
+			var admins int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE role=?`, RoleAdmin).Scan(&admins); err != nil {
+				writeErr(w, 500, "db error")
+				return
+			}
+			if admins <= 1 {
+				writeErrCode(w, 409, CodeLastAdmin, "cannot demote the last admin")
+				return
+			}
+		}
+		if req.Role != RoleStaff {
+			u.Department = ""
+		}
+		u.Role, u.Room = req.Role, req.Room
+		if _, err := tx.Exec(`UPDATE users SET role=?, room=?, department=? WHERE id=?`, u.Role, u.Room, u.Department, id); err != nil {
+			writeErr(w, 500, "db error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeErr(w, 500, "db error")
+			return
+		}
+		writeJSON(w, 200, map[string]any{"user": u})
+	}
+}
+
+// validateCreateUser applies the create-user rules to req, whose username
+// is already normalized, and clears the room of non-guests. departments is
+// the configured set a staff department must come from (empty allows any).
+// A non-empty code means req is invalid.
+func validateCreateUser(req *CreateUserReq, departments []string) (status int, code, msg string) {
+	if req.Username == "" || req.Password == "" {
+		return 400, CodeCredentialsRequired, "username and password required"
+	}
+	if req.Role != RoleGuest && req.Role != RoleStaff && req.Role != RoleAdmin {
+		return 400, CodeInvalidRole, "invalid role"
+	}
+	if req.Role == RoleGuest && req.Room == "" {
+		return 400, CodeRoomRequired, "room required for guest"
+	}
+	if req.Role != RoleGuest {
+		req.Room = ""
+	}
+	dept, msg := checkDepartment(req.Department, departments)
+	if msg != "" {
+		return 400, CodeInvalidDepartment, msg
+	}
+	if dept != "" && req.Role != RoleStaff {
+		return 400, CodeInvalidDepartment, "only staff belong to a department"
+	}
+	req.Department = dept
+	return 0, "", ""
+}
+
+// checkDepartment trims dept and, when allowed is set, matches it against
+// that list ignoring case, returning the configured spelling. "" is always
+// accepted and means no department. A non-empty msg means dept is invalid.
+func checkDepartment(dept string, allowed []string) (string, string) {
+	dept = strings.TrimSpace(dept)
+	if dept == "" {
+		return "", ""
+	}
+	if len(dept) > maxDepartmentLen {
+		return "", "department too long (max " + strconv.Itoa(maxDepartmentLen) + ")"
+	}
+	if len(allowed) == 0 {
+		return dept, ""
+	}
+	for _, d := range allowed {
+		if strings.EqualFold(d, dept) {
+			return d, ""
+		}
+	}
+	return "", "unknown department (allowed: " + strings.Join(allowed, ", ") + ")"
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func hashPassword(pw string) string {
+	ph, _ := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	return string(ph)
+}
+
+// insertUser stores req with the given password hash.
+func insertUser(db execer, req CreateUserReq, passHash string) (User, error) {
+	now := time.Now().UTC()
+	res, err := db.Exec(`INSERT INTO users(username, password_hash, role, room, department, created_at) VALUES(?,?,?,?,?,?)`,
+		req.Username, passHash, req.Role, req.Room, req.Department, now.Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		return User{}, err
+	}
+	id, _ := res.LastInsertId()
+	return User{ID: id, Username: req.Username, Role: req.Role, Room: req.Room, Department: req.Department, CreatedAt: now}, nil
+}
+
+// likeEscaper escapes LIKE wildcards so a search matches them literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 func internalOK(r *http.Request, key string) bool {
 	return key != "" && r.Header.Get("X-Internal-Key") == key
 }
 
-func initSchema(db *sql.DB) error {
+// initSchema creates the users table. With caseInsensitive, usernames
+// must also be unique ignoring case; it fails if existing rows already
+// differ only by case.
+func initSchema(db *sql.DB, caseInsensitive bool) error {
 	_, err := db.Exec(`
 CREATE TABLE IF NOT EXISTS users (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -239,7 +683,41 @@ CREATE TABLE IF NOT EXISTS users (
 );
 CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
 `)
-	return err
+	if err != nil {
+		return err
+	}
+	var hasDept int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='department'`).Scan(&hasDept); err != nil {
+		return err
+	}
+	if hasDept == 0 {
+		if _, err := db.Exec(`ALTER TABLE users ADD COLUMN department TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+	// Names stored before trimming was enforced; a trimmed name that is
+	// already taken is left alone rather than failing startup.
+	if _, err := db.Exec(`UPDATE OR IGNORE users SET username=TRIM(username) WHERE username<>TRIM(username)`); err != nil {
+		return err
+	}
+	if caseInsensitive {
+		if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_nocase ON users(username COLLATE NOCASE)`); err != nil {
+			return fmt.Errorf("case-insensitive usernames: existing usernames differ only by case: %w", err)
+		}
+	}
+	return nil
+}
+
+// usernameNormalizer returns the function applied to every username before
+// it is stored or looked up: trim, and lowercase in case-insensitive mode.
+func usernameNormalizer(caseInsensitive bool) func(string) string {
+	return func(s string) string {
+		s = strings.TrimSpace(s)
+		if caseInsensitive {
+			s = strings.ToLower(s)
+		}
+		return s
+	}
 }
 
 func ensureAdmin(db *sql.DB, user, pass string) error {
@@ -261,11 +739,33 @@ func ensureAdmin(db *sql.DB, user, pass string) error {
 	return err
 }
 
-func getByUsername(db *sql.DB, username string) (User, error) {
+func getByUsername(db *sql.DB, username string, caseInsensitive bool) (User, error) {
 	var u User
 	var created string
-	err := db.QueryRow(`SELECT id, username, password_hash, role, room, created_at FROM users WHERE username=?`, username).
-		Scan(&u.ID, &u.Username, &u.PassHash, &u.Role, &u.Room, &created)
+	q := `SELECT id, username, password_hash, role, room, department, created_at FROM users WHERE username=?`
+	if caseInsensitive {
+		// Accounts created before the mode was switched on may be stored
+		// mixed-case.
+		q += ` COLLATE NOCASE`
+	}
+	err := db.QueryRow(q, username).
+		Scan(&u.ID, &u.Username, &u.PassHash, &u.Role, &u.Room, &u.Department, &created)
+	if err != nil {
+		return User{}, err
+	}
+	u.CreatedAt = parseTime(created)
+	return u, nil
+}
+
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+func getByID(db *sql.DB, id int64) (User, error) {
+	var u User
+	var created string
+	err := db.QueryRow(`SELECT id, username, role, room, department, created_at FROM users WHERE id=?`, id).
+		Scan(&u.ID, &u.Username, &u.Role, &u.Room, &u.Department, &created)
 	if err != nil {
 		return User{}, err
 	}
@@ -292,3 +792,9 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 func writeErr(w http.ResponseWriter, status int, msg string) {
 	writeJSON(w, status, map[string]string{"error": msg})
 }
+
+// writeErrCode is writeErr plus a machine-readable code, for errors a
+// client reacts to differently (e.g. which form field to highlight).
+func writeErrCode(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg, "code": code})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"src/internal/sqlitedb"
+)
+
+func TestChangeRole(t *testing.T) {
+	const key = "test-key"
+
+	tests := []struct {
+		name     string
+		user     CreateUserReq
+		body     string
+		wantCode int
+		wantErr  string // error code; "" on success
+		want     User   // role, room and department after the change
+	}{
+		{
+			name:     "guest to staff clears the room",
+			user:     CreateUserReq{Role: RoleGuest, Room: "101"},
+			body:     `{"role":"STAFF","room":"101"}`,
+			wantCode: http.StatusOK,
+			want:     User{Role: RoleStaff},
+		},
+		{
+			name:     "staff to guest needs a room",
+			user:     CreateUserReq{Role: RoleStaff, Department: "plumbing"},
+			body:     `{"role":"GUEST"}`,
+			wantCode: http.StatusBadRequest,
+			wantErr:  CodeRoomRequired,
+			want:     User{Role: RoleStaff, Department: "plumbing"},
+		},
+		{
+			name:     "staff to guest with a room",
+			user:     CreateUserReq{Role: RoleStaff, Department: "plumbing"},
+			body:     `{"role":"GUEST","room":" 205 "}`,
+			wantCode: http.StatusOK,
+			want:     User{Role: RoleGuest, Room: "205"},
+		},
+		{
+			name:     "leaving staff clears the department",
+			user:     CreateUserReq{Role: RoleStaff, Department: "plumbing"},
+			body:     `{"role":"ADMIN"}`,
+			wantCode: http.StatusOK,
+			want:     User{Role: RoleAdmin},
+		},
+		{
+			name:     "last admin can't be demoted",
+			user:     CreateUserReq{Role: RoleAdmin},
+			body:     `{"role":"STAFF"}`,
+			wantCode: http.StatusConflict,
+			wantErr:  CodeLastAdmin,
+			want:     User{Role: RoleAdmin},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sqlitedb.Open(filepath.Join(t.TempDir(), "auth.db"), sqlitedb.Pool{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if err := initSchema(db, false); err != nil {
+				t.Fatal(err)
+			}
+			tt.user.Username = "target"
+			u, err := insertUser(db, tt.user, "x")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id := strconv.FormatInt(u.ID, 10)
+			r := httptest.NewRequest(http.MethodPatch, "/api/users/"+id, strings.NewReader(tt.body))
+			r.Header.Set("X-Internal-Key", key)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", id)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			w := httptest.NewRecorder()
+			changeRoleHandler(db, key)(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var body struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantErr {
+				t.Errorf("code = %q, want %q", body.Code, tt.wantErr)
+			}
+
+			got, err := getByID(db, u.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Role != tt.want.Role || got.Room != tt.want.Room || got.Department != tt.want.Department {
+				t.Errorf("stored role/room/department = %q/%q/%q, want %q/%q/%q",
+					got.Role, got.Room, got.Department, tt.want.Role, tt.want.Room, tt.want.Department)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"src/internal/sse"
+)
+
+func TestEventMeta(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    sse.Meta
+	}{
+		{"routed to a department", `{"event":"department_assigned","ticket":{"room":"101","created_by_user_id":50,"assigned_department":"Plumbing"}}`,
+			sse.Meta{Room: "101", Department: "Plumbing", CreatedByUserID: 50}},
+		{"assigned", `{"event":"assigned","ticket":{"room":"101","assigned_to_user_id":7,"assigned_department":"Plumbing"}}`,
+			sse.Meta{Room: "101", AssignedToUserID: 7, Department: "Plumbing"}},
+		{"not JSON", `nope`, sse.Meta{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eventMeta([]byte(tt.payload)); got != tt.want {
+				t.Errorf("eventMeta = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
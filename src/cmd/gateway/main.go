@@ -2,27 +2,37 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	_ "modernc.org/sqlite"
 
 	"src/internal/authclient"
+	"src/internal/buildinfo"
+	"src/internal/bulk"
 	"src/internal/config"
+	"src/internal/health"
+	"src/internal/httpjson"
+	"src/internal/logging"
 	"src/internal/mq"
+	"src/internal/ratelimit"
 	"src/internal/session"
+	"src/internal/sqlitedb"
 	"src/internal/sse"
 	"src/internal/tickets"
 )
@@ -31,45 +41,93 @@ const sessionCookieName = "smarthotel_session"
 
 func main() {
 	cfg := config.LoadGateway()
-	logger := log.New(os.Stdout, "[gateway] ", log.LstdFlags|log.Lmicroseconds)
+	logger := logging.New("gateway", cfg.LogFormat)
+	build := buildinfo.Get("gateway")
+
+	if config.WeakInternalKey(cfg.AuthInternalKey) {
+		if config.IsProduction(cfg.Env) {
+			logging.Fatal(logger, "refusing to start: AUTH_INTERNAL_KEY is empty or the dev default", "env", cfg.Env)
+		}
+		logger.Warn("AUTH_INTERNAL_KEY is the dev default; set a real key before deploying", "env", cfg.Env)
+	}
 
 	if err := os.MkdirAll(filepath.Dir(cfg.DBPath), 0o755); err != nil {
-		logger.Fatalf("mkdir data dir: %v", err)
+		logging.Fatal(logger, "mkdir data dir", "err", err)
 	}
 
-	db, err := sql.Open("sqlite", cfg.DBPath)
+	db, err := sqlitedb.Open(cfg.DBPath, sqlitedb.Pool{
+		MaxOpenConns:    cfg.DBPool.MaxOpenConns,
+		MaxIdleConns:    cfg.DBPool.MaxIdleConns,
+		ConnMaxLifetime: cfg.DBPool.ConnMaxLifetime,
+	})
 	if err != nil {
-		logger.Fatalf("open db: %v", err)
+		logging.Fatal(logger, "open db", "err", err)
 	}
 	defer db.Close()
 
 	if err := tickets.InitSchema(db); err != nil {
-		logger.Fatalf("init schema: %v", err)
+		logging.Fatal(logger, "init schema", "err", err)
 	}
 
 	repo := tickets.NewRepository(db)
 
 	// SSE hub
-	hub := sse.NewHub(logger)
+	hub := sse.NewHub(logger, sse.Options{
+		KeepAlive:       cfg.SSEKeepAlive,
+		BroadcastBuffer: cfg.SSEBroadcastBuffer,
+		ClientBuffer:    cfg.SSEClientBuffer,
+		Policy:          sse.Policy(cfg.SSESlowClientPolicy),
+		DisconnectAfter: cfg.SSEDisconnectAfter,
+	})
 	go hub.Run()
 
-	// MQTT client (publish + subscribe)
-	mqttClient, err := mq.Connect(mq.Config{
+	// MQTT client (publish + subscribe); events raised while the broker is
+	// down wait in the outbox until the next (re)connect.
+	outbox := mq.NewOutbox(cfg.MQTTOutboxSize)
+	subState := mq.NewSubscriptionState()
+	mqttCfg := mq.Config{
 		BrokerURL: cfg.MQTTBroker,
 		ClientID:  cfg.MQTTClientID,
 		Logger:    logger,
-	})
-	if err != nil {
-		logger.Fatalf("mqtt connect: %v", err)
+		Username:  cfg.MQTTUsername,
+		Password:  cfg.MQTTPassword,
+		TLS:       mq.TLSConfig(cfg.MQTTTLS),
+		Outbox:    outbox,
+		Status: mq.StatusConfig{
+			Enabled: cfg.MQTTStatus.Enabled,
+			Service: "gateway",
+			Topic:   cfg.MQTTStatus.Topic,
+		},
+		// Subscribe to topics and broadcast to SSE clients; redone on
+		// every reconnect.
+		Subscriptions: bridgeSubscriptions(hub, repo),
+		Retry: mq.RetryPolicy{
+			Attempts:   cfg.MQTTSubscribe.Attempts,
+			Backoff:    cfg.MQTTSubscribe.Backoff,
+			MaxBackoff: 10 * time.Second,
+		},
+		SubscriptionState: subState,
 	}
-	defer mqttClient.Disconnect(250)
-
-	// Subscribe to topics and broadcast to SSE clients
-	subscribeAndBridge(logger, mqttClient, hub)
+	mqttClient, err := mq.Connect(mqttCfg)
+	var subErr *mq.SubscribeError
+	switch {
+	case errors.As(err, &subErr) && !cfg.MQTTSubscribe.FailFast:
+		logger.Error("mqtt subscribe failed; running degraded", "err", err)
+	case err != nil:
+		logging.Fatal(logger, "mqtt connect", "err", err)
+	}
+	defer mq.Disconnect(mqttClient, mqttCfg, 250)
 
 	// Auth client + session store
-	authC := authclient.New(cfg.AuthServiceURL, cfg.AuthInternalKey)
+	authC := authclient.New(cfg.AuthServiceURL, cfg.AuthInternalKey, authclient.Options{
+		MaxIdleConnsPerHost: cfg.AuthMaxIdleConns,
+		IdleConnTimeout:     cfg.AuthIdleConnTimeout,
+	})
+	authC.Retries = cfg.AuthRetries
+	authC.RetryBackoff = cfg.AuthRetryBackoff
+	authC.RoleCacheTTL = cfg.AuthRoleCacheTTL
 	sessions := session.NewStore(12 * time.Hour)
+	sessions.SetRevalidation(cfg.SessionRevalidateInterval, revalidateUser(authC, logger))
 
 	// Templates
 	tmpl, err := template.ParseFiles(
@@ -80,22 +138,47 @@ func main() {
 		"web/templates/staff.html",
 	)
 	if err != nil {
-		logger.Fatalf("parse templates: %v", err)
+		logging.Fatal(logger, "parse templates", "err", err)
 	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.RealIP)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Recoverer)
+	r.Use(httpjson.LimitBody(cfg.MaxBodyBytes))
 	r.Use(middleware.Timeout(20 * time.Second))
-	r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: logger, NoColor: true}))
+	r.Use(logging.RequestLogger(logger))
 
 	// Static
 	fs := http.FileServer(http.Dir("web/static"))
 	r.Handle("/static/*", http.StripPrefix("/static/", fs))
 
+	r.Get("/version", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, 200, build)
+	})
+
+	// Liveness and readiness probes for orchestrators.
+	r.Get("/healthz", health.Liveness("gateway"))
+	r.Get("/readyz", health.Readiness("gateway",
+		health.Check{Name: "db", Run: db.PingContext},
+		health.Check{Name: "mqtt", Run: func(context.Context) error {
+			if !mqttClient.IsConnected() {
+				return mq.ErrNotConnected
+			}
+			if failed := subState.Failed(); len(failed) > 0 {
+				return errors.New("subscriptions failed: " + strings.Join(failed, ", "))
+			}
+			return nil
+		}},
+		health.Check{Name: "auth", Run: authC.Ping},
+	))
+
 	// Health
 	r.Get("/health", func(w http.ResponseWriter, _ *http.Request) {
+		if failed := subState.Failed(); len(failed) > 0 {
+			writeJSON(w, 503, map[string]any{"status": "degraded", "service": "gateway", "failed_subscriptions": failed})
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(`{"status":"ok","service":"gateway"}`))
@@ -109,16 +192,18 @@ func main() {
 		})
 	})
 
-	// Auth API
-	r.Post("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+	// Auth API (login is throttled per client IP against credential stuffing)
+	loginLimiter := ratelimit.New(cfg.LoginRateWindow)
+	r.With(ipRateLimit(loginLimiter, cfg.LoginRateLimit)).Post("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
 		var req authclient.LoginRequest
 		if err := jsonDecode(r, &req); err != nil {
-			writeErr(w, 400, "invalid json")
+			writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
 			return
 		}
-		u, err := authC.Login(req)
+		req.Username = strings.TrimSpace(req.Username)
+		u, err := authC.Login(r.Context(), req)
 		if err != nil {
-			writeErr(w, 401, "invalid credentials")
+			writeAuthErr(w, err)
 			return
 		}
 
@@ -128,14 +213,7 @@ func main() {
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     sessionCookieName,
-			Value:    ss.ID,
-			Path:     "/",
-			HttpOnly: true,
-			SameSite: http.SameSiteLaxMode,
-			// Secure: true (enable if https)
-		})
+		http.SetCookie(w, sessionCookie(cfg, ss.ID, 0))
 
 		writeJSON(w, 200, map[string]any{"user": u})
 	})
@@ -144,14 +222,7 @@ func main() {
 		if c, err := r.Cookie(sessionCookieName); err == nil {
 			sessions.Delete(c.Value)
 		}
-		http.SetCookie(w, &http.Cookie{
-			Name:     sessionCookieName,
-			Value:    "",
-			Path:     "/",
-			MaxAge:   -1,
-			HttpOnly: true,
-			SameSite: http.SameSiteLaxMode,
-		})
+		http.SetCookie(w, sessionCookie(cfg, "", -1))
 		writeJSON(w, 200, map[string]string{"status": "ok"})
 	})
 
@@ -164,14 +235,48 @@ func main() {
 		writeJSON(w, 200, u)
 	})
 
+	// Re-reads the caller's account from auth and stores it in the session,
+	// so a changed room or role applies without logging out. It reads the
+	// session directly rather than through currentUser, whose periodic
+	// revalidation would end the session over exactly those changes.
+	r.Post("/api/me/refresh", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(sessionCookieName)
+		if err != nil || c.Value == "" {
+			writeErr(w, 401, "not logged in")
+			return
+		}
+		ss, ok := sessions.Get(c.Value)
+		if !ok {
+			writeErr(w, 401, "not logged in")
+			return
+		}
+		fresh, err := authC.GetUser(r.Context(), ss.User.ID)
+		var ae *authclient.AuthError
+		if errors.As(err, &ae) && ae.Status == http.StatusNotFound {
+			sessions.Delete(c.Value)
+			http.SetCookie(w, sessionCookie(cfg, "", -1))
+			writeErr(w, 401, "account no longer exists")
+			return
+		}
+		if err != nil {
+			writeAuthErr(w, err)
+			return
+		}
+		if _, ok := sessions.Update(c.Value, fresh); !ok {
+			writeErr(w, 401, "not logged in")
+			return
+		}
+		writeJSON(w, 200, fresh)
+	})
+
 	// SSE stream (admin + staff can open if logged in)
 	r.Get("/api/stream", func(w http.ResponseWriter, r *http.Request) {
-		_, ok := currentUser(r, sessions)
+		u, ok := currentUser(r, sessions)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		hub.SSEHandler()(w, r)
+		hub.SSEHandlerFor(u)(w, r)
 	})
 
 	// Pages (protected)
@@ -223,9 +328,35 @@ func main() {
 	})
 
 	// Ticket API (protected)
-	ticketAPI := tickets.NewAPI(logger, repo, mqttClient)
+	for _, t := range cfg.GuestTicketTypes {
+		if !tickets.IsValidType(t) {
+			logging.Fatal(logger, "GUEST_TICKET_TYPES: unknown ticket type", "type", t)
+		}
+	}
+	pub := mq.NewPublisher(mqttClient, outbox, logger)
+	pub.Legacy = cfg.MQTTLegacyEvents
+	ticketAPI := tickets.NewAPI(logger, repo, pub, authC, tickets.Options{
+		Upload: tickets.UploadConfig{
+			Dir:      cfg.AttachmentDir,
+			MaxBytes: cfg.AttachmentMaxBytes,
+		},
+		ChatEditWindow:    cfg.ChatEditWindow,
+		ChatMaxLen:        cfg.ChatMaxLen,
+		ChatCap:           tickets.ChatCap{Max: cfg.ChatMaxMessages, Policy: cfg.ChatCapPolicy},
+		DuplicatePolicy:   cfg.DuplicateTickets,
+		TypeChangeRouting: cfg.TypeChangeRouting,
+		GuestTypes:        cfg.GuestTicketTypes,
+		RoomRegistry:      cfg.RoomRegistry,
+		GuestCanReopen:    cfg.GuestCanReopen,
+		Departments:       cfg.Departments,
+	})
+
+	limiter := ratelimit.New(cfg.RateLimitWindow)
+	typingLimiter := ratelimit.New(cfg.ChatTypingWindow)
 
 	r.Route("/api", func(r chi.Router) {
+		r.Use(roleRateLimit(limiter, cfg, sessions))
+
 		r.Get("/tickets", func(w http.ResponseWriter, r *http.Request) {
 			u, ok := currentUser(r, sessions)
 			if !ok {
@@ -235,6 +366,33 @@ func main() {
 			ticketAPI.ListTicketsForUser(w, r, u)
 		})
 
+		r.Get("/tickets/mine", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ListMine(w, r, u)
+		})
+
+		r.Get("/tickets/department", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ListDepartmentQueue(w, r, u)
+		})
+
+		r.Get("/tickets/worked", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ListWorked(w, r, u)
+		})
+
 		r.Post("/tickets", func(w http.ResponseWriter, r *http.Request) {
 			u, ok := currentUser(r, sessions)
 			if !ok {
@@ -262,7 +420,94 @@ func main() {
 			ticketAPI.UpdateStatus(w, r, u)
 		})
 
+		// Guests correct the type or description of their own OPEN ticket.
+		r.Patch("/tickets/{id}", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.UpdateTicket(w, r, u)
+		})
+
+		r.Delete("/tickets/{id}", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.Delete(w, r, u)
+		})
+
+		r.Post("/tickets/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.Cancel(w, r, u)
+		})
+
+		r.Post("/tickets/{id}/reopen", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.Reopen(w, r, u)
+		})
+
+		// Caller's own audit trail
+		r.Get("/me/activity", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ListMyActivity(w, r, u)
+		})
+
+		// Attachments: external links or uploaded photos
+		r.Get("/tickets/{id}/attachments", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ListAttachments(w, r, u)
+		})
+
+		// Photo uploads get their own body cap plus room for multipart framing.
+		r.With(httpjson.WithBodyLimit(cfg.AttachmentMaxBytes+64<<10)).Post("/tickets/{id}/attachments", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.AddAttachment(w, r, u)
+		})
+
+		r.Get("/tickets/{id}/attachments/{name}", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.GetAttachmentFile(w, r, u)
+		})
+
 		// ✅ Chat (Option A)
+		// Inbox: guests get the chat of the tickets they created, staff of
+		// their assigned tickets. Admins use /admin/chat.
+		r.Get("/chat/mine", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ListChatInbox(w, r, u)
+		})
+
 		r.Get("/tickets/{id}/chat", func(w http.ResponseWriter, r *http.Request) {
 			u, ok := currentUser(r, sessions)
 			if !ok {
@@ -281,6 +526,47 @@ func main() {
 			ticketAPI.SendChat(w, r, u)
 		})
 
+		// Typing indicators, rate-limited per user
+		r.Post("/tickets/{id}/chat/typing", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			if key := "user:" + strconv.FormatInt(u.ID, 10); !typingLimiter.Allow(key, cfg.ChatTypingLimit) {
+				tooManyRequests(w, typingLimiter, key, "typing updates too frequent")
+				return
+			}
+			ticketAPI.ChatTyping(w, r, u)
+		})
+
+		r.Post("/tickets/{id}/chat/read", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.MarkChatRead(w, r, u)
+		})
+
+		r.Patch("/tickets/{id}/chat/{msgId}", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.EditChat(w, r, u)
+		})
+
+		r.Delete("/tickets/{id}/chat/{msgId}", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.DeleteChat(w, r, u)
+		})
+
 		// Admin-only assign
 		r.Patch("/tickets/{id}/assign", func(w http.ResponseWriter, r *http.Request) {
 			u, ok := currentUser(r, sessions)
@@ -288,59 +574,304 @@ func main() {
 				writeErr(w, 401, "unauthorized")
 				return
 			}
-			if u.Role != authclient.RoleAdmin {
-				writeErr(w, 403, "admin only")
+			ticketAPI.Assign(w, r, u)
+		})
+
+		r.Patch("/tickets/{id}/department", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
 				return
 			}
+			ticketAPI.AssignDepartment(w, r, u)
+		})
 
-			var req struct {
-				StaffUserID int64 `json:"staff_user_id"`
+		r.Post("/tickets/{id}/claim", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
 			}
-			if err := jsonDecode(r, &req); err != nil || req.StaffUserID <= 0 {
-				writeErr(w, 400, "invalid json/staff_user_id")
+			ticketAPI.Claim(w, r, u)
+		})
+
+		r.Get("/admin/tickets", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
 				return
 			}
+			ticketAPI.ListAllTickets(w, r, u)
+		})
 
-			staff, err := authC.ListUsersByRole(authclient.RoleStaff)
-			if err != nil {
-				writeErr(w, 502, "auth service unavailable")
+		// Every ticket's chat, newest first.
+		r.Get("/admin/chat", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
 				return
 			}
-			var assignedTo *authclient.User
-			for _, s := range staff {
-				if s.ID == req.StaffUserID {
-					tmp := s
-					assignedTo = &tmp
-					break
-				}
+			ticketAPI.ListAllChats(w, r, u)
+		})
+
+		r.Get("/admin/tickets/unassigned", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
 			}
-			if assignedTo == nil {
-				writeErr(w, 400, "staff user not found")
+			ticketAPI.ListUnassignedTickets(w, r, u)
+		})
+
+		r.Get("/admin/tickets.csv", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
 				return
 			}
+			ticketAPI.ExportCSV(w, r, u)
+		})
 
-			r.Body.Close()
+		// Account list for audits. Rows are written as they're decoded from
+		// the auth service; password hashes never leave it.
+		r.Get("/admin/users.csv", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok || u.Role != authclient.RoleAdmin {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
 
-			assignedTicket, err := repo.Assign(r.Context(), mustParseID(chi.URLParam(r, "id")), req.StaffUserID)
-			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					writeErr(w, 404, "not found")
+			// Headers wait for the first row so an auth failure can still
+			// be reported as a JSON error.
+			cw := csv.NewWriter(w)
+			started := false
+			start := func() {
+				if started {
 					return
 				}
-				writeErr(w, 500, "db error")
+				started = true
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+				_ = cw.Write([]string{"id", "username", "role", "room", "created_at"})
+			}
+			n := 0
+			err := authC.EachUser(r.Context(), func(au authclient.User) error {
+				start()
+				if err := cw.Write([]string{
+					strconv.FormatInt(au.ID, 10),
+					au.Username,
+					au.Role,
+					au.Room,
+					au.CreatedAt.Format(time.RFC3339),
+				}); err != nil {
+					return err
+				}
+				n++
+				if n%500 == 0 {
+					cw.Flush()
+				}
+				return cw.Error()
+			})
+			if err != nil && !started {
+				writeAuthErr(w, err)
+				return
+			}
+			start()
+			cw.Flush()
+			if err != nil {
+				logger.Error("users export", "err", err, "rows", n)
+			}
+		})
+
+		r.Get("/admin/rooms", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ListRooms(w, r, u)
+		})
+
+		r.Post("/admin/rooms", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.AddRoom(w, r, u)
+		})
+
+		r.Delete("/admin/rooms/{room}", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.DeleteRoom(w, r, u)
+		})
+
+		r.Get("/admin/metrics/resolution", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ResolutionMetrics(w, r, u)
+		})
+
+		r.Get("/admin/rooms/summary", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.RoomsSummary(w, r, u)
+		})
+
+		r.Get("/admin/rooms/{room}/tickets", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.ListRoomTickets(w, r, u)
+		})
+
+		r.Post("/admin/tickets", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			ticketAPI.CreateTicketAsAdmin(w, r, u)
+		})
+
+		r.Post("/admin/tickets/auto-assign-unassigned", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok || u.Role != authclient.RoleAdmin {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			staff, err := authC.ListUsersByRole(r.Context(), authclient.RoleStaff)
+			if err != nil {
+				writeErr(w, 502, "auth service unavailable")
 				return
 			}
+			ticketAPI.AutoAssignUnassigned(w, r, u, staff)
+		})
 
-			payload := tickets.EventPayload{
-				Event:      "assigned",
-				Ticket:     assignedTicket,
-				AssignedTo: assignedTo,
+		r.Get("/admin/sse/stats", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok || u.Role != authclient.RoleAdmin {
+				writeErr(w, 401, "unauthorized")
+				return
 			}
-			publishMQTT(logger, mqttClient, mq.TopicTicketAssigned, payload)
-			writeJSON(w, 200, assignedTicket)
+			writeJSON(w, 200, hub.Stats())
+		})
+
+		// Staff with an open event stream, for picking an assignee who is
+		// around. Connections counts streams (tabs), not just online/offline.
+		r.Get("/admin/presence", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok || u.Role != authclient.RoleAdmin {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			staff := []sse.Presence{}
+			ids := []int64{}
+			for _, p := range hub.Online() {
+				if p.Role == authclient.RoleStaff {
+					staff = append(staff, p)
+					ids = append(ids, p.UserID)
+				}
+			}
+			writeJSON(w, 200, map[string]any{"staff_ids": ids, "staff": staff})
 		})
 
 		// Admin-only user management
+		// Paged account listing, passed through to the auth service, which
+		// validates the query: ?role=&department=&q=&from=&to=&limit=&offset=
+		r.Get("/admin/users", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok || u.Role != authclient.RoleAdmin {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			qv := r.URL.Query()
+			opts := authclient.ListUsersOptions{Role: qv.Get("role"), Department: qv.Get("department"), Query: qv.Get("q")}
+			for _, p := range []struct {
+				name string
+				dst  *int
+			}{{"limit", &opts.Limit}, {"offset", &opts.Offset}} {
+				if raw := qv.Get(p.name); raw != "" {
+					n, err := strconv.Atoi(raw)
+					if err != nil || n < 0 {
+						writeErr(w, 400, "invalid "+p.name)
+						return
+					}
+					*p.dst = n
+				}
+			}
+			for _, p := range []struct {
+				name string
+				dst  *time.Time
+			}{{"from", &opts.From}, {"to", &opts.To}} {
+				if raw := qv.Get(p.name); raw != "" {
+					t, err := time.Parse(time.RFC3339, raw)
+					if err != nil {
+						writeErr(w, 400, "invalid "+p.name+" (RFC3339)")
+						return
+					}
+					*p.dst = t
+				}
+			}
+			out, err := authC.ListUsers(r.Context(), opts)
+			if err != nil {
+				writeAuthErr(w, err)
+				return
+			}
+			if out.Users == nil {
+				out.Users = []authclient.User{}
+			}
+			writeJSON(w, 200, map[string]any{
+				"users":  out.Users,
+				"total":  out.Total,
+				"limit":  opts.Limit,
+				"offset": opts.Offset,
+			})
+		})
+
+		// Changes a user's role ({role, room}; room only for GUEST).
+		r.Patch("/admin/users/{id}", changeUserRole(sessions, authC, ticketAPI, logger))
+
+		// Moves a staff member between departments; open sessions pick it
+		// up at their next revalidation (SESSION_REVALIDATE_INTERVAL).
+		r.Put("/admin/users/{id}/department", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok || u.Role != authclient.RoleAdmin {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			if err != nil || id <= 0 {
+				writeErr(w, 400, "invalid id")
+				return
+			}
+			var req struct {
+				Department string `json:"department"`
+			}
+			if err := httpjson.Decode(r, &req, true); err != nil {
+				writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+				return
+			}
+			updated, err := authC.SetDepartment(r.Context(), id, req.Department)
+			if err != nil {
+				writeAuthErr(w, err)
+				return
+			}
+			writeJSON(w, 200, map[string]any{"user": updated})
+		})
+
 		r.Post("/admin/users", func(w http.ResponseWriter, r *http.Request) {
 			u, ok := currentUser(r, sessions)
 			if !ok || u.Role != authclient.RoleAdmin {
@@ -348,43 +879,166 @@ func main() {
 				return
 			}
 			var req authclient.CreateUserRequest
-			if err := jsonDecode(r, &req); err != nil {
-				writeErr(w, 400, "invalid json")
+			if err := httpjson.Decode(r, &req, true); err != nil {
+				writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
 				return
 			}
+			req.Username = strings.TrimSpace(req.Username)
 			if req.Username == "" || req.Password == "" {
-				writeErr(w, 400, "username and password required")
+				writeErrCode(w, 400, authclient.CodeCredentialsRequired, "username and password required")
 				return
 			}
 			if req.Role != authclient.RoleGuest && req.Role != authclient.RoleStaff && req.Role != authclient.RoleAdmin {
-				writeErr(w, 400, "invalid role")
+				writeErrCode(w, 400, authclient.CodeInvalidRole, "invalid role")
 				return
 			}
 			if req.Role == authclient.RoleGuest && req.Room == "" {
-				writeErr(w, 400, "room required for GUEST")
+				writeErrCode(w, 400, authclient.CodeRoomRequired, "room required for GUEST")
 				return
 			}
+			if req.Role == authclient.RoleGuest {
+				known, err := ticketAPI.KnownRoom(r.Context(), req.Room)
+				if err != nil {
+					writeErr(w, 500, "db error")
+					return
+				}
+				if !known {
+					writeErrCode(w, 400, authclient.CodeUnknownRoom, "unknown room")
+					return
+				}
+			}
 
-			created, err := authC.CreateUser(req)
+			created, err := authC.CreateUser(r.Context(), req)
 			if err != nil {
-				writeErr(w, 400, "could not create user (maybe username exists)")
+				writeAuthErr(w, err)
 				return
 			}
 			writeJSON(w, 201, map[string]any{"user": created})
 		})
 
+		// Admin-only bulk import, answered with the shared bulk envelope:
+		// succeeded holds the new user IDs, failed the rejected rows by
+		// index, whether the gateway (unknown room) or the auth service
+		// turned them down. users lists the created accounts.
+		r.Post("/admin/users/bulk", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok || u.Role != authclient.RoleAdmin {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			var reqs []authclient.CreateUserRequest
+			if err := httpjson.Decode(r, &reqs, true); err != nil {
+				writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+				return
+			}
+			if len(reqs) == 0 {
+				writeErr(w, 400, "no users")
+				return
+			}
+			if len(reqs) > authclient.MaxBulkUsers {
+				writeErr(w, 400, "too many users (max "+strconv.Itoa(authclient.MaxBulkUsers)+")")
+				return
+			}
+
+			results := make([]authclient.BulkUserResult, len(reqs))
+			var forward []authclient.CreateUserRequest
+			var forwardIdx []int
+			for i, req := range reqs {
+				results[i].Index = i
+				req.Username = strings.TrimSpace(req.Username)
+				if req.Role == authclient.RoleGuest && req.Room != "" {
+					known, err := ticketAPI.KnownRoom(r.Context(), req.Room)
+					if err != nil {
+						writeErr(w, 500, "db error")
+						return
+					}
+					if !known {
+						results[i].Code, results[i].Error = authclient.CodeUnknownRoom, "unknown room"
+						continue
+					}
+				}
+				forward = append(forward, req)
+				forwardIdx = append(forwardIdx, i)
+			}
+
+			if len(forward) > 0 {
+				out, err := authC.BulkCreateUsers(r.Context(), forward)
+				if err != nil {
+					writeAuthErr(w, err)
+					return
+				}
+				for _, res := range out.Results {
+					if res.Index < 0 || res.Index >= len(forwardIdx) {
+						continue
+					}
+					res.Index = forwardIdx[res.Index]
+					results[res.Index] = res
+				}
+			}
+
+			out := bulk.New()
+			users := []authclient.User{}
+			for i, res := range results {
+				if res.OK && res.User != nil {
+					out.OK(res.User.ID)
+					users = append(users, *res.User)
+					continue
+				}
+				out.Fail(bulk.Item{Index: bulk.At(i), Code: res.Code, Error: res.Error})
+			}
+			writeJSON(w, out.Status(), struct {
+				*bulk.Result
+				Users []authclient.User `json:"users"`
+			}{out, users})
+		})
+
+		r.Get("/admin/staff/{id}/summary", func(w http.ResponseWriter, r *http.Request) {
+			u, ok := currentUser(r, sessions)
+			if !ok || u.Role != authclient.RoleAdmin {
+				writeErr(w, 401, "unauthorized")
+				return
+			}
+			id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			if err != nil || id <= 0 {
+				writeErr(w, 400, "invalid id")
+				return
+			}
+			staff, err := authC.GetUser(r.Context(), id)
+			if err != nil {
+				writeAuthErr(w, err)
+				return
+			}
+			if staff.Role != authclient.RoleStaff {
+				writeErr(w, 404, "not a staff user")
+				return
+			}
+			ticketAPI.StaffSummary(w, r, u, staff)
+		})
+
+		// ?department= (or ?ticket_type=, whose staff share its name, e.g.
+		// plumbing tickets go to the plumbing department) narrows the list.
 		r.Get("/admin/staff", func(w http.ResponseWriter, r *http.Request) {
 			u, ok := currentUser(r, sessions)
 			if !ok || u.Role != authclient.RoleAdmin {
 				writeErr(w, 401, "unauthorized")
 				return
 			}
-			staff, err := authC.ListUsersByRole(authclient.RoleStaff)
+			dept := strings.TrimSpace(r.URL.Query().Get("department"))
+			if dept == "" {
+				dept = strings.TrimSpace(r.URL.Query().Get("ticket_type"))
+			}
+			var staff []authclient.User
+			var err error
+			if dept != "" {
+				staff, err = authC.ListUsersByRoleAndDepartment(r.Context(), authclient.RoleStaff, dept)
+			} else {
+				staff, err = authC.ListUsersByRole(r.Context(), authclient.RoleStaff)
+			}
 			if err != nil {
 				writeErr(w, 502, "auth service unavailable")
 				return
 			}
-			writeJSON(w, 200, map[string]any{"users": staff})
+			ticketAPI.ListStaffWorkload(w, r, u, staff)
 		})
 	})
 
@@ -393,44 +1047,256 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
+	if err := waitForDependencies(ctx, cfg.StartupWaitTimeout, authC, mqttClient, logger); err != nil {
+		if cfg.StartupFailFast {
+			logging.Fatal(logger, "dependencies not ready", "err", err)
+		}
+		logger.Error("dependencies not ready; starting degraded", "err", err)
+	}
+
+	go ticketAPI.RunOverdueWatcher(ctx, cfg.TicketSLA, cfg.OverdueScanInterval)
+	if cfg.TicketRetention > 0 {
+		go ticketAPI.RunPurge(ctx, cfg.TicketRetention, cfg.PurgeInterval)
+	}
+	go limiter.RunCleanup(ctx, cfg.RateLimitWindow)
+	go loginLimiter.RunCleanup(ctx, cfg.LoginRateWindow)
+	go typingLimiter.RunCleanup(ctx, cfg.ChatTypingWindow)
+
 	go func() {
-		logger.Printf("listening on %s (db=%s, mqtt=%s, auth=%s)", cfg.Addr, cfg.DBPath, cfg.MQTTBroker, cfg.AuthServiceURL)
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Fatalf("listen: %v", err)
+		logger.Info("listening", "addr", cfg.Addr, "tls", cfg.TLSEnabled(), "db", cfg.DBPath, "mqtt", cfg.MQTTBroker, "auth", cfg.AuthServiceURL, "version", build.Version, "commit", build.Commit, "build_time", build.BuildTime)
+		var err error
+		if cfg.TLSEnabled() {
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal(logger, "listen", "err", err)
 		}
 	}()
 
 	<-ctx.Done()
+	// End SSE streams first; Shutdown would otherwise wait on them.
+	hub.Close()
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
 	_ = srv.Shutdown(shutdownCtx)
+
+	// Let publishes started by the last requests finish before the deferred
+	// Disconnect closes the connection under them.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.MQTTDrainTimeout)
+	defer cancelDrain()
+	if err := pub.Drain(drainCtx); err != nil {
+		logger.Warn("mqtt drain incomplete", "err", err)
+	}
 }
 
-// ✅ Now includes Chat wildcard AND sends SSE envelope {topic,payload}
-func subscribeAndBridge(logger *log.Logger, c mqtt.Client, hub *sse.Hub) {
+// waitForDependencies polls the auth service and the broker until both
+// answer or timeout passes, so logins right after a cold start don't fail
+// with 502s while auth is still booting. A zero timeout skips the wait.
+func waitForDependencies(ctx context.Context, timeout time.Duration, authC *authclient.Client, mqttClient mqtt.Client, logger *slog.Logger) error {
+	if timeout <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	check := func() error {
+		if !mqttClient.IsConnected() {
+			return mq.ErrNotConnected
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		if err := authC.Ping(pingCtx); err != nil {
+			return fmt.Errorf("auth service: %w", err)
+		}
+		return nil
+	}
+
+	start := time.Now()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for logged := false; ; {
+		err := check()
+		if err == nil {
+			if logged {
+				logger.Info("dependencies ready", "waited", time.Since(start).Round(time.Millisecond))
+			}
+			return nil
+		}
+		if !logged {
+			logger.Info("waiting for dependencies", "err", err, "timeout", timeout)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-tick.C:
+		}
+	}
+}
+
+// ✅ Now includes Chat wildcard AND sends SSE envelope {topic,type,payload}
+// Chat envelopes also carry ticket_id so clients can route to the right
+// conversation.
+func bridgeSubscriptions(hub *sse.Hub, repo *tickets.Repository) []mq.Subscription {
 	topics := []string{
 		mq.TopicTicketCreated,
 		mq.TopicTicketStatusUpdated,
 		mq.TopicTicketAssigned,
+		mq.TopicTicketOverdue,
+		mq.TopicTicketCancelled,
+		mq.TopicTicketDeleted,
+		mq.TopicTicketReassignSuggested,
 		mq.TopicChatTicketWildcard, // ✅ chat
 	}
 
+	// Browsers get the envelope's data as "payload" whichever format the
+	// publisher used, so they never need to know about mq.Envelope.
+	handler := func(_ mqtt.Client, msg mqtt.Message) {
+		ev, err := mq.Decode(msg.Payload())
+		if err != nil {
+			return
+		}
+		env := map[string]any{
+			"topic":   msg.Topic(),
+			"type":    ev.Type,
+			"payload": json.RawMessage(append([]byte(nil), ev.Data...)),
+		}
+		meta := eventMeta(ev.Data)
+		if msg.Topic() == mq.TopicTicketReassignSuggested {
+			// Suggestions are for admins to act on.
+			meta = sse.Meta{}
+		}
+		if strings.HasPrefix(msg.Topic(), mq.TopicChatTicketPrefix) {
+			ticketID, m := chatMeta(repo, msg.Topic())
+			env["ticket_id"] = ticketID
+			meta = m
+			if ev.Type == tickets.ChatEventTyping {
+				meta.SenderUserID = typingSender(ev.Data)
+				meta.Ephemeral = true
+			}
+		}
+		b, _ := json.Marshal(env)
+		hub.Broadcast(b, meta)
+	}
+
+	subs := make([]mq.Subscription, 0, len(topics))
 	for _, topic := range topics {
-		topic := topic
-		token := c.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
-			env := map[string]any{
-				"topic":   msg.Topic(),
-				"payload": json.RawMessage(append([]byte(nil), msg.Payload()...)),
-			}
-			b, _ := json.Marshal(env)
-			hub.Broadcast(b)
-		})
-		token.Wait()
-		if err := token.Error(); err != nil {
-			logger.Printf("mqtt subscribe error topic=%s: %v", topic, err)
-		} else {
-			logger.Printf("mqtt subscribed topic=%s", topic)
+		subs = append(subs, mq.Subscription{Topic: topic, QoS: 1, Handler: handler, Critical: true})
+	}
+	return subs
+}
+
+// eventMeta pulls the ticket's room, assignee, department and creator out of
+// an EventPayload so the SSE hub can filter per connection.
+func eventMeta(payload []byte) sse.Meta {
+	var p struct {
+		Ticket struct {
+			Room               string `json:"room"`
+			AssignedToUserID   *int64 `json:"assigned_to_user_id"`
+			AssignedDepartment string `json:"assigned_department"`
+			CreatedByUserID    int64  `json:"created_by_user_id"`
+		} `json:"ticket"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return sse.Meta{}
+	}
+	m := sse.Meta{Room: p.Ticket.Room, Department: p.Ticket.AssignedDepartment, CreatedByUserID: p.Ticket.CreatedByUserID}
+	if p.Ticket.AssignedToUserID != nil {
+		m.AssignedToUserID = *p.Ticket.AssignedToUserID
+	}
+	return m
+}
+
+// chatMeta resolves the ticket behind a chat topic. Chat is visible to
+// admins, the assigned staffer and the guest who raised the ticket, so the
+// room and department are left empty on purpose.
+func chatMeta(repo *tickets.Repository, topic string) (int64, sse.Meta) {
+	ticketID, err := strconv.ParseInt(strings.TrimPrefix(topic, mq.TopicChatTicketPrefix), 10, 64)
+	if err != nil {
+		return 0, sse.Meta{}
+	}
+	t, err := repo.Get(context.Background(), ticketID)
+	if err != nil {
+		return ticketID, sse.Meta{}
+	}
+	m := sse.Meta{CreatedByUserID: t.CreatedByUserID}
+	if t.AssignedToUserID != nil {
+		m.AssignedToUserID = *t.AssignedToUserID
+	}
+	return ticketID, m
+}
+
+// typingSender is the user a chat_typing event came from.
+func typingSender(payload []byte) int64 {
+	var p struct {
+		FromUserID int64 `json:"from_user_id"`
+	}
+	_ = json.Unmarshal(payload, &p)
+	return p.FromUserID
+}
+
+// changeUserRole serves PATCH /admin/users/{id}. The user's sessions are
+// ended since role and room are baked into them.
+func changeUserRole(sessions *session.Store, authC *authclient.Client, ticketAPI *tickets.API, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := currentUser(r, sessions)
+		if !ok || u.Role != authclient.RoleAdmin {
+			writeErr(w, 401, "unauthorized")
+			return
 		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeErr(w, 400, "invalid id")
+			return
+		}
+		var req authclient.UpdateRoleRequest
+		if err := httpjson.Decode(r, &req, true); err != nil {
+			writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+			return
+		}
+		req.Room = strings.TrimSpace(req.Room)
+		if req.Role == authclient.RoleGuest && req.Room != "" {
+			known, err := ticketAPI.KnownRoom(r.Context(), req.Room)
+			if err != nil {
+				writeErr(w, 500, "db error")
+				return
+			}
+			if !known {
+				writeErrCode(w, 400, authclient.CodeUnknownRoom, "unknown room")
+				return
+			}
+		}
+		updated, err := authC.UpdateRole(r.Context(), id, req)
+		if err != nil {
+			writeAuthErr(w, err)
+			return
+		}
+		ended := sessions.DeleteUser(id)
+		logger.Info("user role changed", "user_id", id, "role", updated.Role, "by", u.ID, "sessions_ended", ended)
+		writeJSON(w, 200, map[string]any{"user": updated, "sessions_ended": ended})
+	}
+}
+
+// sessionCookie builds the session cookie with the configured Secure and
+// SameSite attributes; maxAge -1 clears it.
+func sessionCookie(cfg config.GatewayConfig, value string, maxAge int) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(cfg.CookieSameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+		SameSite: sameSite,
 	}
 }
 
@@ -439,17 +1305,97 @@ func currentUser(r *http.Request, store *session.Store) (authclient.User, bool)
 	if err != nil || c.Value == "" {
 		return authclient.User{}, false
 	}
-	ss, ok := store.Get(c.Value)
+	ss, ok := store.Lookup(r.Context(), c.Value)
 	if !ok {
 		return authclient.User{}, false
 	}
 	return ss.User, true
 }
 
+// revalidateUser checks a session's user against the auth service. A
+// deleted account, or one whose role or room changed, ends the session, so
+// a guest who moved rooms logs in again rather than seeing the old room's
+// tickets. Other changes, such as a staff department, are picked up in
+// place. If auth can't be reached the session carries on unchanged.
+func revalidateUser(authC *authclient.Client, logger *slog.Logger) session.Revalidator {
+	return func(ctx context.Context, u authclient.User) (authclient.User, bool, error) {
+		fresh, err := authC.GetUser(ctx, u.ID)
+		var ae *authclient.AuthError
+		if errors.As(err, &ae) && ae.Status == http.StatusNotFound {
+			logger.Info("session ended: account deleted", "user_id", u.ID)
+			return authclient.User{}, false, nil
+		}
+		if err != nil {
+			logger.Warn("session revalidation failed", "user_id", u.ID, "err", err)
+			return u, true, err
+		}
+		if fresh.Role != u.Role || fresh.Room != u.Room {
+			logger.Info("session ended: account changed", "user_id", u.ID, "role", fresh.Role, "room", fresh.Room)
+			return authclient.User{}, false, nil
+		}
+		return fresh, true, nil
+	}
+}
+
+// roleRateLimit throttles per user with a limit chosen by role once the
+// session is resolved, and per client IP for unauthenticated requests.
+func roleRateLimit(l *ratelimit.Limiter, cfg config.GatewayConfig, store *session.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, limit := "ip:"+clientIP(r), cfg.RateLimitAnon
+			if u, ok := currentUser(r, store); ok {
+				key = "user:" + strconv.FormatInt(u.ID, 10)
+				switch u.Role {
+				case authclient.RoleGuest:
+					limit = cfg.RateLimitGuest
+				case authclient.RoleStaff:
+					limit = cfg.RateLimitStaff
+				case authclient.RoleAdmin:
+					limit = cfg.RateLimitAdmin
+				}
+			}
+			if !l.Allow(key, limit) {
+				tooManyRequests(w, l, key, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ipRateLimit throttles every request by client IP.
+func ipRateLimit(l *ratelimit.Limiter, limit int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := clientIP(r); !l.Allow(key, limit) {
+				tooManyRequests(w, l, key, "too many attempts, try again later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tooManyRequests answers 429 with a Retry-After, in whole seconds, for
+// when key's window reopens.
+func tooManyRequests(w http.ResponseWriter, l *ratelimit.Limiter, key, msg string) {
+	secs := int(math.Ceil(l.RetryAfter(key).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	writeErr(w, http.StatusTooManyRequests, msg)
+}
+
+// clientIP is the address set by middleware.RealIP, without the port that a
+// direct connection's RemoteAddr carries.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 // helpers
 func jsonDecode(r *http.Request, v any) error {
-	defer r.Body.Close()
-	return json.NewDecoder(r.Body).Decode(v)
+	return httpjson.Decode(r, v, false)
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -462,24 +1408,30 @@ func writeErr(w http.ResponseWriter, status int, msg string) {
 	writeJSON(w, status, map[string]string{"error": msg})
 }
 
-func mustParseID(s string) int64 {
-	id, _ := strconv.ParseInt(s, 10, 64)
-	return id
+// writeErrCode adds a machine-readable code; see authclient.Code*.
+func writeErrCode(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg, "code": code})
 }
 
-func publishMQTT(logger *log.Logger, c mqtt.Client, topic string, payload any) {
-	if c == nil || !c.IsConnected() {
-		logger.Printf("mqtt not connected; skipping publish topic=%s", topic)
+// writeAuthErr propagates a client error (4xx) from the auth service as-is
+// and reports anything else as the auth service being unavailable.
+func writeAuthErr(w http.ResponseWriter, err error) {
+	var ae *authclient.AuthError
+	if errors.As(err, &ae) && ae.Status >= 400 && ae.Status < 500 {
+		msg := ae.Message
+		if msg == "" {
+			msg = http.StatusText(ae.Status)
+		}
+		if ae.Code != "" {
+			writeErrCode(w, ae.Status, ae.Code, msg)
+			return
+		}
+		writeErr(w, ae.Status, msg)
 		return
 	}
-	b, err := json.Marshal(payload)
-	if err != nil {
-		logger.Printf("marshal event: %v", err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeErr(w, 504, "auth service timed out")
 		return
 	}
-	tok := c.Publish(topic, 1, false, b)
-	tok.WaitTimeout(3 * time.Second)
-	if err := tok.Error(); err != nil {
-		logger.Printf("publish error topic=%s: %v", topic, err)
-	}
+	writeErr(w, 502, "auth service unavailable")
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"src/internal/authclient"
+	"src/internal/config"
+	"src/internal/ratelimit"
+	"src/internal/session"
+)
+
+func TestRoleRateLimit(t *testing.T) {
+	cfg := config.GatewayConfig{RateLimitGuest: 2, RateLimitStaff: 3, RateLimitAdmin: 0, RateLimitAnon: 1}
+	store := session.NewStore(time.Hour)
+	l := ratelimit.New(time.Minute)
+	h := roleRateLimit(l, cfg, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cookieFor := func(u authclient.User) *http.Cookie {
+		ss, err := store.Create(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Cookie{Name: sessionCookieName, Value: ss.ID}
+	}
+	guest := cookieFor(authclient.User{ID: 1, Role: authclient.RoleGuest, Room: "101"})
+	staff := cookieFor(authclient.User{ID: 2, Role: authclient.RoleStaff})
+	admin := cookieFor(authclient.User{ID: 3, Role: authclient.RoleAdmin})
+
+	do := func(c *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/tickets", nil)
+		if c != nil {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Guests get RateLimitGuest requests, then 429 with a Retry-After.
+	for i := 0; i < cfg.RateLimitGuest; i++ {
+		if w := do(guest); w.Code != http.StatusNoContent {
+			t.Fatalf("guest request %d: status %d", i+1, w.Code)
+		}
+	}
+	w := do(guest)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("guest over limit: status %d, want 429", w.Code)
+	}
+	secs, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || secs < 1 || secs > 60 {
+		t.Fatalf("Retry-After = %q, want 1-60 seconds", w.Header().Get("Retry-After"))
+	}
+
+	// Staff have their own, larger budget, untouched by the guest's.
+	for i := 0; i < cfg.RateLimitStaff; i++ {
+		if w := do(staff); w.Code != http.StatusNoContent {
+			t.Fatalf("staff request %d: status %d", i+1, w.Code)
+		}
+	}
+	if w := do(staff); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("staff over limit: status %d, want 429", w.Code)
+	}
+
+	// A zero admin limit means unlimited.
+	for i := 0; i < 20; i++ {
+		if w := do(admin); w.Code != http.StatusNoContent {
+			t.Fatalf("admin request %d: status %d", i+1, w.Code)
+		}
+	}
+
+	// Anonymous requests are limited by client IP.
+	if w := do(nil); w.Code != http.StatusNoContent {
+		t.Fatalf("anonymous request: status %d", w.Code)
+	}
+	if w := do(nil); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("anonymous over limit: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
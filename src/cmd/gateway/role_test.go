@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"src/internal/authclient"
+	"src/internal/session"
+	"src/internal/tickets"
+)
+
+func TestChangeUserRoleEndsSessions(t *testing.T) {
+	// The auth service promotes user 5 and refuses to demote user 9, the
+	// last admin.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPatch && r.URL.Path == "/api/users/5":
+			_ = json.NewEncoder(w).Encode(authclient.GetUserResponse{User: authclient.User{ID: 5, Username: "sam", Role: authclient.RoleStaff}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/api/users/9":
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "cannot demote the last admin", "code": "last_admin"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	authC := authclient.New(srv.URL, "test-key", authclient.Options{})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ticketAPI := tickets.NewAPI(logger, nil, nil, nil, tickets.Options{})
+
+	store := session.NewStore(time.Hour)
+	create := func(u authclient.User) string {
+		ss, err := store.Create(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ss.ID
+	}
+	admin := create(authclient.User{ID: 1, Role: authclient.RoleAdmin})
+	target := []string{
+		create(authclient.User{ID: 5, Role: authclient.RoleGuest, Room: "101"}),
+		create(authclient.User{ID: 5, Role: authclient.RoleGuest, Room: "101"}),
+	}
+	other := create(authclient.User{ID: 9, Role: authclient.RoleAdmin})
+
+	h := changeUserRole(store, authC, ticketAPI, logger)
+	do := func(id, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, "/api/admin/users/"+id, strings.NewReader(body))
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: admin})
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	// A refused change passes the auth service's code through and leaves
+	// the user signed in.
+	w := do("9", `{"role":"STAFF"}`)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"last_admin"`) {
+		t.Fatalf("last admin: status %d: %s", w.Code, w.Body)
+	}
+	if _, ok := store.Get(other); !ok {
+		t.Error("refused change ended the user's session")
+	}
+
+	w = do("5", `{"role":"STAFF"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		User          authclient.User `json:"user"`
+		SessionsEnded int             `json:"sessions_ended"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.User.Role != authclient.RoleStaff || body.SessionsEnded != 2 {
+		t.Errorf("response = %+v, want STAFF with 2 sessions ended", body)
+	}
+	for _, id := range target {
+		if _, ok := store.Get(id); ok {
+			t.Error("changed user's session still valid")
+		}
+	}
+	for _, id := range []string{admin, other} {
+		if _, ok := store.Get(id); !ok {
+			t.Error("another user's session was ended")
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"src/internal/mq"
+)
+
+// Webhook delivery modes.
+const (
+	WebhookModeImmediate = "immediate"
+	WebhookModeDigest    = "digest"
+)
+
+// DigestRecord is the summary POSTed in digest mode in place of the events
+// it covers.
+type DigestRecord struct {
+	Type   string         `json:"type"` // always "digest"
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Events int            `json:"events"`
+	Counts map[string]int `json:"counts"`
+	Rooms  []string       `json:"rooms,omitempty"`
+	Text   string         `json:"text"`
+}
+
+// Digest batches events and sends one summary per interval to the webhooks,
+// or sooner once maxEvents are waiting. It is safe for concurrent use.
+type Digest struct {
+	hooks     *Webhooks
+	interval  time.Duration
+	maxEvents int
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	pending []EventRecord
+	flushCh chan struct{}
+}
+
+func NewDigest(hooks *Webhooks, interval time.Duration, maxEvents int, logger *slog.Logger) *Digest {
+	if maxEvents <= 0 {
+		maxEvents = 100
+	}
+	return &Digest{hooks: hooks, interval: interval, maxEvents: maxEvents, logger: logger, flushCh: make(chan struct{}, 1)}
+}
+
+// Add queues e for the next summary, asking Run to flush early when the
+// batch is full.
+func (d *Digest) Add(e EventRecord) {
+	d.mu.Lock()
+	d.pending = append(d.pending, e)
+	full := len(d.pending) >= d.maxEvents
+	d.mu.Unlock()
+	if full {
+		select {
+		case d.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run sends a summary every interval, and whenever Add fills the batch,
+// until ctx ends; what is still queued then is sent on the way out.
+func (d *Digest) Run(ctx context.Context) {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), d.hooks.timeout)
+			d.flush(shutdownCtx)
+			cancel()
+			return
+		case <-t.C:
+			d.flush(ctx)
+		case <-d.flushCh:
+			d.flush(ctx)
+			t.Reset(d.interval)
+		}
+	}
+}
+
+func (d *Digest) flush(ctx context.Context) {
+	d.mu.Lock()
+	batch := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	sum := summarize(batch)
+	delivered, failed := d.hooks.send(ctx, "digest", sum)
+	d.logger.Info("digest sent", "events", sum.Events, "delivered", delivered, "failed", failed)
+}
+
+// digestLabels names each topic in the summary text, in display order.
+var digestLabels = []struct{ key, label string }{
+	{"created", "new tickets"},
+	{"resolved", "resolved"},
+	{"status_updated", "status changes"},
+	{"assigned", "assignments"},
+	{"reassign_suggested", "reassignment suggestions"},
+	{"overdue", "overdue"},
+	{"cancelled", "cancelled"},
+	{"deleted", "deleted"},
+	{"chat", "chat messages"},
+	{"other", "other events"},
+}
+
+// summarize counts batch by kind and collects the rooms involved. A status
+// update to RESOLVED counts as "resolved" rather than "status_updated".
+func summarize(batch []EventRecord) DigestRecord {
+	sum := DigestRecord{
+		Type:   "digest",
+		From:   batch[0].ReceivedAt,
+		To:     batch[len(batch)-1].ReceivedAt,
+		Events: len(batch),
+		Counts: map[string]int{},
+	}
+	var p struct {
+		Ticket struct {
+			Room   string `json:"room"`
+			Status string `json:"status"`
+		} `json:"ticket"`
+	}
+	seen := map[string]bool{}
+	for _, e := range batch {
+		p.Ticket.Room, p.Ticket.Status = "", ""
+		if ev, err := mq.Decode(e.Payload); err == nil {
+			_ = json.Unmarshal(ev.Data, &p)
+		}
+		key := digestKey(e.Topic, p.Ticket.Status)
+		sum.Counts[key]++
+		if r := p.Ticket.Room; r != "" && !seen[r] {
+			seen[r] = true
+			sum.Rooms = append(sum.Rooms, r)
+		}
+	}
+	slices.Sort(sum.Rooms)
+
+	var parts []string
+	for _, l := range digestLabels {
+		if n := sum.Counts[l.key]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, l.label))
+		}
+	}
+	sum.Text = strings.Join(parts, ", ")
+	if len(sum.Rooms) > 0 {
+		sum.Text += "; rooms: " + strings.Join(sum.Rooms, ",")
+	}
+	return sum
+}
+
+func digestKey(topic, status string) string {
+	switch topic {
+	case mq.TopicTicketCreated:
+		return "created"
+	case mq.TopicTicketStatusUpdated:
+		if status == "RESOLVED" {
+			return "resolved"
+		}
+		return "status_updated"
+	case mq.TopicTicketAssigned:
+		return "assigned"
+	case mq.TopicTicketReassignSuggested:
+		return "reassign_suggested"
+	case mq.TopicTicketOverdue:
+		return "overdue"
+	case mq.TopicTicketCancelled:
+		return "cancelled"
+	case mq.TopicTicketDeleted:
+		return "deleted"
+	}
+	if topicMatches(mq.TopicChatTicketWildcard, topic) {
+		return "chat"
+	}
+	return "other"
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
-	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,38 +17,115 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"src/internal/buildinfo"
 	"src/internal/config"
+	"src/internal/health"
+	"src/internal/logging"
 	"src/internal/mq"
 )
 
 type EventRecord struct {
 	ReceivedAt time.Time       `json:"received_at"`
 	Topic      string          `json:"topic"`
+	Type       string          `json:"type,omitempty"` // mq.Envelope type; blank for non-events
 	Payload    json.RawMessage `json:"payload"`
 }
 
+// RingBuffer keeps the most recent events. It is safe for concurrent use.
+//
+// MQTT QoS 1 is at-least-once, so a redelivered event would otherwise be
+// recorded twice; Add drops an event whose dedupKey was already seen within
+// the last window.
 type RingBuffer struct {
+	mu  sync.Mutex
 	max int
 	arr []EventRecord
+
+	window     time.Duration
+	seen       map[string]time.Time
+	suppressed int
 }
 
-func NewRingBuffer(max int) *RingBuffer {
+func NewRingBuffer(max int, window time.Duration) *RingBuffer {
 	if max <= 0 {
 		max = 50
 	}
-	return &RingBuffer{max: max, arr: make([]EventRecord, 0, max)}
+	return &RingBuffer{max: max, arr: make([]EventRecord, 0, max), window: window, seen: map[string]time.Time{}}
 }
 
-func (rb *RingBuffer) Add(e EventRecord) {
+// Add records e and reports whether it was kept; false means it duplicated
+// an event seen within the dedup window.
+func (rb *RingBuffer) Add(e EventRecord) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.window > 0 {
+		for k, at := range rb.seen {
+			if e.ReceivedAt.Sub(at) > rb.window {
+				delete(rb.seen, k)
+			}
+		}
+		if key, ok := dedupKey(e); ok {
+			if _, dup := rb.seen[key]; dup {
+				rb.suppressed++
+				return false
+			}
+			rb.seen[key] = e.ReceivedAt
+		}
+	}
 	if len(rb.arr) < rb.max {
 		rb.arr = append(rb.arr, e)
-		return
+		return true
 	}
 	copy(rb.arr, rb.arr[1:])
 	rb.arr[len(rb.arr)-1] = e
+	return true
+}
+
+// Suppressed returns how many duplicates Add has dropped.
+func (rb *RingBuffer) Suppressed() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.suppressed
+}
+
+// dedupKey identifies the event inside e's payload: by its event_id when the
+// publisher set one, otherwise ticket events by ticket ID and version and
+// chat events by message ID, send time and text (an edit keeps the original
+// sent_at). Payloads carrying none of these, such as service status
+// announcements, are never deduplicated.
+func dedupKey(e EventRecord) (string, bool) {
+	var p struct {
+		EventID   string    `json:"event_id"`
+		TicketID  int64     `json:"ticket_id"`
+		MessageID int64     `json:"message_id"`
+		Message   string    `json:"message"`
+		SentAt    time.Time `json:"sent_at"`
+		Ticket    struct {
+			ID      int64 `json:"id"`
+			Version int64 `json:"version"`
+		} `json:"ticket"`
+	}
+	ev, err := mq.Decode(e.Payload)
+	if err != nil || ev.Type == "" {
+		return "", false
+	}
+	if err := json.Unmarshal(ev.Data, &p); err != nil {
+		return "", false
+	}
+	switch {
+	case p.EventID != "":
+		return e.Topic + "|" + p.EventID, true
+	case p.Ticket.ID != 0:
+		return fmt.Sprintf("%s|%d|%s|v%d", e.Topic, p.Ticket.ID, ev.Type, p.Ticket.Version), true
+	case p.TicketID != 0 && !p.SentAt.IsZero():
+		return fmt.Sprintf("%s|%d|%s|%s|%d|%s", e.Topic, p.TicketID, ev.Type, p.SentAt.Format(time.RFC3339Nano), p.MessageID, p.Message), true
+	}
+	return "", false
 }
 
 func (rb *RingBuffer) Snapshot() []EventRecord {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
 	out := make([]EventRecord, len(rb.arr))
 	copy(out, rb.arr)
 	return out
@@ -55,7 +133,8 @@ func (rb *RingBuffer) Snapshot() []EventRecord {
 
 func main() {
 	cfg := config.LoadNotifier()
-	logger := log.New(os.Stdout, "[notifier] ", log.LstdFlags|log.Lmicroseconds)
+	logger := logging.New("notifier", cfg.LogFormat)
+	build := buildinfo.Get("notifier")
 
 	bufSize := 50
 	if cfg.EventBufferSize != "" {
@@ -63,60 +142,196 @@ func main() {
 			bufSize = n
 		}
 	}
-	rb := NewRingBuffer(bufSize)
+	rb := NewRingBuffer(bufSize, cfg.EventDedupWindow)
+	hooks := NewWebhooks(cfg.WebhookURLs, cfg.WebhookTimeout, logger)
 
-	client, err := mq.Connect(mq.Config{
-		BrokerURL: cfg.MQTTBroker,
-		ClientID:  cfg.MQTTClientID,
-		Logger:    logger,
-	})
+	var digest *Digest
+	switch cfg.WebhookMode {
+	case WebhookModeImmediate:
+	case WebhookModeDigest:
+		digest = NewDigest(hooks, cfg.DigestInterval, cfg.DigestMaxEvents, logger)
+	default:
+		logging.Fatal(logger, "unknown WEBHOOK_MODE", "mode", cfg.WebhookMode)
+	}
+	quiet, err := NewQuietHours(cfg.QuietHoursStart, cfg.QuietHoursEnd, cfg.QuietHoursTZ, hooks, logger)
 	if err != nil {
-		logger.Fatalf("mqtt connect: %v", err)
+		logging.Fatal(logger, "quiet hours", "err", err)
 	}
-	defer client.Disconnect(250)
 
-	subscribe := func(topic string) {
-		token := client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
-			rec := EventRecord{
-				ReceivedAt: time.Now().UTC(),
-				Topic:      msg.Topic(),
-				Payload:    json.RawMessage(append([]byte(nil), msg.Payload()...)),
-			}
-			rb.Add(rec)
-			logger.Printf("ALERT topic=%s payload=%s", msg.Topic(), string(msg.Payload()))
-		})
-		token.Wait()
-		if err := token.Error(); err != nil {
-			logger.Printf("subscribe error topic=%s: %v", topic, err)
-		} else {
-			logger.Printf("subscribed topic=%s", topic)
+	handler := func(_ mqtt.Client, msg mqtt.Message) {
+		rec := EventRecord{
+			ReceivedAt: time.Now().UTC(),
+			Topic:      msg.Topic(),
+			Payload:    json.RawMessage(append([]byte(nil), msg.Payload()...)),
+		}
+		if ev, err := mq.Decode(msg.Payload()); err == nil {
+			rec.Type = ev.Type
+		}
+		// Typing indicators only matter to open chat windows.
+		if rec.Type == "chat_typing" {
+			return
+		}
+		if !rb.Add(rec) {
+			logger.Info("duplicate event suppressed", "topic", msg.Topic())
+			return
+		}
+		logger.Info("alert", "topic", msg.Topic(), "payload", string(msg.Payload()))
+		switch {
+		case !hooks.Enabled():
+		case quiet != nil && quiet.Hold(rec):
+			logger.Info("held for quiet hours", "topic", msg.Topic())
+		case digest != nil:
+			digest.Add(rec)
+		default:
+			// Don't hold up paho's delivery goroutine on a slow webhook.
+			go hooks.Deliver(context.Background(), rec)
 		}
 	}
 
-	subscribe(mq.TopicTicketCreated)
-	subscribe(mq.TopicTicketStatusUpdated)
-	subscribe(mq.TopicTicketAssigned)
+	subs := []mq.Subscription{
+		{Topic: mq.TopicTicketCreated, QoS: 1, Handler: handler, Critical: true},
+		{Topic: mq.TopicTicketStatusUpdated, QoS: 1, Handler: handler, Critical: true},
+		{Topic: mq.TopicTicketAssigned, QoS: 1, Handler: handler, Critical: true},
+		{Topic: mq.TopicTicketOverdue, QoS: 1, Handler: handler, Critical: true},
+		{Topic: mq.TopicTicketCancelled, QoS: 1, Handler: handler, Critical: true},
+		{Topic: mq.TopicTicketDeleted, QoS: 1, Handler: handler, Critical: true},
+		{Topic: mq.TopicTicketReassignSuggested, QoS: 1, Handler: handler, Critical: true},
+
+		// ✅ Chat events
+		{Topic: mq.TopicChatTicketWildcard, QoS: 1, Handler: handler, Critical: true},
+
+		// Service online/offline announcements
+		{Topic: mq.TopicServiceStatusWildcard, QoS: 1, Handler: handler},
+	}
 
-	// ✅ Chat events
-	subscribe(mq.TopicChatTicketWildcard)
+	subState := mq.NewSubscriptionState()
+	mqttCfg := mq.Config{
+		BrokerURL: cfg.MQTTBroker,
+		ClientID:  cfg.MQTTClientID,
+		Logger:    logger,
+		Username:  cfg.MQTTUsername,
+		Password:  cfg.MQTTPassword,
+		TLS:       mq.TLSConfig(cfg.MQTTTLS),
+		Status: mq.StatusConfig{
+			Enabled: cfg.MQTTStatus.Enabled,
+			Service: "notifier",
+			Topic:   cfg.MQTTStatus.Topic,
+		},
+		Subscriptions: subs,
+		Retry: mq.RetryPolicy{
+			Attempts:   cfg.MQTTSubscribe.Attempts,
+			Backoff:    cfg.MQTTSubscribe.Backoff,
+			MaxBackoff: 10 * time.Second,
+		},
+		SubscriptionState: subState,
+	}
+	client, err := mq.Connect(mqttCfg)
+	var subErr *mq.SubscribeError
+	switch {
+	case errors.As(err, &subErr) && !cfg.MQTTSubscribe.FailFast:
+		logger.Error("mqtt subscribe failed; running degraded", "err", err)
+	case err != nil:
+		logging.Fatal(logger, "mqtt connect", "err", err)
+	}
+	defer mq.Disconnect(client, mqttCfg, 250)
 
 	r := chi.NewRouter()
 	r.Use(middleware.RealIP)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(10 * time.Second))
+	r.Use(logging.RequestLogger(logger))
+
+	r.Get("/version", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(build)
+	})
+
+	// Liveness and readiness probes for orchestrators.
+	r.Get("/healthz", health.Liveness("notifier"))
+	r.Get("/readyz", health.Readiness("notifier", health.Check{Name: "mqtt", Run: func(context.Context) error {
+		if !client.IsConnected() {
+			return mq.ErrNotConnected
+		}
+		if failed := subState.Failed(); len(failed) > 0 {
+			return errors.New("subscriptions failed: " + strings.Join(failed, ", "))
+		}
+		return nil
+	}}))
 
 	r.Get("/health", func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+		if failed := subState.Failed(); len(failed) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"status":               "degraded",
+				"service":              "notifier",
+				"failed_subscriptions": failed,
+			})
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(`{"status":"ok","service":"notifier"}`))
 	})
 
 	r.Get("/events", func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+		events := rb.Snapshot()
 		_ = json.NewEncoder(w).Encode(map[string]any{
-			"count":  len(rb.arr),
-			"events": rb.Snapshot(),
+			"count":                 len(events),
+			"duplicates_suppressed": rb.Suppressed(),
+			"events":                events,
+		})
+	})
+
+	// POST /replay re-sends the buffered events, oldest first, to the
+	// webhooks. Optional query parameters: topic (an MQTT filter, + and #
+	// allowed) and since/until (RFC 3339, matched against received_at).
+	r.Post("/replay", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !hooks.Enabled() {
+			w.WriteHeader(http.StatusNotImplemented)
+			_, _ = w.Write([]byte(`{"error":"no webhook URLs configured"}`))
+			return
+		}
+		q := r.URL.Query()
+		var since, until time.Time
+		for _, p := range []struct {
+			name string
+			dst  *time.Time
+		}{{"since", &since}, {"until", &until}} {
+			v := q.Get(p.name)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid " + p.name + " (RFC 3339 expected)"})
+				return
+			}
+			*p.dst = t
+		}
+		topic := q.Get("topic")
+
+		var events, delivered, failed int
+		for _, e := range rb.Snapshot() {
+			if topic != "" && !topicMatches(topic, e.Topic) {
+				continue
+			}
+			if (!since.IsZero() && e.ReceivedAt.Before(since)) || (!until.IsZero() && e.ReceivedAt.After(until)) {
+				continue
+			}
+			events++
+			d, f := hooks.Deliver(r.Context(), e)
+			delivered += d
+			failed += f
+		}
+		logger.Info("replay", "events", events, "delivered", delivered, "failed", failed)
+		_ = json.NewEncoder(w).Encode(map[string]int{
+			"events":    events,
+			"delivered": delivered,
+			"failed":    failed,
 		})
 	})
 
@@ -125,18 +340,35 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Background webhook workers, stopped by ctx; shutdown waits for them.
+	var workers sync.WaitGroup
+	start := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+	if digest != nil && hooks.Enabled() {
+		start(digest.Run)
+	}
+	if quiet != nil && hooks.Enabled() {
+		start(quiet.Run)
+	}
+
 	go func() {
-		logger.Printf("listening on %s (mqtt=%s)", cfg.Addr, cfg.MQTTBroker)
+		logger.Info("listening", "addr", cfg.Addr, "mqtt", cfg.MQTTBroker, "version", build.Version, "commit", build.Commit, "build_time", build.BuildTime)
 		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Fatalf("listen: %v", err)
+			logging.Fatal(logger, "listen", "err", err)
 		}
 	}()
 
 	<-ctx.Done()
-	logger.Printf("shutdown signal received")
+	logger.Info("shutdown signal received")
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	_ = srv.Shutdown(shutdownCtx)
-	logger.Printf("stopped")
+	workers.Wait()
+	logger.Info("stopped")
 }
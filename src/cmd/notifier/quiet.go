@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"src/internal/mq"
+)
+
+// QuietHours holds back webhook delivery of non-urgent events during a daily
+// window and sends one summary of what it held once the window ends. Events
+// still reach the ring buffer as usual. It is safe for concurrent use.
+type QuietHours struct {
+	start, end int // minutes after midnight in loc; end may be before start
+	loc        *time.Location
+	hooks      *Webhooks
+	logger     *slog.Logger
+
+	mu       sync.Mutex
+	held     []EventRecord
+	inWindow bool
+}
+
+// NewQuietHours parses a window given as "HH:MM" start and end in the named
+// time zone. Blank start and end return nil, meaning no quiet hours.
+func NewQuietHours(start, end, tz string, hooks *Webhooks, logger *slog.Logger) (*QuietHours, error) {
+	if start == "" && end == "" {
+		return nil, nil
+	}
+	s, err := parseClock(start)
+	if err != nil {
+		return nil, fmt.Errorf("QUIET_HOURS_START: %w", err)
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return nil, fmt.Errorf("QUIET_HOURS_END: %w", err)
+	}
+	if s == e {
+		return nil, fmt.Errorf("QUIET_HOURS_START and QUIET_HOURS_END are both %s", start)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("QUIET_HOURS_TZ: %w", err)
+	}
+	return &QuietHours{start: s, end: e, loc: loc, hooks: hooks, logger: logger}, nil
+}
+
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Active reports whether t falls inside the window.
+func (q *QuietHours) Active(t time.Time) bool {
+	lt := t.In(q.loc)
+	m := lt.Hour()*60 + lt.Minute()
+	if q.start < q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+// Hold keeps e back for the end-of-window summary and reports true, unless
+// the window is closed or e is urgent, in which case the caller delivers it.
+func (q *QuietHours) Hold(e EventRecord) bool {
+	if !q.Active(e.ReceivedAt) || isUrgent(e) {
+		return false
+	}
+	q.mu.Lock()
+	q.held = append(q.held, e)
+	q.mu.Unlock()
+	return true
+}
+
+// Run checks the window once a minute and, when it closes, sends a summary
+// of the events held during it. It returns when ctx ends; anything still
+// held then is not sent, as that would page staff in the middle of the
+// window.
+func (q *QuietHours) Run(ctx context.Context) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			q.check(ctx, now)
+		}
+	}
+}
+
+func (q *QuietHours) check(ctx context.Context, now time.Time) {
+	active := q.Active(now)
+	q.mu.Lock()
+	ended := q.inWindow && !active
+	q.inWindow = active
+	var batch []EventRecord
+	if !active {
+		batch, q.held = q.held, nil
+	}
+	q.mu.Unlock()
+	if !ended || len(batch) == 0 {
+		return
+	}
+	sum := summarize(batch)
+	sum.Type = "quiet_hours"
+	sum.Text = "suppressed during quiet hours: " + sum.Text
+	delivered, failed := q.hooks.send(ctx, "quiet_hours", sum)
+	q.logger.Info("quiet hours summary sent", "events", sum.Events, "delivered", delivered, "failed", failed)
+}
+
+// isUrgent reports whether e concerns a ticket with URGENT priority; those
+// are delivered even during quiet hours.
+func isUrgent(e EventRecord) bool {
+	var p struct {
+		Ticket struct {
+			Priority string `json:"priority"`
+		} `json:"ticket"`
+	}
+	ev, err := mq.Decode(e.Payload)
+	if err != nil || json.Unmarshal(ev.Data, &p) != nil {
+		return false
+	}
+	return strings.EqualFold(p.Ticket.Priority, "URGENT")
+}
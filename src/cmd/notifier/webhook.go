@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhooks POSTs event records as JSON to a fixed set of URLs.
+type Webhooks struct {
+	urls    []string
+	timeout time.Duration
+	client  *http.Client
+	logger  *slog.Logger
+}
+
+func NewWebhooks(urls []string, timeout time.Duration, logger *slog.Logger) *Webhooks {
+	return &Webhooks{urls: urls, timeout: timeout, client: &http.Client{}, logger: logger}
+}
+
+// Enabled reports whether any webhook URL is configured.
+func (wh *Webhooks) Enabled() bool {
+	return len(wh.urls) > 0
+}
+
+// Deliver sends e to every URL and returns how many deliveries succeeded
+// and failed. Failures are logged, not retried.
+func (wh *Webhooks) Deliver(ctx context.Context, e EventRecord) (delivered, failed int) {
+	return wh.send(ctx, e.Topic, e)
+}
+
+// send POSTs v as JSON to every URL; topic labels the log lines.
+func (wh *Webhooks) send(ctx context.Context, topic string, v any) (delivered, failed int) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		wh.logger.Error("webhook encode", "topic", topic, "err", err)
+		return 0, len(wh.urls)
+	}
+	for _, u := range wh.urls {
+		if err := wh.post(ctx, u, body); err != nil {
+			wh.logger.Warn("webhook delivery failed", "url", u, "topic", topic, "err", err)
+			failed++
+			continue
+		}
+		delivered++
+	}
+	return delivered, failed
+}
+
+func (wh *Webhooks) post(ctx context.Context, url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, wh.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// topicMatches reports whether topic matches the MQTT-style filter, where
+// "+" stands for one level and a trailing "#" for any number of levels.
+func topicMatches(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -2,80 +2,368 @@ package authclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 type Client struct {
 	BaseURL     string
 	InternalKey string
 	HTTPClient  *http.Client
+
+	// Timeout caps each call on top of the caller's context, so a call ends
+	// at whichever comes first: this, the caller's deadline or its
+	// cancellation (e.g. the browser disconnecting). 0 means no extra cap.
+	Timeout time.Duration
+
+	// Retries is how many extra attempts a GET or login gets after a
+	// connection error or 5xx, waiting RetryBackoff (doubling) in between.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// RoleCacheTTL is how long ListUsersByRole answers from memory; 0
+	// turns the cache off. Users created through this client clear the
+	// cache at once.
+	RoleCacheTTL time.Duration
+	roles        *roleCache
+}
+
+// Defaults set by New.
+const (
+	DefaultTimeout             = 6 * time.Second
+	DefaultRetries             = 2
+	DefaultRetryBackoff        = 200 * time.Millisecond
+	DefaultMaxIdleConnsPerHost = 32
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultRoleCacheTTL        = 30 * time.Second
+)
+
+// Options tunes the connection pool to the auth service. Zero fields take
+// the defaults above.
+type Options struct {
+	// Idle keep-alive connections kept open to auth. The gateway talks to a
+	// single host, so this is effectively the pool size; Go's default of 2
+	// makes busy gateways reconnect for nearly every call.
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
 }
 
-func New(baseURL, internalKey string) *Client {
+func New(baseURL, internalKey string, opts Options) *Client {
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConns = opts.MaxIdleConnsPerHost
+	tr.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	tr.IdleConnTimeout = opts.IdleConnTimeout
+	// Small JSON bodies on an internal hop: gzip costs more than it saves.
+	tr.DisableCompression = true
+
 	return &Client{
 		BaseURL:     baseURL,
 		InternalKey: internalKey,
-		HTTPClient:  &http.Client{Timeout: 6 * time.Second},
+		HTTPClient:  &http.Client{Transport: tr},
+		Timeout:     DefaultTimeout,
+
+		Retries:      DefaultRetries,
+		RetryBackoff: DefaultRetryBackoff,
+
+		RoleCacheTTL: DefaultRoleCacheTTL,
+		roles:        &roleCache{entries: map[string]roleEntry{}},
 	}
 }
 
-func (c *Client) Login(req LoginRequest) (User, error) {
+func (c *Client) Login(ctx context.Context, req LoginRequest) (User, error) {
 	var out LoginResponse
-	if err := c.doJSON("POST", "/api/login", false, req, &out); err != nil {
+	if err := c.doJSON(ctx, "POST", "/api/login", false, req, &out); err != nil {
 		return User{}, err
 	}
 	return out.User, nil
 }
 
-func (c *Client) CreateUser(req CreateUserRequest) (User, error) {
+func (c *Client) CreateUser(ctx context.Context, req CreateUserRequest) (User, error) {
+	defer c.roles.clear()
 	var out CreateUserResponse
-	if err := c.doJSON("POST", "/api/users", true, req, &out); err != nil {
+	if err := c.doJSON(ctx, "POST", "/api/users", true, req, &out); err != nil {
 		return User{}, err
 	}
 	return out.User, nil
 }
 
-func (c *Client) ListUsersByRole(role string) ([]User, error) {
-	u, _ := url.Parse(c.BaseURL)
-	u.Path = "/api/users"
-	q := u.Query()
-	q.Set("role", role)
-	u.RawQuery = q.Encode()
+// BulkTimeout replaces Timeout for BulkCreateUsers, which hashes a
+// password per row.
+const BulkTimeout = 15 * time.Second
+
+// MaxBulkUsers mirrors the auth service's cap on one bulk import.
+const MaxBulkUsers = 50
+
+// BulkCreateUsers creates every valid row of reqs in one call. Rows that
+// fail (invalid or duplicate) are reported in the per-row results rather
+// than as an error.
+func (c *Client) BulkCreateUsers(ctx context.Context, reqs []CreateUserRequest) (BulkCreateUsersResponse, error) {
+	defer c.roles.clear()
+	var out BulkCreateUsersResponse
+	cc := *c
+	cc.Timeout = BulkTimeout
+	if err := cc.doJSON(ctx, "POST", "/api/users/bulk", true, reqs, &out); err != nil {
+		return BulkCreateUsersResponse{}, err
+	}
+	return out, nil
+}
 
-	httpReq, _ := http.NewRequest("GET", u.String(), nil)
-	httpReq.Header.Set("X-Internal-Key", c.InternalKey)
+// UpdateRole changes user id's role. The auth service clears the room of
+// non-guests and the department of non-staff, and refuses to demote the
+// last admin (CodeLastAdmin).
+func (c *Client) UpdateRole(ctx context.Context, id int64, req UpdateRoleRequest) (User, error) {
+	defer c.roles.clear()
+	var out GetUserResponse
+	if err := c.doJSON(ctx, "PATCH", "/api/users/"+strconv.FormatInt(id, 10), true, req, &out); err != nil {
+		return User{}, err
+	}
+	return out.User, nil
+}
 
-	resp, err := c.HTTPClient.Do(httpReq)
+// SetDepartment moves staff user id to department; "" removes them from
+// any department.
+func (c *Client) SetDepartment(ctx context.Context, id int64, department string) (User, error) {
+	defer c.roles.clear()
+	var out GetUserResponse
+	in := map[string]string{"department": department}
+	if err := c.doJSON(ctx, "PUT", "/api/users/"+strconv.FormatInt(id, 10)+"/department", true, in, &out); err != nil {
+		return User{}, err
+	}
+	return out.User, nil
+}
+
+// GetUser looks up a single user by ID. A missing user is an *AuthError
+// with Status 404.
+func (c *Client) GetUser(ctx context.Context, id int64) (User, error) {
+	var out GetUserResponse
+	if err := c.doJSON(ctx, "GET", "/api/users/"+strconv.FormatInt(id, 10), true, nil, &out); err != nil {
+		return User{}, err
+	}
+	return out.User, nil
+}
+
+// MaxBatchIDs mirrors the auth service's per-request cap on ?ids= lookups.
+const MaxBatchIDs = 100
+
+// GetUsersByIDs resolves many user IDs with as few requests as possible
+// (one per MaxBatchIDs). Unknown IDs are simply absent from the result.
+func (c *Client) GetUsersByIDs(ctx context.Context, ids []int64) ([]User, error) {
+	var all []User
+	for len(ids) > 0 {
+		n := min(len(ids), MaxBatchIDs)
+		parts := make([]string, n)
+		for i, id := range ids[:n] {
+			parts[i] = strconv.FormatInt(id, 10)
+		}
+		ids = ids[n:]
+
+		q := url.Values{}
+		q.Set("ids", strings.Join(parts, ","))
+		var out ListUsersResponse
+		if err := c.doJSON(ctx, "GET", "/api/users?"+q.Encode(), true, nil, &out); err != nil {
+			return nil, err
+		}
+		all = append(all, out.Users...)
+	}
+	return all, nil
+}
+
+// ListUsersByRole returns every user with role. Results are cached for
+// RoleCacheTTL; callers get their own copy of the slice.
+func (c *Client) ListUsersByRole(ctx context.Context, role string) ([]User, error) {
+	if users, ok := c.roles.get(role, c.RoleCacheTTL); ok {
+		return users, nil
+	}
+	gen := c.roles.generation()
+	out, err := c.ListUsers(ctx, ListUsersOptions{Role: role})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	c.roles.put(role, out.Users, gen)
+	return append([]User(nil), out.Users...), nil
+}
 
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("auth list users status=%d", resp.StatusCode)
+// ListUsersByRoleAndDepartment is ListUsersByRole narrowed to users in
+// department, matched ignoring case. It filters the cached role listing
+// rather than asking the auth service again.
+func (c *Client) ListUsersByRoleAndDepartment(ctx context.Context, role, department string) ([]User, error) {
+	users, err := c.ListUsersByRole(ctx, role)
+	if err != nil {
+		return nil, err
 	}
+	out := users[:0]
+	for _, u := range users {
+		if strings.EqualFold(u.Department, department) {
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
 
+// ListUsers returns one page of accounts matching opts, with the total
+// match count for paging.
+func (c *Client) ListUsers(ctx context.Context, opts ListUsersOptions) (ListUsersResponse, error) {
+	q := url.Values{}
+	if opts.Role != "" {
+		q.Set("role", opts.Role)
+	}
+	if opts.Department != "" {
+		q.Set("department", opts.Department)
+	}
+	if opts.Query != "" {
+		q.Set("q", opts.Query)
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		q.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if !opts.From.IsZero() {
+		q.Set("from", opts.From.Format(time.RFC3339))
+	}
+	if !opts.To.IsZero() {
+		q.Set("to", opts.To.Format(time.RFC3339))
+	}
 	var out ListUsersResponse
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
+	err := c.doJSON(ctx, "GET", "/api/users?"+q.Encode(), true, nil, &out)
+	return out, err
+}
+
+// Ping checks that the auth service is up by calling its liveness probe
+// once, without retries.
+func (c *Client) Ping(ctx context.Context) error {
+	var out map[string]any
+	return c.do(ctx, "GET", "/healthz", false, nil, &out)
+}
+
+// EachUser calls fn for every account, in id order, decoding the auth
+// service's reply as it arrives rather than holding the whole list. It makes
+// a single attempt: rows already passed to fn can't be taken back.
+func (c *Client) EachUser(ctx context.Context, fn func(User) error) error {
+	return c.do(ctx, "GET", "/api/users", true, nil, decoder(func(r io.Reader) error {
+		dec := json.NewDecoder(r)
+		// Walk {"users":[...]} token by token; "users" is null when empty.
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if key != "users" {
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					return err
+				}
+				continue
+			}
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if tok == nil {
+				continue
+			}
+			for dec.More() {
+				var u User
+				if err := dec.Decode(&u); err != nil {
+					return err
+				}
+				if err := fn(u); err != nil {
+					return err
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// decoder, passed as do's out, reads the response body itself instead of
+// having it decoded as JSON.
+type decoder func(io.Reader) error
+
+// doJSON sends in (if any) as JSON and decodes the reply into out. The
+// request is bound to ctx and carries the caller's request ID, if ctx has
+// one, as X-Request-Id so both services log the same ID.
+//
+// GETs and logins are retried on connection errors and 5xx replies, up to
+// Retries times; 4xx replies and other methods are never retried.
+func (c *Client) doJSON(ctx context.Context, method, path string, internal bool, in any, out any) error {
+	var b []byte
+	if in != nil {
+		b, _ = json.Marshal(in)
+	}
+
+	retries := 0
+	if method == http.MethodGet || path == "/api/login" {
+		retries = c.Retries
+	}
+	delay := c.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, method, path, internal, b, out)
+		if err == nil || attempt >= retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if delay > 0 {
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return err
+			case <-t.C:
+			}
+			delay *= 2
+		}
 	}
-	return out.Users, nil
 }
 
-func (c *Client) doJSON(method, path string, internal bool, in any, out any) error {
-	b, _ := json.Marshal(in)
-	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(b))
+// do makes a single attempt of a doJSON call.
+func (c *Client) do(ctx context.Context, method, path string, internal bool, body []byte, out any) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, r)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	if internal {
 		req.Header.Set("X-Internal-Key", c.InternalKey)
 	}
+	if id := middleware.GetReqID(ctx); id != "" {
+		req.Header.Set(middleware.RequestIDHeader, id)
+	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
@@ -84,9 +372,92 @@ func (c *Client) doJSON(method, path string, internal bool, in any, out any) err
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 300 {
-		// try read {error:"..."} but keep simple
-		return fmt.Errorf("auth request failed status=%d", resp.StatusCode)
+		return readError(resp)
 	}
 
+	if d, ok := out.(decoder); ok {
+		return d(resp.Body)
+	}
 	return json.NewDecoder(resp.Body).Decode(out)
 }
+
+// retryable reports whether err is worth another attempt: a transport
+// failure or a 5xx from the auth service.
+func retryable(err error) bool {
+	var ae *AuthError
+	if errors.As(err, &ae) {
+		return ae.Status >= 500
+	}
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// AuthError is returned when the auth service answers with a non-2xx status.
+// Message and Code are the service's {"error": "...", "code": "..."} fields
+// when it sent them.
+type AuthError struct {
+	Status  int
+	Message string
+	Code    string
+}
+
+func (e *AuthError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("auth request failed status=%d", e.Status)
+	}
+	return fmt.Sprintf("auth request failed status=%d: %s", e.Status, e.Message)
+}
+
+func readError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	return &AuthError{Status: resp.StatusCode, Message: body.Error, Code: body.Code}
+}
+
+// roleCache holds ListUsersByRole results. gen counts clears, so a listing
+// fetched before a clear isn't stored over it.
+type roleCache struct {
+	mu      sync.Mutex
+	gen     uint64
+	entries map[string]roleEntry
+}
+
+type roleEntry struct {
+	users   []User
+	fetched time.Time
+}
+
+func (rc *roleCache) get(role string, ttl time.Duration) ([]User, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[role]
+	if !ok || time.Since(e.fetched) >= ttl {
+		return nil, false
+	}
+	return append([]User(nil), e.users...), true
+}
+
+func (rc *roleCache) generation() uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.gen
+}
+
+func (rc *roleCache) put(role string, users []User, gen uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if gen != rc.gen {
+		return
+	}
+	rc.entries[role] = roleEntry{users: append([]User(nil), users...), fetched: time.Now()}
+}
+
+func (rc *roleCache) clear() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.gen++
+	clear(rc.entries)
+}
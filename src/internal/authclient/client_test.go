@@ -0,0 +1,67 @@
+package authclient
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkListUsersByRole makes bursts of concurrent calls to a local auth
+// stub, as a busy gateway does, with the role cache off so every call is a
+// round trip. conns/op is how many new TCP connections one burst opened:
+// with Go's default of 2 idle connections per host most of a burst's
+// connections are closed afterwards and reopened by the next, with the
+// pooled default they are reused.
+func BenchmarkListUsersByRole(b *testing.B) {
+	const burst = 16
+	body, _ := json.Marshal(ListUsersResponse{Users: []User{{ID: 7, Username: "sam", Role: RoleStaff}}, Total: 1})
+
+	for _, bc := range []struct {
+		name string
+		opts Options
+	}{
+		{"go-default", Options{MaxIdleConnsPerHost: 2}},
+		{"pooled", Options{}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var conns atomic.Int64
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write(body)
+			}))
+			srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+				if s == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			srv.Start()
+			defer srv.Close()
+
+			c := New(srv.URL, "test-key", bc.opts)
+			c.RoleCacheTTL = 0
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < burst; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if _, err := c.ListUsersByRole(ctx, RoleStaff); err != nil {
+							b.Error(err)
+						}
+					}()
+				}
+				wg.Wait()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -3,11 +3,12 @@ package authclient
 import "time"
 
 type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	Role      string    `json:"role"` // GUEST, STAFF, ADMIN
-	Room      string    `json:"room"` // only for GUEST
-	CreatedAt time.Time `json:"created_at"`
+	ID         int64     `json:"id"`
+	Username   string    `json:"username"`
+	Role       string    `json:"role"`                 // GUEST, STAFF, ADMIN
+	Room       string    `json:"room"`                 // only for GUEST
+	Department string    `json:"department,omitempty"` // only for STAFF
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 const (
@@ -16,6 +17,18 @@ const (
 	RoleAdmin = "ADMIN"
 )
 
+// Error codes in AuthError.Code and the gateway's error responses. The auth
+// service defines the same strings; CodeUnknownRoom is the gateway's own.
+const (
+	CodeCredentialsRequired = "credentials_required"
+	CodeInvalidRole         = "invalid_role"
+	CodeRoomRequired        = "room_required"
+	CodeUsernameTaken       = "username_taken"
+	CodeInvalidDepartment   = "invalid_department"
+	CodeLastAdmin           = "last_admin"
+	CodeUnknownRoom         = "unknown_room"
+)
+
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -26,16 +39,58 @@ type LoginResponse struct {
 }
 
 type CreateUserRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Role     string `json:"role"`
-	Room     string `json:"room,omitempty"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Role       string `json:"role"`
+	Room       string `json:"room,omitempty"`
+	Department string `json:"department,omitempty"`
+}
+
+// UpdateRoleRequest changes a user's role. Room is required for GUEST and
+// ignored otherwise.
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+	Room string `json:"room,omitempty"`
 }
 
 type CreateUserResponse struct {
 	User User `json:"user"`
 }
 
+// BulkUserResult is the outcome of one row of a bulk import; Index is the
+// row's position in the request.
+type BulkUserResult struct {
+	Index int    `json:"index"`
+	OK    bool   `json:"ok"`
+	User  *User  `json:"user,omitempty"`
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type BulkCreateUsersResponse struct {
+	Created int              `json:"created"`
+	Failed  int              `json:"failed"`
+	Results []BulkUserResult `json:"results"`
+}
+
+type GetUserResponse struct {
+	User User `json:"user"`
+}
+
 type ListUsersResponse struct {
 	Users []User `json:"users"`
+	Total int    `json:"total"` // matches before limit/offset
+}
+
+// ListUsersOptions filters and pages ListUsers. Zero values mean no
+// filter; Limit 0 returns every match.
+type ListUsersOptions struct {
+	Role       string
+	Department string
+	Query      string // username prefix
+	Limit      int
+	Offset     int
+
+	// From and To bound created_at, inclusive.
+	From, To time.Time
 }
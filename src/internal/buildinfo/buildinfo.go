@@ -0,0 +1,65 @@
+// Package buildinfo reports which build of a service is running. The
+// values are set at link time, e.g.
+//
+//	go build -ldflags "-X src/internal/buildinfo.Version=1.4.0 \
+//	  -X src/internal/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//	  -X src/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// (see the Makefile). A plain go build falls back to the VCS stamp Go
+// embeds, when there is one.
+package buildinfo
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// Set with -ldflags -X.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info is the body of GET /version.
+type Info struct {
+	Service   string `json:"service"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+var vcs = sync.OnceValues(func() (rev, at string) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", ""
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.time":
+			at = s.Value
+		}
+	}
+	return rev, at
+})
+
+// Get returns the build info for service.
+func Get(service string) Info {
+	info := Info{Service: service, Version: Version, Commit: Commit, BuildTime: BuildTime}
+	rev, at := vcs()
+	if info.Commit == "" {
+		info.Commit = rev
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = at
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
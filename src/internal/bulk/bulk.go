@@ -0,0 +1,56 @@
+// Package bulk is the response envelope shared by every endpoint that acts
+// on many items at once (ticket auto-assign, user import), so clients handle
+// partial success the same way everywhere.
+package bulk
+
+import "net/http"
+
+// Result lists what happened to each item. Skipped items needed no work,
+// e.g. a ticket someone else assigned first; unlike failures they don't
+// make the response a 207.
+type Result struct {
+	Succeeded []int64 `json:"succeeded"`
+	Skipped   []Item  `json:"skipped"`
+	Failed    []Item  `json:"failed"`
+	Total     int     `json:"total"`
+}
+
+// Item is a skipped or failed item. ID names it when it has one; Index is
+// its position in the request for items that don't exist yet, such as the
+// rows of an import.
+type Item struct {
+	ID    int64  `json:"id,omitempty"`
+	Index *int   `json:"index,omitempty"`
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error"`
+}
+
+func New() *Result {
+	return &Result{Succeeded: []int64{}, Skipped: []Item{}, Failed: []Item{}}
+}
+
+func (b *Result) OK(id int64) {
+	b.Succeeded = append(b.Succeeded, id)
+	b.Total++
+}
+
+func (b *Result) Skip(it Item) {
+	b.Skipped = append(b.Skipped, it)
+	b.Total++
+}
+
+func (b *Result) Fail(it Item) {
+	b.Failed = append(b.Failed, it)
+	b.Total++
+}
+
+// Status is 200 when nothing failed and 207 Multi-Status otherwise.
+func (b *Result) Status() int {
+	if len(b.Failed) > 0 {
+		return http.StatusMultiStatus
+	}
+	return http.StatusOK
+}
+
+// At is a helper for Item.Index.
+func At(i int) *int { return &i }
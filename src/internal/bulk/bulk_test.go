@@ -0,0 +1,54 @@
+package bulk
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestResultStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		fill  func(*Result)
+		want  int
+		total int
+	}{
+		{"empty", func(*Result) {}, http.StatusOK, 0},
+		{"all succeeded", func(b *Result) { b.OK(1); b.OK(2) }, http.StatusOK, 2},
+		{"skipped is not a failure", func(b *Result) {
+			b.OK(1)
+			b.Skip(Item{ID: 2, Error: "already assigned"})
+		}, http.StatusOK, 2},
+		{"any failure is a 207", func(b *Result) {
+			b.OK(1)
+			b.Skip(Item{ID: 2, Error: "already assigned"})
+			b.Fail(Item{ID: 3, Error: "db error"})
+		}, http.StatusMultiStatus, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New()
+			tt.fill(b)
+			if got := b.Status(); got != tt.want {
+				t.Errorf("Status = %d, want %d", got, tt.want)
+			}
+			if b.Total != tt.total {
+				t.Errorf("Total = %d, want %d", b.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	b := New()
+	b.OK(4)
+	b.Fail(Item{Index: At(0), Code: "unknown_room", Error: "unknown room"})
+	raw, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"succeeded":[4],"skipped":[],"failed":[{"index":0,"code":"unknown_room","error":"unknown room"}],"total":2}`
+	if string(raw) != want {
+		t.Errorf("got %s\nwant %s", raw, want)
+	}
+}
@@ -1,66 +1,537 @@
 package config
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DevInternalKey is the built-in AUTH_INTERNAL_KEY for local development.
+// Production deployments must replace it.
+const DevInternalKey = "dev-internal-key"
 
 type GatewayConfig struct {
+	// Env is "development" (default) or "production".
+	Env             string
 	Addr            string
 	DBPath          string
 	MQTTBroker      string
 	MQTTClientID    string
+	MQTTUsername    string
+	MQTTPassword    string
+	MQTTTLS         MQTTTLSConfig
+	MQTTStatus      MQTTStatusConfig
+	MQTTOutboxSize  int
+	MQTTSubscribe   MQTTSubscribeConfig
 	AuthServiceURL  string
 	AuthInternalKey string
+	MaxBodyBytes    int64
+	DBPool          DBPoolConfig
+
+	// MQTTLegacyEvents keeps the pre-envelope event fields at the top level
+	// of every published event while consumers migrate to mq.Envelope.
+	MQTTLegacyEvents bool
+
+	// On shutdown, wait up to MQTTDrainTimeout for in-flight publishes
+	// before disconnecting from the broker.
+	MQTTDrainTimeout time.Duration
+
+	// Before serving, wait up to StartupWaitTimeout for the auth service
+	// and broker; 0 skips the wait. When the time runs out the gateway
+	// exits with StartupFailFast, or starts degraded without it.
+	StartupWaitTimeout time.Duration
+	StartupFailFast    bool
+
+	// Extra attempts for auth GETs and logins after a connection error or
+	// 5xx, with AuthRetryBackoff doubling between them.
+	AuthRetries      int
+	AuthRetryBackoff time.Duration
+
+	// Keep-alive pool towards the auth service.
+	AuthMaxIdleConns    int
+	AuthIdleConnTimeout time.Duration
+
+	// Staff and other role listings are cached this long; creating a user
+	// through the gateway clears the cache. 0 turns the cache off.
+	AuthRoleCacheTTL time.Duration
+
+	// LogFormat is "json" (default) or "text" for a readable dev console.
+	LogFormat string
+
+	// Uploaded ticket photos live under AttachmentDir/<ticket id>/.
+	AttachmentDir      string
+	AttachmentMaxBytes int64
+
+	// Chat authors may edit a message for this long after sending it.
+	ChatEditWindow time.Duration
+
+	// Longest chat message accepted, in characters.
+	ChatMaxLen int
+
+	// ChatMaxMessages caps the messages stored per ticket (0 = no cap).
+	// ChatCapPolicy is reject (409) or prune (drop the oldest) at the cap.
+	ChatMaxMessages int
+	ChatCapPolicy   string
+
+	// GuestTicketTypes limits the types guests may report (comma-separated
+	// in GUEST_TICKET_TYPES); empty allows all of them.
+	GuestTicketTypes []string
+
+	// RoomRegistry limits ticket and guest rooms to the admin-managed
+	// rooms table.
+	RoomRegistry bool
+
+	// GuestCanReopen lets a guest reopen a resolved ticket they created.
+	GuestCanReopen bool
+
+	// DuplicateTickets is allow, warn or block: what to do when a guest
+	// reports a type that already has an open ticket in their room.
+	DuplicateTickets string
+
+	// TypeChangeRouting is off, suggest or auto: whether a guest's type
+	// correction suggests or reassigns to staff of the matching department.
+	TypeChangeRouting string
+
+	// Departments is the AUTH_DEPARTMENTS list the auth service holds staff
+	// to; tickets can only be routed to one of them. Empty accepts any name.
+	Departments []string
+
+	// Interval between SSE keep-alive comments; keep it below the idle
+	// timeout of any proxy in front of the gateway.
+	SSEKeepAlive time.Duration
+
+	// SSE buffering: SSEBroadcastBuffer events may wait for delivery and
+	// SSEClientBuffer per connection. SSESlowClientPolicy (drop-newest,
+	// drop-oldest or disconnect) handles a full connection buffer; with
+	// disconnect, a stream full SSEDisconnectAfter times in a row is closed.
+	SSEBroadcastBuffer  int
+	SSEClientBuffer     int
+	SSESlowClientPolicy string
+	SSEDisconnectAfter  int
+
+	// Serve HTTPS directly when both are set; plain HTTP otherwise.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Session cookie attributes. Enable CookieSecure behind TLS; it is
+	// forced on when the gateway serves TLS itself.
+	// CookieSameSite is one of lax, strict, none.
+	CookieSecure   bool
+	CookieSameSite string
+
+	// How often a session's user is re-checked against the auth service;
+	// a deleted account or a changed role or room ends the session.
+	SessionRevalidateInterval time.Duration
+
+	// Tickets still unresolved after TicketSLA fire a one-off overdue event.
+	TicketSLA           time.Duration
+	OverdueScanInterval time.Duration
+
+	// Finished tickets idle for TicketRetention are deleted, with their
+	// chat and attachments, every PurgeInterval. 0 (the default) keeps
+	// everything.
+	TicketRetention time.Duration
+	PurgeInterval   time.Duration
+
+	// Requests allowed per RateLimitWindow on /api; 0 disables the limit.
+	// Anonymous requests are limited per client IP.
+	RateLimitWindow time.Duration
+	RateLimitGuest  int
+	RateLimitStaff  int
+	RateLimitAdmin  int
+	RateLimitAnon   int
+
+	// Login attempts allowed per client IP per LoginRateWindow.
+	LoginRateLimit  int
+	LoginRateWindow time.Duration
+
+	// Typing indicators allowed per user per ChatTypingWindow; 0 disables
+	// the limit.
+	ChatTypingLimit  int
+	ChatTypingWindow time.Duration
 }
 
 type AuthConfig struct {
+	Env            string
 	Addr           string
 	DBPath         string
 	InternalKey    string
 	BootstrapAdmin bool
 	BootstrapUser  string
 	BootstrapPass  string
+	MaxBodyBytes   int64
+	LogFormat      string
+	DBPool         DBPoolConfig
+
+	// Usernames are always trimmed; with UsernameCaseInsensitive they are
+	// also lowercased and must be unique ignoring case.
+	UsernameCaseInsensitive bool
+
+	// Departments is the set a staff member's department must come from;
+	// empty accepts any name.
+	Departments []string
 }
 
 type NotifierConfig struct {
 	Addr            string
 	MQTTBroker      string
 	MQTTClientID    string
+	MQTTUsername    string
+	MQTTPassword    string
+	MQTTTLS         MQTTTLSConfig
+	MQTTStatus      MQTTStatusConfig
+	MQTTSubscribe   MQTTSubscribeConfig
 	EventBufferSize string
+	LogFormat       string
+
+	// Events with the same identity seen again within EventDedupWindow are
+	// treated as MQTT redeliveries and dropped.
+	EventDedupWindow time.Duration
+
+	// Every received event is POSTed to each of WebhookURLs (comma-separated
+	// in WEBHOOK_URLS), each attempt bounded by WebhookTimeout.
+	WebhookURLs    []string
+	WebhookTimeout time.Duration
+	// WebhookMode "digest" batches events into one summary per
+	// DigestInterval, sent early once DigestMaxEvents are waiting; the
+	// default "immediate" posts each event as it arrives.
+	WebhookMode     string
+	DigestInterval  time.Duration
+	DigestMaxEvents int
+
+	// Between QuietHoursStart and QuietHoursEnd ("HH:MM" in QuietHoursTZ,
+	// may wrap midnight) non-urgent events are not sent to the webhooks;
+	// one summary of them goes out when the window ends. Both blank
+	// disables quiet hours.
+	QuietHoursStart string
+	QuietHoursEnd   string
+	QuietHoursTZ    string
+}
+
+// DBPoolConfig bounds a service's SQLite connection pool.
+type DBPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// MQTTTLSConfig holds certificate paths for ssl:// / mqtts:// brokers.
+// All fields are optional.
+type MQTTTLSConfig struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// MQTTStatusConfig controls the retained online/offline (Last Will)
+// announcement. A blank Topic means smarthotel/services/<client id>/status.
+type MQTTStatusConfig struct {
+	Enabled bool
+	Topic   string
+}
+
+// MQTTSubscribeConfig bounds subscribe retries. With FailFast a critical
+// topic that still can't be subscribed stops the service; otherwise it keeps
+// running and /health reports it as degraded.
+type MQTTSubscribeConfig struct {
+	Attempts int
+	Backoff  time.Duration
+	FailFast bool
 }
 
+// LoadGateway reads the gateway settings from the environment and the
+// optional CONFIG_FILE, exiting with an error if a required one is blank.
 func LoadGateway() GatewayConfig {
-	return GatewayConfig{
-		Addr:            getenv("GATEWAY_ADDR", ":8080"),
-		DBPath:          getenv("DB_PATH", "./data/smarthotel.db"),
-		MQTTBroker:      getenv("MQTT_BROKER", "tcp://localhost:1883"),
-		MQTTClientID:    getenv("MQTT_CLIENT_ID", "smarthotel-gateway"),
-		AuthServiceURL:  getenv("AUTH_SERVICE_URL", "http://localhost:8090"),
-		AuthInternalKey: getenv("AUTH_INTERNAL_KEY", "dev-internal-key"),
+	s := newSource("gateway")
+	cfg := GatewayConfig{
+		Env:             s.getenv("ENV", "development"),
+		Addr:            s.getenv("GATEWAY_ADDR", ":8080"),
+		DBPath:          s.getenv("DB_PATH", "./data/smarthotel.db"),
+		MQTTBroker:      s.getenv("MQTT_BROKER", "tcp://localhost:1883"),
+		MQTTClientID:    s.getenv("MQTT_CLIENT_ID", "smarthotel-gateway"),
+		MQTTUsername:    s.getenv("MQTT_USERNAME", ""),
+		MQTTPassword:    s.getenv("MQTT_PASSWORD", ""),
+		MQTTTLS:         s.loadMQTTTLS(),
+		MQTTStatus:      s.loadMQTTStatus(),
+		MQTTOutboxSize:  s.getenvInt("MQTT_OUTBOX_SIZE", 500),
+		MQTTSubscribe:   s.loadMQTTSubscribe(),
+		AuthServiceURL:  s.getenv("AUTH_SERVICE_URL", "http://localhost:8090"),
+		AuthInternalKey: s.getenv("AUTH_INTERNAL_KEY", DevInternalKey),
+		MaxBodyBytes:    int64(s.getenvInt("MAX_BODY_BYTES", 1<<20)),
+		LogFormat:       s.getenv("LOG_FORMAT", "json"),
+		DBPool:          s.loadDBPool(),
+
+		MQTTLegacyEvents: s.getenvBool("MQTT_LEGACY_EVENTS", true),
+		MQTTDrainTimeout: s.getenvDuration("MQTT_DRAIN_TIMEOUT", 5*time.Second),
+
+		StartupWaitTimeout: s.getenvDuration("STARTUP_WAIT_TIMEOUT", 30*time.Second),
+		StartupFailFast:    s.getenvBool("STARTUP_FAIL_FAST", false),
+
+		AuthRetries:      s.getenvInt("AUTH_RETRIES", 2),
+		AuthRetryBackoff: s.getenvDuration("AUTH_RETRY_BACKOFF", 200*time.Millisecond),
+
+		AuthMaxIdleConns:    s.getenvInt("AUTH_MAX_IDLE_CONNS", 32),
+		AuthIdleConnTimeout: s.getenvDuration("AUTH_IDLE_CONN_TIMEOUT", 90*time.Second),
+
+		AuthRoleCacheTTL: s.getenvTTL("AUTH_ROLE_CACHE_TTL", 30*time.Second),
+
+		AttachmentDir:      s.getenv("ATTACHMENT_DIR", "./data/attachments"),
+		AttachmentMaxBytes: int64(s.getenvInt("ATTACHMENT_MAX_BYTES", 5<<20)),
+
+		ChatEditWindow: s.getenvDuration("CHAT_EDIT_WINDOW", 15*time.Minute),
+		ChatMaxLen:     s.getenvInt("CHAT_MAX_LEN", 2000),
+
+		ChatMaxMessages: s.getenvInt("CHAT_MAX_MESSAGES", 1000),
+		ChatCapPolicy:   strings.ToLower(s.getenv("CHAT_CAP_POLICY", "reject")),
+
+		DuplicateTickets:  strings.ToLower(s.getenv("DUPLICATE_TICKETS", "allow")),
+		TypeChangeRouting: strings.ToLower(s.getenv("TYPE_CHANGE_ROUTING", "off")),
+		GuestTicketTypes:  s.getenvList("GUEST_TICKET_TYPES"),
+		RoomRegistry:      s.getenvBool("ROOM_REGISTRY", false),
+		GuestCanReopen:    s.getenvBool("GUEST_CAN_REOPEN", false),
+		Departments:       s.getenvList("AUTH_DEPARTMENTS"),
+
+		SSEKeepAlive:        s.getenvDuration("SSE_KEEP_ALIVE", 15*time.Second),
+		SSEBroadcastBuffer:  s.getenvInt("SSE_BROADCAST_BUFFER", 100),
+		SSEClientBuffer:     s.getenvInt("SSE_CLIENT_BUFFER", 25),
+		SSESlowClientPolicy: strings.ToLower(s.getenv("SSE_SLOW_CLIENT_POLICY", "drop-newest")),
+		SSEDisconnectAfter:  s.getenvInt("SSE_DISCONNECT_AFTER", 3),
+
+		TLSCertFile: s.getenv("TLS_CERT_FILE", ""),
+		TLSKeyFile:  s.getenv("TLS_KEY_FILE", ""),
+
+		CookieSecure:   s.getenvBool("COOKIE_SECURE", false),
+		CookieSameSite: s.getenv("COOKIE_SAMESITE", "lax"),
+
+		SessionRevalidateInterval: s.getenvDuration("SESSION_REVALIDATE_INTERVAL", time.Minute),
+
+		TicketSLA:           s.getenvDuration("TICKET_SLA", 24*time.Hour),
+		OverdueScanInterval: s.getenvDuration("OVERDUE_SCAN_INTERVAL", time.Minute),
+
+		TicketRetention: s.getenvDuration("TICKET_RETENTION", 0),
+		PurgeInterval:   s.getenvDuration("PURGE_INTERVAL", time.Hour),
+
+		RateLimitWindow: s.getenvDuration("RATE_LIMIT_WINDOW", time.Minute),
+		RateLimitGuest:  s.getenvInt("RATE_LIMIT_GUEST", 30),
+		RateLimitStaff:  s.getenvInt("RATE_LIMIT_STAFF", 120),
+		RateLimitAdmin:  s.getenvInt("RATE_LIMIT_ADMIN", 0),
+		RateLimitAnon:   s.getenvInt("RATE_LIMIT_ANON", 60),
+
+		LoginRateLimit:  s.getenvInt("LOGIN_RATE_LIMIT", 10),
+		LoginRateWindow: s.getenvDuration("LOGIN_RATE_WINDOW", time.Minute),
+
+		ChatTypingLimit:  s.getenvInt("CHAT_TYPING_LIMIT", 20),
+		ChatTypingWindow: s.getenvDuration("CHAT_TYPING_WINDOW", time.Minute),
+	}
+	require(map[string]string{
+		"GATEWAY_ADDR":      cfg.Addr,
+		"DB_PATH":           cfg.DBPath,
+		"MQTT_BROKER":       cfg.MQTTBroker,
+		"MQTT_CLIENT_ID":    cfg.MQTTClientID,
+		"AUTH_SERVICE_URL":  cfg.AuthServiceURL,
+		"AUTH_INTERNAL_KEY": cfg.AuthInternalKey,
+	})
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		fail(errors.New("config: TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	switch cfg.DuplicateTickets {
+	case "allow", "warn", "block":
+	default:
+		fail(fmt.Errorf("config: DUPLICATE_TICKETS must be allow, warn or block, got %q", cfg.DuplicateTickets))
 	}
+	switch cfg.TypeChangeRouting {
+	case "off", "suggest", "auto":
+	default:
+		fail(fmt.Errorf("config: TYPE_CHANGE_ROUTING must be off, suggest or auto, got %q", cfg.TypeChangeRouting))
+	}
+	switch cfg.ChatCapPolicy {
+	case "reject", "prune":
+	default:
+		fail(fmt.Errorf("config: CHAT_CAP_POLICY must be reject or prune, got %q", cfg.ChatCapPolicy))
+	}
+	switch cfg.SSESlowClientPolicy {
+	case "drop-newest", "drop-oldest", "disconnect":
+	default:
+		fail(fmt.Errorf("config: SSE_SLOW_CLIENT_POLICY must be drop-newest, drop-oldest or disconnect, got %q", cfg.SSESlowClientPolicy))
+	}
+	if cfg.TLSEnabled() {
+		cfg.CookieSecure = true
+	}
+	return cfg
+}
+
+// TLSEnabled reports whether the gateway serves HTTPS itself.
+func (c GatewayConfig) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
 }
 
 func LoadAuth() AuthConfig {
-	return AuthConfig{
-		Addr:           getenv("AUTH_ADDR", ":8090"),
-		DBPath:         getenv("AUTH_DB_PATH", "./data/smarthotel_auth.db"),
-		InternalKey:    getenv("AUTH_INTERNAL_KEY", "dev-internal-key"),
+	s := newSource("auth")
+	cfg := AuthConfig{
+		Env:            s.getenv("ENV", "development"),
+		Addr:           s.getenv("AUTH_ADDR", ":8090"),
+		DBPath:         s.getenv("AUTH_DB_PATH", "./data/smarthotel_auth.db"),
+		InternalKey:    s.getenv("AUTH_INTERNAL_KEY", DevInternalKey),
 		BootstrapAdmin: true,
-		BootstrapUser:  getenv("AUTH_BOOTSTRAP_ADMIN_USER", "admin"),
-		BootstrapPass:  getenv("AUTH_BOOTSTRAP_ADMIN_PASS", "admin123"),
+		BootstrapUser:  s.getenv("AUTH_BOOTSTRAP_ADMIN_USER", "admin"),
+		BootstrapPass:  s.getenv("AUTH_BOOTSTRAP_ADMIN_PASS", "admin123"),
+		MaxBodyBytes:   int64(s.getenvInt("MAX_BODY_BYTES", 1<<20)),
+		LogFormat:      s.getenv("LOG_FORMAT", "json"),
+		DBPool:         s.loadDBPool(),
+
+		UsernameCaseInsensitive: s.getenvBool("USERNAME_CASE_INSENSITIVE", false),
+
+		Departments: s.getenvList("AUTH_DEPARTMENTS"),
 	}
+	require(map[string]string{
+		"AUTH_ADDR":         cfg.Addr,
+		"AUTH_DB_PATH":      cfg.DBPath,
+		"AUTH_INTERNAL_KEY": cfg.InternalKey,
+	})
+	return cfg
 }
 
 func LoadNotifier() NotifierConfig {
-	return NotifierConfig{
-		Addr:            getenv("NOTIFIER_ADDR", ":8081"),
-		MQTTBroker:      getenv("MQTT_BROKER", "tcp://localhost:1883"),
-		MQTTClientID:    getenv("MQTT_CLIENT_ID", "smarthotel-notifier"),
-		EventBufferSize: getenv("EVENT_BUFFER_SIZE", "50"),
+	s := newSource("notifier")
+	cfg := NotifierConfig{
+		Addr:             s.getenv("NOTIFIER_ADDR", ":8081"),
+		MQTTBroker:       s.getenv("MQTT_BROKER", "tcp://localhost:1883"),
+		MQTTClientID:     s.getenv("MQTT_CLIENT_ID", "smarthotel-notifier"),
+		MQTTUsername:     s.getenv("MQTT_USERNAME", ""),
+		MQTTPassword:     s.getenv("MQTT_PASSWORD", ""),
+		MQTTTLS:          s.loadMQTTTLS(),
+		MQTTStatus:       s.loadMQTTStatus(),
+		MQTTSubscribe:    s.loadMQTTSubscribe(),
+		EventBufferSize:  s.getenv("EVENT_BUFFER_SIZE", "50"),
+		LogFormat:        s.getenv("LOG_FORMAT", "json"),
+		EventDedupWindow: s.getenvDuration("EVENT_DEDUP_WINDOW", 30*time.Second),
+		WebhookURLs:      s.getenvList("WEBHOOK_URLS"),
+		WebhookTimeout:   s.getenvDuration("WEBHOOK_TIMEOUT", 5*time.Second),
+
+		WebhookMode:     strings.ToLower(s.getenv("WEBHOOK_MODE", "immediate")),
+		DigestInterval:  s.getenvDuration("DIGEST_INTERVAL", 15*time.Minute),
+		DigestMaxEvents: s.getenvInt("DIGEST_MAX_EVENTS", 100),
+
+		QuietHoursStart: s.getenv("QUIET_HOURS_START", ""),
+		QuietHoursEnd:   s.getenv("QUIET_HOURS_END", ""),
+		QuietHoursTZ:    s.getenv("QUIET_HOURS_TZ", "UTC"),
 	}
+	require(map[string]string{
+		"NOTIFIER_ADDR":  cfg.Addr,
+		"MQTT_BROKER":    cfg.MQTTBroker,
+		"MQTT_CLIENT_ID": cfg.MQTTClientID,
+	})
+	return cfg
 }
 
-func getenv(k, def string) string {
-	if v := os.Getenv(k); v != "" {
+// IsProduction reports whether env names a production deployment.
+func IsProduction(env string) bool {
+	switch strings.ToLower(strings.TrimSpace(env)) {
+	case "production", "prod":
+		return true
+	}
+	return false
+}
+
+// WeakInternalKey reports whether key is blank or the development default,
+// either of which leaves the auth service's internal endpoints open.
+func WeakInternalKey(key string) bool {
+	key = strings.TrimSpace(key)
+	return key == "" || key == DevInternalKey
+}
+
+func (s source) loadDBPool() DBPoolConfig {
+	return DBPoolConfig{
+		MaxOpenConns:    s.getenvInt("DB_MAX_OPEN_CONNS", 4),
+		MaxIdleConns:    s.getenvInt("DB_MAX_IDLE_CONNS", 4),
+		ConnMaxLifetime: s.getenvDuration("DB_CONN_MAX_LIFETIME", time.Hour),
+	}
+}
+
+func (s source) loadMQTTTLS() MQTTTLSConfig {
+	return MQTTTLSConfig{
+		CAFile:             s.getenv("MQTT_TLS_CA_FILE", ""),
+		CertFile:           s.getenv("MQTT_TLS_CERT_FILE", ""),
+		KeyFile:            s.getenv("MQTT_TLS_KEY_FILE", ""),
+		InsecureSkipVerify: s.getenvBool("MQTT_TLS_INSECURE", false),
+	}
+}
+
+func (s source) loadMQTTStatus() MQTTStatusConfig {
+	return MQTTStatusConfig{
+		Enabled: s.getenvBool("MQTT_STATUS_ENABLED", true),
+		Topic:   s.getenv("MQTT_STATUS_TOPIC", ""),
+	}
+}
+
+func (s source) loadMQTTSubscribe() MQTTSubscribeConfig {
+	return MQTTSubscribeConfig{
+		Attempts: s.getenvInt("MQTT_SUBSCRIBE_ATTEMPTS", 5),
+		Backoff:  s.getenvDuration("MQTT_SUBSCRIBE_BACKOFF", 500*time.Millisecond),
+		FailFast: s.getenvBool("MQTT_SUBSCRIBE_FAIL_FAST", false),
+	}
+}
+
+func (s source) getenv(k, def string) string {
+	if v, ok := s.lookup(k); ok {
 		return v
 	}
 	return def
 }
+
+func (s source) getenvDuration(k string, def time.Duration) time.Duration {
+	if v, ok := s.lookup(k); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
+
+// getenvTTL reads a cache lifetime where 0 turns the cache off. Unlike
+// getenvDuration it fails on a value that doesn't parse instead of quietly
+// using def.
+func (s source) getenvTTL(k string, def time.Duration) time.Duration {
+	v, ok := s.lookup(k)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		fail(fmt.Errorf("config: %s must be a duration such as 30s, or 0 to disable, got %q", k, v))
+	}
+	return d
+}
+
+func (s source) getenvInt(k string, def int) int {
+	if v, ok := s.lookup(k); ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// getenvList splits a comma-separated setting, dropping blank entries.
+func (s source) getenvList(k string) []string {
+	v, _ := s.lookup(k)
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func (s source) getenvBool(k string, def bool) bool {
+	if v, ok := s.lookup(k); ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
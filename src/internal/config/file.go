@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A config file (path in CONFIG_FILE) is a JSON object keyed by the same
+// names as the environment variables. Top-level keys apply to every service;
+// the optional "gateway", "auth" and "notifier" objects override them for
+// that service only:
+//
+//	{
+//	  "MQTT_BROKER": "tcp://mqtt:1883",
+//	  "AUTH_INTERNAL_KEY": "s3cret",
+//	  "gateway":  {"MQTT_CLIENT_ID": "smarthotel-gateway", "TICKET_SLA": "12h"},
+//	  "notifier": {"MQTT_CLIENT_ID": "smarthotel-notifier"}
+//	}
+//
+// Environment variables win over the file, and the file over the defaults.
+
+var services = map[string]bool{"gateway": true, "auth": true, "notifier": true}
+
+// source resolves settings for one service from the environment and the
+// config file.
+type source struct {
+	file map[string]string
+}
+
+// newSource reads CONFIG_FILE, if set, for service. A file that can't be
+// read or parsed stops the process: running on defaults instead of the
+// intended settings is worse than not starting.
+func newSource(service string) source {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return source{}
+	}
+	values, err := readFile(path, service)
+	if err != nil {
+		fail(err)
+	}
+	return source{file: values}
+}
+
+func readFile(path, service string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: read %s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("config: parse %s: %w", path, err)
+	}
+
+	out := map[string]string{}
+	if err := flatten(out, raw, ""); err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	if sect, ok := raw[service].(map[string]any); ok {
+		if err := flatten(out, sect, service+"."); err != nil {
+			return nil, fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+	return out, nil
+}
+
+// flatten copies the scalar values of m into out as strings. Service
+// sections at the top level are skipped; the caller merges its own.
+func flatten(out map[string]string, m map[string]any, prefix string) error {
+	for k, v := range m {
+		switch v := v.(type) {
+		case string:
+			out[k] = v
+		case json.Number:
+			out[k] = v.String()
+		case bool:
+			out[k] = strconv.FormatBool(v)
+		case map[string]any:
+			if prefix == "" && services[k] {
+				continue
+			}
+			return fmt.Errorf("%s%s: nested objects are only allowed for gateway, auth and notifier", prefix, k)
+		default:
+			return fmt.Errorf("%s%s: value must be a string, number or boolean", prefix, k)
+		}
+	}
+	return nil
+}
+
+func (s source) lookup(k string) (string, bool) {
+	if v := os.Getenv(k); v != "" {
+		return v, true
+	}
+	v, ok := s.file[k]
+	return v, ok
+}
+
+// require fails with a single error naming every key whose resolved value
+// is empty.
+func require(fields map[string]string) {
+	var missing []string
+	for k, v := range fields {
+		if strings.TrimSpace(v) == "" {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		fail(fmt.Errorf("config: missing required settings: %s", strings.Join(missing, ", ")))
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
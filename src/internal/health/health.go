@@ -0,0 +1,65 @@
+// Package health serves the liveness and readiness probes every service
+// exposes next to its legacy /health.
+//
+// GET /healthz answers 200 as soon as the HTTP server is up: the process
+// is alive and restarting it won't help. GET /readyz runs the service's
+// dependency checks and answers 503 until all of them pass, so an
+// orchestrator holds traffic back while the DB, broker or auth service is
+// unreachable.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds each readiness check.
+const checkTimeout = 2 * time.Second
+
+// Check is one named dependency check. Run returns nil when the
+// dependency is usable.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Liveness returns the /healthz handler.
+func Liveness(service string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		write(w, http.StatusOK, map[string]any{"status": "ok", "service": service})
+	}
+}
+
+// Readiness returns the /readyz handler. Checks run in order, each with
+// its own timeout; the reply lists every result.
+func Readiness(service string, checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results := make(map[string]string, len(checks))
+		ready := true
+		for _, c := range checks {
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			err := c.Run(ctx)
+			cancel()
+			if err != nil {
+				ready = false
+				results[c.Name] = err.Error()
+				continue
+			}
+			results[c.Name] = "ok"
+		}
+
+		status, code := "ready", http.StatusOK
+		if !ready {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+		write(w, code, map[string]any{"status": status, "service": service, "checks": results})
+	}
+}
+
+func write(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
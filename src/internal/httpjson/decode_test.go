@@ -0,0 +1,62 @@
+package httpjson
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeErrorMessages(t *testing.T) {
+	type body struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	tests := []struct {
+		name       string
+		body       string
+		limit      int64
+		wantStatus int
+		wantMsg    string
+	}{
+		{"empty body", "", 0, http.StatusBadRequest, "request body is empty"},
+		{"truncated", `{"name":"x"`, 0, http.StatusBadRequest, "invalid json: unexpected end of body"},
+		{"syntax error", `{"name":}`, 0, http.StatusBadRequest, "invalid json: syntax error at offset 9"},
+		{"wrong field type", `{"count":"three"}`, 0, http.StatusBadRequest, `invalid json: field "count" must be int`},
+		{"wrong top-level type", `[1,2]`, 0, http.StatusBadRequest, "invalid json: expected httpjson.body at offset 1"},
+		{"unknown field", `{"name":"x","colour":"red"}`, 0, http.StatusBadRequest, `invalid json: unknown field "colour"`},
+		{"too large", `{"name":"` + strings.Repeat("x", 64) + `"}`, 16, http.StatusRequestEntityTooLarge, "request body too large (max 16 bytes)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.limit > 0 {
+				r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, tt.limit)
+			}
+			var v body
+			err := Decode(r, &v, true)
+			if err == nil {
+				t.Fatal("Decode succeeded, want an error")
+			}
+			if got := Status(err); got != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", got, tt.wantStatus)
+			}
+			if got := ErrorMessage(err); got != tt.wantMsg {
+				t.Errorf("ErrorMessage = %q, want %q", got, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestDecodeLenientAllowsUnknownFields(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"x","colour":"red"}`))
+	var v struct {
+		Name string `json:"name"`
+	}
+	if err := Decode(r, &v, false); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if v.Name != "x" {
+		t.Errorf("Name = %q, want x", v.Name)
+	}
+}
@@ -0,0 +1,100 @@
+package httpjson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodyBytes is the request body cap used when none is configured.
+const DefaultMaxBodyBytes int64 = 1 << 20
+
+type origBodyKey struct{}
+
+// LimitBody caps every request body at n bytes so an oversized upload fails
+// in the decoder instead of exhausting memory.
+func LimitBody(n int64) func(http.Handler) http.Handler {
+	if n <= 0 {
+		n = DefaultMaxBodyBytes
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r = r.WithContext(context.WithValue(r.Context(), origBodyKey{}, r.Body))
+				r.Body = http.MaxBytesReader(w, r.Body, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithBodyLimit replaces the router-wide LimitBody cap with n for the routes
+// it wraps, e.g. to allow larger file uploads on a single endpoint.
+func WithBodyLimit(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := r.Body
+			if orig, ok := r.Context().Value(origBodyKey{}).(io.ReadCloser); ok {
+				body = orig
+			}
+			if body != nil {
+				r.Body = http.MaxBytesReader(w, body, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Decode reads a single JSON value from the request body into v.
+// With strict set, fields not present in v are rejected.
+func Decode(r *http.Request, v any, strict bool) error {
+	defer r.Body.Close()
+	dec := json.NewDecoder(r.Body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+}
+
+// Status is the HTTP status to answer a Decode error with: 413 when the body
+// exceeded the LimitBody cap, 400 otherwise.
+func Status(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// ErrorMessage turns a Decode error into a message that tells the client
+// what was wrong with the body instead of a bare "invalid json".
+func ErrorMessage(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &tooLarge):
+		return fmt.Sprintf("request body too large (max %d bytes)", tooLarge.Limit)
+	case errors.Is(err, io.EOF):
+		return "request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "invalid json: unexpected end of body"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("invalid json: syntax error at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			return fmt.Sprintf("invalid json: field %q must be %s", typeErr.Field, typeErr.Type)
+		}
+		return fmt.Sprintf("invalid json: expected %s at offset %d", typeErr.Type, typeErr.Offset)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this case
+		return "invalid json: unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	default:
+		return "invalid json"
+	}
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// New returns a logger for service writing to stdout. format is "json"
+// (the default, one object per line for log aggregators) or "text" for a
+// readable console in local dev.
+func New(service, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+
+	var h slog.Handler
+	if strings.EqualFold(format, "text") {
+		h = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		h = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	return slog.New(h).With("service", service)
+}
+
+// Fatal logs msg at error level and exits.
+func Fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+// ForRequest returns l tagged with the request ID set by middleware.RequestID.
+func ForRequest(l *slog.Logger, r *http.Request) *slog.Logger {
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		return l.With("request_id", id)
+	}
+	return l
+}
+
+// RequestLogger logs one line per request with its request ID, status and
+// duration. It must run after middleware.RequestID.
+func RequestLogger(l *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+			defer func() {
+				ForRequest(l, r).Info("request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"status", ww.Status(),
+					"bytes", ww.BytesWritten(),
+					"duration_ms", time.Since(start).Milliseconds(),
+					"remote", r.RemoteAddr,
+				)
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
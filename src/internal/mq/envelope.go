@@ -0,0 +1,74 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// EnvelopeVersion is the current event envelope format.
+const EnvelopeVersion = 1
+
+// Envelope wraps every ticket and chat event:
+//
+//	{"v":1,"type":"status_updated","ts":"2024-05-01T10:00:00Z","data":{...}}
+//
+// Consumers switch on Type and decode Data; a format change that existing
+// consumers can't ignore bumps V.
+type Envelope struct {
+	V    int             `json:"v"`
+	Type string          `json:"type"`
+	TS   time.Time       `json:"ts"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Encode wraps data in an Envelope of type typ stamped with ts.
+//
+// With legacy set, data's own top-level fields are repeated next to the
+// envelope keys, so consumers still reading the pre-envelope
+// {"event":...,"ticket":...} shape keep working while they migrate. data
+// must then encode to a JSON object.
+func Encode(typ string, ts time.Time, data any, legacy bool) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	env := Envelope{V: EnvelopeVersion, Type: typ, TS: ts.UTC(), Data: raw}
+	if !legacy {
+		return json.Marshal(env)
+	}
+
+	var flat map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &flat); err != nil {
+		return nil, errors.New("mq: legacy envelope needs an object payload")
+	}
+	for k, v := range map[string]any{"v": env.V, "type": env.Type, "ts": env.TS, "data": env.Data} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		flat[k] = b
+	}
+	return json.Marshal(flat)
+}
+
+// Decode reads an event payload in either format. A pre-envelope payload
+// comes back with V 0, Type taken from its "event" field and the whole
+// payload as Data.
+func Decode(payload []byte) (Envelope, error) {
+	var probe struct {
+		V     int    `json:"v"`
+		Event string `json:"event"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return Envelope{}, err
+	}
+	if probe.V == 0 {
+		return Envelope{Type: probe.Event, Data: json.RawMessage(payload)}, nil
+	}
+	var env Envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return Envelope{}, err
+	}
+	return env, nil
+}
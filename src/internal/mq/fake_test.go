@@ -0,0 +1,76 @@
+package mq
+
+import (
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeClient is an mqtt.Client that records publishes and whose connection
+// state the test controls. Methods the tests don't use panic via the nil
+// embed.
+type fakeClient struct {
+	mqtt.Client
+
+	mu           sync.Mutex
+	connected    bool
+	published    []fakePublish
+	disconnected bool
+	// onPublish, when set, runs before a publish is recorded.
+	onPublish func(topic string)
+}
+
+type fakePublish struct {
+	Topic   string
+	QoS     byte
+	Retain  bool
+	Payload []byte
+}
+
+func (f *fakeClient) IsConnected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connected
+}
+
+func (f *fakeClient) setConnected(v bool) {
+	f.mu.Lock()
+	f.connected = v
+	f.mu.Unlock()
+}
+
+func (f *fakeClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	if f.onPublish != nil {
+		f.onPublish(topic)
+	}
+	b, _ := payload.([]byte)
+	f.mu.Lock()
+	f.published = append(f.published, fakePublish{Topic: topic, QoS: qos, Retain: retained, Payload: append([]byte(nil), b...)})
+	f.mu.Unlock()
+	return doneToken{}
+}
+
+func (f *fakeClient) Disconnect(uint) {
+	f.mu.Lock()
+	f.disconnected = true
+	f.connected = false
+	f.mu.Unlock()
+}
+
+func (f *fakeClient) Published() []fakePublish {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakePublish(nil), f.published...)
+}
+
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+func (doneToken) Error() error { return nil }
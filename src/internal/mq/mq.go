@@ -1,9 +1,15 @@
 package mq
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -13,21 +19,106 @@ const (
 	TopicTicketCreated       = "smarthotel/tickets/created"
 	TopicTicketStatusUpdated = "smarthotel/tickets/status_updated"
 	TopicTicketAssigned      = "smarthotel/tickets/assigned"
+	TopicTicketOverdue       = "smarthotel/tickets/overdue"
+	TopicTicketCancelled     = "smarthotel/tickets/cancelled"
+	TopicTicketDeleted       = "smarthotel/tickets/deleted"
+
+	// A specialist proposed for a retyped ticket; nothing was assigned.
+	TopicTicketReassignSuggested = "smarthotel/tickets/reassign_suggested"
+
+	// Latest state per ticket, retained so new subscribers get it at once
+	TopicTicketStatePrefix   = "smarthotel/tickets/state/"
+	TopicTicketStateWildcard = "smarthotel/tickets/state/+"
 
 	// Chat
 	TopicChatTicketPrefix   = "smarthotel/chat/ticket/"
 	TopicChatTicketWildcard = "smarthotel/chat/ticket/+"
+
+	// Service liveness (retained birth / last-will messages)
+	TopicServiceStatusWildcard = "smarthotel/services/+/status"
 )
 
 type Config struct {
 	BrokerURL string
 	ClientID  string
-	Logger    *log.Logger
+	Logger    *slog.Logger
+
+	// Optional broker credentials; blank connects anonymously.
+	Username string
+	Password string
+
+	TLS TLSConfig
+
+	// Outbox, if set, is flushed every time the client (re)connects.
+	Outbox *Outbox
+
+	// Subscriptions are (re)established on every connect, since the client
+	// uses a clean session and the broker forgets them on reconnect.
+	// Failures are recorded in SubscriptionState when it is set.
+	Subscriptions     []Subscription
+	Retry             RetryPolicy
+	SubscriptionState *SubscriptionState
+
+	// Status publishes a retained {"service","status":"online"} message on
+	// connect and registers an "offline" Last Will on the same topic.
+	Status StatusConfig
+}
+
+type StatusConfig struct {
+	Enabled bool
+	Service string // defaults to ClientID
+	Topic   string // defaults to smarthotel/services/<clientid>/status
+}
+
+type ServiceStatus struct {
+	Service string `json:"service"`
+	Status  string `json:"status"` // "online" or "offline"
+}
+
+// TLSConfig configures ssl:// / mqtts:// connections. CAFile adds a custom
+// root CA; CertFile and KeyFile enable client-certificate auth.
+type TLSConfig struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.InsecureSkipVerify
 }
 
 func ChatTopic(ticketID int64) string {
 	return fmt.Sprintf("%s%d", TopicChatTicketPrefix, ticketID)
 }
+
+func TicketStateTopic(ticketID int64) string {
+	return fmt.Sprintf("%s%d", TopicTicketStatePrefix, ticketID)
+}
+
+// PublishOptions are the MQTT delivery settings for a topic.
+type PublishOptions struct {
+	QoS    byte
+	Retain bool
+}
+
+// OptionsFor returns the delivery settings for topic. Event topics are
+// QoS 1 and not retained; ticket state topics are retained so a freshly
+// connected dashboard receives the current state immediately.
+func OptionsFor(topic string) PublishOptions {
+	if strings.HasPrefix(topic, TopicTicketStatePrefix) {
+		return PublishOptions{QoS: 1, Retain: true}
+	}
+	return PublishOptions{QoS: 1}
+}
+
+func ServiceStatusTopic(clientID string) string {
+	return "smarthotel/services/" + clientID + "/status"
+}
+
+// Connect dials the broker and waits for the first subscription pass. If a
+// critical subscription fails it returns the connected client along with a
+// *SubscribeError; the client keeps retrying on later reconnects.
 func Connect(cfg Config) (mqtt.Client, error) {
 	if cfg.BrokerURL == "" {
 		return nil, errors.New("MQTT broker URL is empty")
@@ -45,13 +136,53 @@ func Connect(cfg Config) (mqtt.Client, error) {
 		SetConnectRetry(true).
 		SetConnectRetryInterval(2 * time.Second)
 
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+	}
+	if cfg.Password != "" {
+		opts.SetPassword(cfg.Password)
+	}
+
+	if cfg.TLS.enabled() || isTLSBroker(cfg.BrokerURL) {
+		tlsCfg, err := buildTLS(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsCfg)
+	}
+
+	var online []byte
+	if cfg.Status.Enabled {
+		cfg.Status = cfg.Status.withDefaults(cfg.ClientID)
+		offline, _ := json.Marshal(ServiceStatus{Service: cfg.Status.Service, Status: "offline"})
+		online, _ = json.Marshal(ServiceStatus{Service: cfg.Status.Service, Status: "online"})
+		opts.SetBinaryWill(cfg.Status.Topic, offline, 1, true)
+	}
+
 	if cfg.Logger != nil {
 		opts.OnConnectionLost = func(_ mqtt.Client, err error) {
-			cfg.Logger.Printf("mqtt connection lost: %v", err)
+			cfg.Logger.Warn("mqtt connection lost", "err", err)
+		}
+	}
+	// The first subscription pass reports back to Connect so callers can
+	// decide whether to start without a critical topic.
+	initial := make(chan error, 1)
+	var once sync.Once
+
+	opts.OnConnect = func(c mqtt.Client) {
+		if cfg.Logger != nil {
+			cfg.Logger.Info("mqtt connected", "broker", cfg.BrokerURL, "client_id", cfg.ClientID)
 		}
-		opts.OnConnect = func(_ mqtt.Client) {
-			cfg.Logger.Printf("mqtt connected broker=%s client_id=%s", cfg.BrokerURL, cfg.ClientID)
+		if online != nil {
+			// Runs on paho's callback goroutine, so don't block on the token.
+			c.Publish(cfg.Status.Topic, 1, true, online)
 		}
+		if cfg.Outbox != nil {
+			go cfg.Outbox.Flush(c, cfg.Logger)
+		}
+		// paho runs OnConnect on its own goroutine, so retrying here is fine.
+		err := SubscribeAll(c, cfg.Subscriptions, cfg.Retry, cfg.SubscriptionState, cfg.Logger)
+		once.Do(func() { initial <- err })
 	}
 
 	c := mqtt.NewClient(opts)
@@ -60,5 +191,84 @@ func Connect(cfg Config) (mqtt.Client, error) {
 	if err := tok.Error(); err != nil {
 		return nil, err
 	}
+	// A *SubscribeError comes back together with the connected client.
+	if err := <-initial; err != nil {
+		return c, err
+	}
 	return c, nil
 }
+
+// Disconnect closes c after quiesce milliseconds. With cfg.Status enabled it
+// first publishes the retained "offline" status and waits for the broker to
+// acknowledge it: a clean disconnect doesn't fire the Last Will, so the
+// topic would otherwise keep saying "online". cfg is the one given to
+// Connect.
+func Disconnect(c mqtt.Client, cfg Config, quiesce uint) {
+	if cfg.Status.Enabled && c.IsConnected() {
+		if cfg.ClientID == "" {
+			cfg.ClientID = "smarthotel-client"
+		}
+		st := cfg.Status.withDefaults(cfg.ClientID)
+		offline, _ := json.Marshal(ServiceStatus{Service: st.Service, Status: "offline"})
+		tok := c.Publish(st.Topic, 1, true, offline)
+		err := errors.New("mq: publish timed out")
+		if tok.WaitTimeout(publishTimeout) {
+			err = tok.Error()
+		}
+		if err != nil && cfg.Logger != nil {
+			cfg.Logger.Warn("mqtt offline status not sent", "topic", st.Topic, "err", err)
+		}
+	}
+	c.Disconnect(quiesce)
+}
+
+func (s StatusConfig) withDefaults(clientID string) StatusConfig {
+	if s.Service == "" {
+		s.Service = clientID
+	}
+	if s.Topic == "" {
+		s.Topic = ServiceStatusTopic(clientID)
+	}
+	return s
+}
+
+func isTLSBroker(url string) bool {
+	for _, p := range []string{"ssl://", "tls://", "mqtts://", "tcps://"} {
+		if strings.HasPrefix(url, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func buildTLS(t TLSConfig) (*tls.Config, error) {
+	out := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt tls: read CA file %s: %w", t.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mqtt tls: no certificates found in CA file %s", t.CAFile)
+		}
+		out.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, errors.New("mqtt tls: client cert and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt tls: load client cert %s/%s: %w", t.CertFile, t.KeyFile, err)
+		}
+		out.Certificates = []tls.Certificate{cert}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,92 @@
+package mq
+
+import (
+	"log/slog"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Outbox buffers events published while the broker is unreachable and
+// replays them, in order, once the client reconnects. It is bounded: on
+// overflow the oldest event is dropped and counted.
+type Outbox struct {
+	max int
+
+	mu      sync.Mutex
+	items   []outboxItem
+	dropped uint64
+
+	flushMu sync.Mutex
+}
+
+type outboxItem struct {
+	topic   string
+	payload []byte
+}
+
+func NewOutbox(max int) *Outbox {
+	if max <= 0 {
+		max = 500
+	}
+	return &Outbox{max: max}
+}
+
+func (o *Outbox) Add(topic string, payload []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.items) >= o.max {
+		o.items = o.items[1:]
+		o.dropped++
+	}
+	o.items = append(o.items, outboxItem{topic: topic, payload: payload})
+}
+
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.items)
+}
+
+// Dropped reports how many events were discarded because the outbox was full.
+func (o *Outbox) Dropped() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.dropped
+}
+
+// Flush publishes the queued events oldest first. If a publish fails (the
+// connection dropped again) the unsent events go back to the front of the
+// queue for the next reconnect.
+func (o *Outbox) Flush(c mqtt.Client, logger *slog.Logger) {
+	o.flushMu.Lock()
+	defer o.flushMu.Unlock()
+
+	o.mu.Lock()
+	pending := o.items
+	o.items = nil
+	o.mu.Unlock()
+
+	for i, it := range pending {
+		if err := publishRaw(c, it.topic, it.payload); err != nil {
+			if logger != nil {
+				logger.Warn("outbox flush stopped", "topic", it.topic, "err", err, "requeued", len(pending)-i)
+			}
+			o.requeue(pending[i:])
+			return
+		}
+	}
+	if logger != nil && len(pending) > 0 {
+		logger.Info("outbox flushed", "events", len(pending))
+	}
+}
+
+func (o *Outbox) requeue(rest []outboxItem) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.items = append(append([]outboxItem(nil), rest...), o.items...)
+	if over := len(o.items) - o.max; over > 0 {
+		o.items = o.items[over:]
+		o.dropped += uint64(over)
+	}
+}
@@ -0,0 +1,123 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// publishTimeout bounds the wait for the broker to acknowledge a publish.
+const publishTimeout = 3 * time.Second
+
+// ErrNotConnected is returned by Publish when the broker is unreachable and
+// there is no outbox to hold the event.
+var ErrNotConnected = errors.New("mq: not connected")
+
+// Event is implemented by payloads that are published inside an Envelope.
+type Event interface {
+	EventType() string
+	EventTime() time.Time
+}
+
+// Publisher is the single place events are sent to the broker from.
+type Publisher struct {
+	client mqtt.Client
+	outbox *Outbox
+	logger *slog.Logger
+
+	inflight sync.WaitGroup
+
+	// Legacy is passed to Encode for every Event.
+	Legacy bool
+}
+
+// NewPublisher publishes through client. outbox may be nil, in which case
+// events published while the broker is down are dropped.
+func NewPublisher(client mqtt.Client, outbox *Outbox, logger *slog.Logger) *Publisher {
+	return &Publisher{client: client, outbox: outbox, logger: logger}
+}
+
+// Connected reports whether a publish would reach the broker right away.
+func (p *Publisher) Connected() bool {
+	return p.client != nil && p.client.IsConnected()
+}
+
+// Publish sends payload to topic with the topic's OptionsFor settings. An
+// Event is wrapped in an Envelope; anything else is sent as plain JSON.
+// While disconnected the payload goes to the outbox and Publish returns nil.
+// Failures are logged as well as returned.
+func (p *Publisher) Publish(topic string, payload any) error {
+	return p.publish(topic, payload, true)
+}
+
+// PublishEphemeral is Publish for events that are worthless once late, such
+// as typing indicators: while disconnected they are dropped instead of going
+// to the outbox.
+func (p *Publisher) PublishEphemeral(topic string, payload any) error {
+	return p.publish(topic, payload, false)
+}
+
+func (p *Publisher) publish(topic string, payload any, queue bool) error {
+	p.inflight.Add(1)
+	defer p.inflight.Done()
+
+	var b []byte
+	var err error
+	if ev, ok := payload.(Event); ok {
+		b, err = Encode(ev.EventType(), ev.EventTime(), payload, p.Legacy)
+	} else {
+		b, err = json.Marshal(payload)
+	}
+	if err != nil {
+		p.logger.Error("marshal event", "topic", topic, "err", err)
+		return err
+	}
+
+	if !p.Connected() {
+		if p.outbox == nil || !queue {
+			p.logger.Warn("mqtt not connected; skipping publish", "topic", topic)
+			return ErrNotConnected
+		}
+		p.outbox.Add(topic, b)
+		p.logger.Warn("mqtt not connected; queued publish", "topic", topic, "outbox", p.outbox.Len())
+		return nil
+	}
+	if err := publishRaw(p.client, topic, b); err != nil {
+		p.logger.Error("publish", "topic", topic, "err", err)
+		return err
+	}
+	return nil
+}
+
+// Drain waits until every Publish call in progress has returned, or until
+// ctx is done. Call it after the HTTP server has shut down and before
+// disconnecting the client, so an event raised just before shutdown still
+// reaches the broker.
+func (p *Publisher) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// publishRaw publishes b and waits up to publishTimeout for the broker.
+func publishRaw(c mqtt.Client, topic string, b []byte) error {
+	opts := OptionsFor(topic)
+	tok := c.Publish(topic, opts.QoS, opts.Retain, b)
+	if !tok.WaitTimeout(publishTimeout) {
+		return errors.New("mq: publish timed out")
+	}
+	return tok.Error()
+}
@@ -0,0 +1,53 @@
+package mq
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDisconnectPublishesOfflineStatus(t *testing.T) {
+	c := &fakeClient{connected: true}
+	Disconnect(c, Config{ClientID: "gw-1", Status: StatusConfig{Enabled: true, Service: "gateway"}}, 0)
+
+	pubs := c.Published()
+	if len(pubs) != 1 {
+		t.Fatalf("published %d messages, want 1", len(pubs))
+	}
+	p := pubs[0]
+	if p.Topic != ServiceStatusTopic("gw-1") || !p.Retain || p.QoS != 1 {
+		t.Errorf("published to %s (qos %d, retain %v), want retained qos 1 on %s", p.Topic, p.QoS, p.Retain, ServiceStatusTopic("gw-1"))
+	}
+	var st ServiceStatus
+	if err := json.Unmarshal(p.Payload, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st != (ServiceStatus{Service: "gateway", Status: "offline"}) {
+		t.Errorf("status = %+v, want gateway offline", st)
+	}
+	if !c.disconnected {
+		t.Error("client not disconnected")
+	}
+}
+
+func TestDisconnectSkipsOfflineStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		connected bool
+		status    bool
+	}{
+		{"status disabled", true, false},
+		{"already disconnected", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeClient{connected: tt.connected}
+			Disconnect(c, Config{ClientID: "gw-1", Status: StatusConfig{Enabled: tt.status}}, 0)
+			if n := len(c.Published()); n != 0 {
+				t.Errorf("published %d messages, want none", n)
+			}
+			if !c.disconnected {
+				t.Error("client not disconnected")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Subscription is a topic a service listens on. A Critical subscription
+// that can't be established makes SubscribeAll return an error.
+type Subscription struct {
+	Topic    string
+	QoS      byte
+	Handler  mqtt.MessageHandler
+	Critical bool
+}
+
+// RetryPolicy bounds subscribe attempts. The delay starts at Backoff and
+// doubles after every failure, capped at MaxBackoff.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// SubscriptionState tracks which topics are currently not subscribed so the
+// service can report itself as degraded.
+type SubscriptionState struct {
+	mu     sync.Mutex
+	failed map[string]string
+}
+
+func NewSubscriptionState() *SubscriptionState {
+	return &SubscriptionState{failed: map[string]string{}}
+}
+
+func (s *SubscriptionState) set(topic string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.failed, topic)
+		return
+	}
+	s.failed[topic] = err.Error()
+}
+
+// Failed returns the topics whose last subscribe attempt failed, sorted.
+func (s *SubscriptionState) Failed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]string, 0, len(s.failed))
+	for t := range s.failed {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Subscribe subscribes to sub.Topic, retrying with exponential backoff.
+func Subscribe(c mqtt.Client, sub Subscription, p RetryPolicy, logger *slog.Logger) error {
+	attempts := p.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	delay := p.Backoff
+
+	var err error
+	for i := 1; i <= attempts; i++ {
+		tok := c.Subscribe(sub.Topic, sub.QoS, sub.Handler)
+		if !tok.WaitTimeout(5 * time.Second) {
+			err = errors.New("subscribe timed out")
+		} else {
+			err = tok.Error()
+		}
+		if err == nil {
+			if logger != nil {
+				logger.Info("mqtt subscribed", "topic", sub.Topic)
+			}
+			return nil
+		}
+		if logger != nil {
+			logger.Warn("mqtt subscribe failed", "topic", sub.Topic, "attempt", i, "of", attempts, "err", err)
+		}
+		if i < attempts && delay > 0 {
+			time.Sleep(delay)
+			delay *= 2
+			if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+				delay = p.MaxBackoff
+			}
+		}
+	}
+	return err
+}
+
+// SubscribeAll subscribes to every topic in subs, recording the outcome in
+// state (which may be nil). It returns an error naming the critical topics
+// that couldn't be subscribed; non-critical failures only show up in state.
+func SubscribeAll(c mqtt.Client, subs []Subscription, p RetryPolicy, state *SubscriptionState, logger *slog.Logger) error {
+	var critical []string
+	for _, sub := range subs {
+		err := Subscribe(c, sub, p, logger)
+		if state != nil {
+			state.set(sub.Topic, err)
+		}
+		if err != nil && sub.Critical {
+			critical = append(critical, sub.Topic)
+		}
+	}
+	if len(critical) > 0 {
+		return &SubscribeError{Topics: critical}
+	}
+	return nil
+}
+
+// SubscribeError lists the critical topics that couldn't be subscribed.
+type SubscribeError struct {
+	Topics []string
+}
+
+func (e *SubscribeError) Error() string {
+	return fmt.Sprintf("mqtt: could not subscribe to %v", e.Topics)
+}
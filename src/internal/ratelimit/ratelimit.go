@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is an in-memory fixed-window counter keyed by an arbitrary string
+// (client IP, user ID, ...). Each key gets its own window that starts on its
+// first request.
+type Limiter struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	start time.Time
+	count int
+}
+
+func New(window time.Duration) *Limiter {
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &Limiter{
+		window:  window,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow records a request for key and reports whether it is within limit
+// requests for the current window. A limit <= 0 means unlimited.
+func (l *Limiter) Allow(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok || now.Sub(b.start) >= l.window {
+		l.buckets[key] = &bucket{start: now, count: 1}
+		return true
+	}
+	if b.count >= limit {
+		return false
+	}
+	b.count++
+	return true
+}
+
+// RetryAfter is how long until key's current window ends and Allow will
+// accept it again; 0 if it has no open window.
+func (l *Limiter) RetryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		return 0
+	}
+	return max(l.window-time.Since(b.start), 0)
+}
+
+// RunCleanup drops keys whose window has expired, every interval, so the map
+// doesn't grow with every client ever seen. It blocks until ctx is cancelled.
+func (l *Limiter) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for k, b := range l.buckets {
+				if now.Sub(b.start) >= l.window {
+					delete(l.buckets, k)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
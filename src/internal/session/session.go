@@ -1,6 +1,7 @@
 package session
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"sync"
@@ -13,14 +14,24 @@ type Store struct {
 	mu       sync.RWMutex
 	sessions map[string]Session
 	ttl      time.Duration
+
+	revalidateEvery time.Duration
+	revalidate      Revalidator
 }
 
 type Session struct {
 	ID        string
 	User      authclient.User
 	CreatedAt time.Time
+	CheckedAt time.Time // last time User was confirmed against its account
 }
 
+// Revalidator re-reads a session's user from the account it came from.
+// ok false ends the session (the account is gone, or changed so the
+// session no longer describes it); otherwise fresh replaces the stored
+// user. An error keeps the session as it is until the next check.
+type Revalidator func(ctx context.Context, u authclient.User) (fresh authclient.User, ok bool, err error)
+
 func NewStore(ttl time.Duration) *Store {
 	return &Store{
 		sessions: make(map[string]Session),
@@ -39,6 +50,7 @@ func (s *Store) Create(u authclient.User) (Session, error) {
 		ID:        id,
 		User:      u,
 		CreatedAt: now,
+		CheckedAt: now,
 	}
 
 	s.mu.Lock()
@@ -62,6 +74,83 @@ func (s *Store) Get(id string) (Session, bool) {
 	return ss, true
 }
 
+// SetRevalidation makes Lookup run fn on a session at most once per every.
+// Call it before the store is in use.
+func (s *Store) SetRevalidation(every time.Duration, fn Revalidator) {
+	s.revalidateEvery = every
+	s.revalidate = fn
+}
+
+// Lookup is Get, plus revalidation of the session's user when it is due.
+// Concurrent lookups of one session don't all revalidate: the first claims
+// the check and the others carry on with the stored user.
+func (s *Store) Lookup(ctx context.Context, id string) (Session, bool) {
+	ss, ok := s.Get(id)
+	if !ok || s.revalidate == nil {
+		return ss, ok
+	}
+	now := time.Now().UTC()
+	s.mu.Lock()
+	cur, ok := s.sessions[id]
+	due := ok && now.Sub(cur.CheckedAt) >= s.revalidateEvery
+	if due {
+		cur.CheckedAt = now
+		s.sessions[id] = cur
+	}
+	s.mu.Unlock()
+	if !ok {
+		return Session{}, false
+	}
+	if !due {
+		return cur, true
+	}
+
+	fresh, valid, err := s.revalidate(ctx, cur.User)
+	if err != nil {
+		return cur, true
+	}
+	if !valid {
+		s.Delete(id)
+		return Session{}, false
+	}
+	s.mu.Lock()
+	if cur, ok = s.sessions[id]; ok {
+		cur.User = fresh
+		s.sessions[id] = cur
+	}
+	s.mu.Unlock()
+	return cur, ok
+}
+
+// Update replaces the user of a live session, counting as a revalidation.
+// It reports false when the session no longer exists.
+func (s *Store) Update(id string, u authclient.User) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	ss.User = u
+	ss.CheckedAt = time.Now().UTC()
+	s.sessions[id] = ss
+	return ss, true
+}
+
+// DeleteUser ends every session of user id and returns how many there were.
+func (s *Store) DeleteUser(id int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for sid, ss := range s.sessions {
+		if ss.User.ID == id {
+			delete(s.sessions, sid)
+			n++
+		}
+	}
+	return n
+}
+
 func (s *Store) Delete(id string) {
 	s.mu.Lock()
 	delete(s.sessions, id)
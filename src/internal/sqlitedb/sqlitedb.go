@@ -0,0 +1,68 @@
+package sqlitedb
+
+import (
+	"database/sql"
+	"net/url"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// BusyTimeoutMillis is how long a connection waits on a locked database
+// before failing with "database is locked".
+const BusyTimeoutMillis = 5000
+
+// Pool bounds the connection pool. SQLite has a single writer, so extra
+// connections only help concurrent reads (which WAL allows); with too many,
+// writers just queue on busy_timeout. Zero fields use the defaults below.
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+const (
+	DefaultMaxOpenConns    = 4
+	DefaultConnMaxLifetime = time.Hour
+)
+
+// Open opens the SQLite file at path with the settings both services need
+// under concurrent use. They are passed as _pragma DSN parameters so the
+// driver applies them to every pooled connection, not just the first:
+//
+//   - busy_timeout: wait for a competing writer instead of failing at once.
+//   - journal_mode=WAL: readers no longer block the writer or each other.
+//   - foreign_keys=ON: SQLite leaves FK enforcement off unless asked.
+//   - synchronous=NORMAL: the usual pairing with WAL; durable across app
+//     crashes, at worst loses the last commit on power loss.
+//
+// Transactions start with BEGIN IMMEDIATE (_txlock), taking the write lock
+// up front so two read-then-write transactions can't deadlock on upgrade.
+func Open(path string, pool Pool) (*sql.DB, error) {
+	q := url.Values{}
+	q.Add("_pragma", "busy_timeout("+strconv.Itoa(BusyTimeoutMillis)+")")
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "foreign_keys(1)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	q.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.MaxOpenConns <= 0 {
+		pool.MaxOpenConns = DefaultMaxOpenConns
+	}
+	if pool.MaxIdleConns <= 0 || pool.MaxIdleConns > pool.MaxOpenConns {
+		// Keep every connection: reopening one re-runs the pragmas.
+		pool.MaxIdleConns = pool.MaxOpenConns
+	}
+	if pool.ConnMaxLifetime <= 0 {
+		pool.ConnMaxLifetime = DefaultConnMaxLifetime
+	}
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	return db, nil
+}
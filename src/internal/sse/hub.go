@@ -3,73 +3,348 @@ package sse
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"src/internal/authclient"
+)
+
+// Meta describes which ticket an event concerns so each connection can
+// decide whether its user may see it. The zero value is visible to admins only.
+type Meta struct {
+	Room             string
+	AssignedToUserID int64
+	// Department an unclaimed ticket is routed to; its staff see the event.
+	Department string
+	// CreatedByUserID is the guest who raised the ticket; they see the
+	// event wherever they are staying now.
+	CreatedByUserID int64
+
+	// SenderUserID, when set, keeps the event from the sender's own
+	// connections, e.g. their typing indicator.
+	SenderUserID int64
+	// Ephemeral events are streamed live but left out of the replay buffer,
+	// since they mean nothing to a client catching up later.
+	Ephemeral bool
+}
+
+// ReplayBufferSize is how many recent events the hub keeps for clients that
+// reconnect with a Last-Event-ID header. A client that missed more than this
+// many events silently loses the oldest ones and should refetch state.
+const ReplayBufferSize = 200
+
+// Defaults for zero Options fields.
+const (
+	DefaultKeepAlive       = 15 * time.Second
+	DefaultBroadcastBuffer = 100
+	DefaultClientBuffer    = 25
+	DefaultDisconnectAfter = 3
+)
+
+// Policy says what the hub does with an event for a client whose buffer is
+// full.
+type Policy string
+
+const (
+	// DropNewest skips the new event for that client.
+	DropNewest Policy = "drop-newest"
+	// DropOldest discards the client's oldest queued event to make room.
+	DropOldest Policy = "drop-oldest"
+	// Disconnect drops the event and, once a client has been full
+	// DisconnectAfter times in a row, closes its stream so it reconnects
+	// and catches up through Last-Event-ID.
+	Disconnect Policy = "disconnect"
 )
 
+// Options tunes a Hub; zero fields take the defaults above and DropNewest.
+type Options struct {
+	KeepAlive       time.Duration
+	BroadcastBuffer int // events waiting for Run; Broadcast blocks when full
+	ClientBuffer    int // events waiting for one slow connection
+	Policy          Policy
+	DisconnectAfter int
+}
+
+type message struct {
+	id     uint64
+	data   []byte
+	meta   Meta
+	notice bool // hub-generated; sent regardless of id and filter
+}
+
+// client is the hub's view of one connection. user is who opened it, zero
+// for unfiltered streams. full counts consecutive deliveries that found its
+// buffer full; missed counts events it has lost since it was last told.
+type client struct {
+	user   authclient.User
+	full   int
+	missed uint64
+}
+
+// registration is a new connection and the user behind it.
+type registration struct {
+	ch   chan message
+	user authclient.User
+}
+
+// Presence is one user with at least one open stream.
+type Presence struct {
+	UserID      int64  `json:"user_id"`
+	Username    string `json:"username"`
+	Role        string `json:"role"`
+	Connections int    `json:"connections"`
+}
+
 type Hub struct {
-	logger *log.Logger
+	logger *slog.Logger
+	opts   Options
+
+	register   chan registration
+	unregister chan chan message
+	broadcast  chan message
+	done       chan struct{}
+	closeOnce  sync.Once
 
-	register   chan chan []byte
-	unregister chan chan []byte
-	broadcast  chan []byte
+	mu           sync.Mutex
+	clients      map[chan message]*client
+	online       map[int64]int // open streams per user ID
+	lastID       uint64
+	recent       []message // ring of the last ReplayBufferSize events, oldest first
+	dropped      uint64    // events not delivered because a client's buffer was full
+	disconnected uint64    // streams closed by the Disconnect policy
+}
 
-	mu      sync.Mutex
-	clients map[chan []byte]struct{}
+type Stats struct {
+	Clients         int    `json:"clients"`
+	Dropped         uint64 `json:"dropped"`
+	Disconnected    uint64 `json:"disconnected"`
+	BroadcastQueued int    `json:"broadcast_queued"`
+	BroadcastBuffer int    `json:"broadcast_buffer"`
+	ClientBuffer    int    `json:"client_buffer"`
+	Policy          Policy `json:"policy"`
 }
 
-func NewHub(logger *log.Logger) *Hub {
+// NewHub creates a hub. Connections send a keep-alive comment every
+// opts.KeepAlive so idle proxies don't drop the stream.
+func NewHub(logger *slog.Logger, opts Options) *Hub {
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = DefaultKeepAlive
+	}
+	if opts.BroadcastBuffer <= 0 {
+		opts.BroadcastBuffer = DefaultBroadcastBuffer
+	}
+	if opts.ClientBuffer <= 0 {
+		opts.ClientBuffer = DefaultClientBuffer
+	}
+	if opts.Policy == "" {
+		opts.Policy = DropNewest
+	}
+	if opts.DisconnectAfter <= 0 {
+		opts.DisconnectAfter = DefaultDisconnectAfter
+	}
 	return &Hub{
 		logger:     logger,
-		register:   make(chan chan []byte),
-		unregister: make(chan chan []byte),
-		broadcast:  make(chan []byte, 100),
-		clients:    make(map[chan []byte]struct{}),
+		opts:       opts,
+		register:   make(chan registration),
+		unregister: make(chan chan message),
+		broadcast:  make(chan message, opts.BroadcastBuffer),
+		done:       make(chan struct{}),
+		clients:    make(map[chan message]*client),
+		online:     make(map[int64]int),
 	}
 }
 
+// Run delivers broadcasts to clients until Close is called.
 func (h *Hub) Run() {
 	for {
 		select {
-		case ch := <-h.register:
+		case <-h.done:
+			h.mu.Lock()
+			for ch := range h.clients {
+				h.remove(ch)
+			}
+			h.mu.Unlock()
+			return
+		case reg := <-h.register:
 			h.mu.Lock()
-			h.clients[ch] = struct{}{}
+			h.clients[reg.ch] = &client{user: reg.user}
+			if reg.user.ID != 0 {
+				h.online[reg.user.ID]++
+			}
 			h.mu.Unlock()
 		case ch := <-h.unregister:
 			h.mu.Lock()
 			if _, ok := h.clients[ch]; ok {
-				delete(h.clients, ch)
-				close(ch)
+				h.remove(ch)
 			}
 			h.mu.Unlock()
 		case msg := <-h.broadcast:
 			h.mu.Lock()
-			for ch := range h.clients {
-				select {
-				case ch <- msg:
-				default:
+			h.lastID++
+			msg.id = h.lastID
+			if !msg.meta.Ephemeral {
+				if len(h.recent) == ReplayBufferSize {
+					copy(h.recent, h.recent[1:])
+					h.recent = h.recent[:len(h.recent)-1]
 				}
+				h.recent = append(h.recent, msg)
+			}
+			for ch, c := range h.clients {
+				h.deliver(ch, c, msg)
 			}
 			h.mu.Unlock()
 		}
 	}
 }
 
-func (h *Hub) Broadcast(b []byte) {
+// remove drops a registered client, closing its channel and its share of
+// the user's presence. The caller holds h.mu.
+func (h *Hub) remove(ch chan message) {
+	if id := h.clients[ch].user.ID; id != 0 {
+		if h.online[id]--; h.online[id] <= 0 {
+			delete(h.online, id)
+		}
+	}
+	delete(h.clients, ch)
+	close(ch)
+}
+
+// deliver queues msg for one client, applying the slow-client policy when
+// its buffer is full. A client that lost events is sent a notice with the
+// count as soon as it has room again. The caller holds h.mu.
+func (h *Hub) deliver(ch chan message, c *client, msg message) {
+	if c.missed > 0 {
+		notice := message{notice: true, data: []byte(fmt.Sprintf(`{"event":"events_dropped","count":%d}`, c.missed))}
+		select {
+		case ch <- notice:
+			c.missed = 0
+		default:
+		}
+	}
+	select {
+	case ch <- msg:
+		c.full = 0
+		return
+	default:
+	}
+
+	c.full++
+	switch h.opts.Policy {
+	case DropOldest:
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- msg:
+		default:
+		}
+	case Disconnect:
+		if c.full >= h.opts.DisconnectAfter {
+			h.remove(ch)
+			h.disconnected++
+			h.logger.Warn("sse client disconnected: buffer full", "times", c.full)
+		}
+	}
+	// Either way one event is lost: the new one, or the oldest queued.
+	h.dropped++
+	c.missed++
+}
+
+// Close stops Run and ends every open stream so the HTTP server can shut
+// down without waiting for clients to disconnect. Later broadcasts are
+// dropped and new streams return immediately.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+func (h *Hub) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Stats{
+		Clients:         len(h.clients),
+		Dropped:         h.dropped,
+		Disconnected:    h.disconnected,
+		BroadcastQueued: len(h.broadcast),
+		BroadcastBuffer: h.opts.BroadcastBuffer,
+		ClientBuffer:    h.opts.ClientBuffer,
+		Policy:          h.opts.Policy,
+	}
+}
+
+func (h *Hub) Broadcast(b []byte, meta Meta) {
 	if !json.Valid(b) {
 		b, _ = json.Marshal(map[string]any{
 			"event":   "raw",
 			"payload": string(b),
 		})
 	}
-	h.broadcast <- append([]byte(nil), b...)
+	select {
+	case h.broadcast <- message{data: append([]byte(nil), b...), meta: meta}:
+	case <-h.done:
+	}
+}
+
+// Online lists the users with an open stream, by user ID, with how many
+// streams each has open; a user counts once per browser tab.
+func (h *Hub) Online() []Presence {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := make(map[int64]bool, len(h.online))
+	out := make([]Presence, 0, len(h.online))
+	for _, c := range h.clients {
+		u := c.user
+		if u.ID == 0 || seen[u.ID] {
+			continue
+		}
+		seen[u.ID] = true
+		out = append(out, Presence{UserID: u.ID, Username: u.Username, Role: u.Role, Connections: h.online[u.ID]})
+	}
+	slices.SortFunc(out, func(a, b Presence) int { return cmp.Compare(a.UserID, b.UserID) })
+	return out
 }
 
+// SSEHandler streams every event unfiltered. Its streams are anonymous and
+// do not count towards Online.
 func (h *Hub) SSEHandler() http.HandlerFunc {
+	return h.handler(authclient.User{}, func(Meta) bool { return true })
+}
+
+// SSEHandlerFor streams only the events u may see: admins get everything,
+// guests their own room's tickets and those they raised, staff the tickets assigned to them and
+// the unclaimed ones routed to their department (ignoring case, as the
+// ticket views do). Nobody gets back events they sent themselves.
+func (h *Hub) SSEHandlerFor(u authclient.User) http.HandlerFunc {
+	return h.handler(u, func(m Meta) bool {
+		if m.SenderUserID != 0 && m.SenderUserID == u.ID {
+			return false
+		}
+		switch u.Role {
+		case authclient.RoleAdmin:
+			return true
+		case authclient.RoleGuest:
+			return (u.Room != "" && m.Room == u.Room) || (m.CreatedByUserID != 0 && m.CreatedByUserID == u.ID)
+		case authclient.RoleStaff:
+			if m.AssignedToUserID != 0 {
+				return m.AssignedToUserID == u.ID
+			}
+			return u.Department != "" && strings.EqualFold(m.Department, u.Department)
+		default:
+			return false
+		}
+	})
+}
+
+func (h *Hub) handler(u authclient.User, allow func(Meta) bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		flusher, ok := w.(http.Flusher)
 		if !ok {
@@ -81,14 +356,43 @@ func (h *Hub) SSEHandler() http.HandlerFunc {
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 
-		client := make(chan []byte, 25)
-		h.register <- client
-		defer func() { h.unregister <- client }()
+		events := make(chan message, h.opts.ClientBuffer)
+		select {
+		case h.register <- registration{ch: events, user: u}:
+		case <-h.done:
+			return
+		}
+		defer func() {
+			select {
+			case h.unregister <- events:
+			case <-h.done:
+			}
+		}()
 
-		writeSSE(w, []byte(`{"event":"connected"}`))
+		// A keep-alive straight away lets proxies see traffic before the
+		// first interval has passed.
+		_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+		writeSSE(w, 0, []byte(`{"event":"connected"}`))
+
+		// Replay what the client missed since Last-Event-ID. Registration
+		// happened first, so anything newer than the replay arrives on client
+		// too; sent tracks the high-water mark to skip those duplicates.
+		var sent uint64
+		if lastID, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+			missed, ok := h.since(lastID)
+			if ok {
+				sent = lastID
+			}
+			for _, msg := range missed {
+				if allow(msg.meta) {
+					writeSSE(w, msg.id, msg.data)
+				}
+				sent = msg.id
+			}
+		}
 		flusher.Flush()
 
-		keepAlive := time.NewTicker(15 * time.Second)
+		keepAlive := time.NewTicker(h.opts.KeepAlive)
 		defer keepAlive.Stop()
 
 		notify := r.Context().Done()
@@ -98,15 +402,40 @@ func (h *Hub) SSEHandler() http.HandlerFunc {
 			select {
 			case <-notify:
 				return
+			case <-h.done:
+				return
 			case <-keepAlive.C:
 				_, _ = bw.WriteString(": keep-alive\n\n")
 				_ = bw.Flush()
 				flusher.Flush()
-			case msg, ok := <-client:
+			case msg, ok := <-events:
 				if !ok {
+					// Closed by the hub: on shutdown, or because this
+					// connection kept falling behind. Say so in the latter
+					// case so the client resyncs when it reconnects.
+					select {
+					case <-h.done:
+					default:
+						writeSSE(bw, 0, []byte(`{"event":"disconnected","reason":"slow_client"}`))
+						_ = bw.Flush()
+						flusher.Flush()
+					}
 					return
 				}
-				writeSSEBuffered(bw, msg)
+				if msg.notice {
+					writeSSE(bw, 0, msg.data)
+					_ = bw.Flush()
+					flusher.Flush()
+					continue
+				}
+				if msg.id <= sent {
+					continue
+				}
+				sent = msg.id
+				if !allow(msg.meta) {
+					continue
+				}
+				writeSSE(bw, msg.id, msg.data)
 				_ = bw.Flush()
 				flusher.Flush()
 			}
@@ -114,10 +443,48 @@ func (h *Hub) SSEHandler() http.HandlerFunc {
 	}
 }
 
-func writeSSE(w http.ResponseWriter, data []byte) {
-	_, _ = fmt.Fprintf(w, "data: %s\n\n", bytes.ReplaceAll(data, []byte("\n"), []byte("")))
+// since returns the buffered events with an ID greater than id. ok is false
+// when id is ahead of the hub (the gateway restarted and IDs began again).
+func (h *Hub) since(id uint64) (out []message, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id > h.lastID {
+		return nil, false
+	}
+	for _, msg := range h.recent {
+		if msg.id > id {
+			out = append(out, msg)
+		}
+	}
+	return out, true
 }
 
-func writeSSEBuffered(w *bufio.Writer, data []byte) {
-	_, _ = fmt.Fprintf(w, "data: %s\n\n", bytes.ReplaceAll(data, []byte("\n"), []byte("")))
+// writeSSE writes one event; id 0 omits the id: field.
+func writeSSE(w io.Writer, id uint64, data []byte) {
+	if id > 0 {
+		_, _ = fmt.Fprintf(w, "id: %d\n", id)
+	}
+	// Each line of data gets its own data: field; the client joins them back
+	// with \n. The spec treats \r\n, \r and \n all as line ends.
+	for _, line := range splitLines(data) {
+		_, _ = w.Write([]byte("data: "))
+		_, _ = w.Write(line)
+		_, _ = w.Write([]byte("\n"))
+	}
+	_, _ = w.Write([]byte("\n"))
+}
+
+func splitLines(data []byte) [][]byte {
+	var lines [][]byte
+	for {
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			return append(lines, data)
+		}
+		lines = append(lines, data[:i])
+		if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			i++
+		}
+		data = data[i+1:]
+	}
 }
@@ -0,0 +1,228 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"src/internal/authclient"
+)
+
+// event is one dispatched server-sent event.
+type event struct {
+	ID   string
+	Data string
+}
+
+// parseSSE decodes a stream the way an EventSource does: any of \r\n, \r
+// and \n ends a line, data: lines are joined with \n, and a blank line
+// dispatches the event. Comments and events without data are skipped.
+func parseSSE(stream string) []event {
+	var out []event
+	var cur event
+	var data []string
+	stream = strings.ReplaceAll(stream, "\r\n", "\n")
+	stream = strings.ReplaceAll(stream, "\r", "\n")
+	for _, line := range strings.Split(stream, "\n") {
+		switch {
+		case line == "":
+			if data != nil {
+				cur.Data = strings.Join(data, "\n")
+				out = append(out, cur)
+			}
+			cur, data = event{}, nil
+		case strings.HasPrefix(line, ":"):
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "data":
+				data = append(data, value)
+			case "id":
+				cur.ID = value
+			}
+		}
+	}
+	return out
+}
+
+func TestWriteSSEFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string // data the client sees
+	}{
+		{"single line", `{"a":1}`, `{"a":1}`},
+		{"LF", "{\n\"a\": 1\n}", "{\n\"a\": 1\n}"},
+		{"CRLF", "{\r\n\"a\": 1\r\n}", "{\n\"a\": 1\n}"},
+		{"bare CR", "{\r\"a\": 1\r}", "{\n\"a\": 1\n}"},
+		{"mixed", "a\rb\r\nc\nd", "a\nb\nc\nd"},
+		{"blank line inside", "a\r\n\r\nb", "a\n\nb"},
+		{"trailing break", "a\r\n", "a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeSSE(&buf, 7, []byte(tt.payload))
+			got := parseSSE(buf.String())
+			if len(got) != 1 {
+				t.Fatalf("parsed %d events from %q, want 1", len(got), buf.String())
+			}
+			if got[0].ID != "7" || got[0].Data != tt.want {
+				t.Errorf("event = %+v, want id 7 and data %q", got[0], tt.want)
+			}
+		})
+	}
+}
+
+// eventReader returns a function that reads the stream's next event. It
+// reads whole events, up to their blank line, so the parser never sees half
+// of one.
+func eventReader(t *testing.T, r io.Reader) func() event {
+	br := bufio.NewReader(r)
+	return func() event {
+		t.Helper()
+		var raw strings.Builder
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			raw.WriteString(line)
+			if line == "\n" {
+				if evs := parseSSE(raw.String()); len(evs) > 0 {
+					if len(evs) != 1 {
+						t.Fatalf("one frame parsed as %d events: %q", len(evs), raw.String())
+					}
+					return evs[0]
+				}
+				raw.Reset()
+			}
+		}
+	}
+}
+
+// newTestHub starts a hub that runs until the test ends.
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), Options{})
+	go h.Run()
+	t.Cleanup(h.Close)
+	return h
+}
+
+// openStream connects to handler and returns a reader of its events, past
+// the initial "connected" one.
+func openStream(t *testing.T, handler http.HandlerFunc) func() event {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	next := eventReader(t, resp.Body)
+	if ev := next(); ev.Data != `{"event":"connected"}` {
+		t.Fatalf("first event = %+v", ev)
+	}
+	return next
+}
+
+func TestBroadcastCRLFIsOneEvent(t *testing.T) {
+	h := newTestHub(t)
+	next := openStream(t, h.SSEHandler())
+
+	// Valid, pretty-printed JSON, so Broadcast passes it through as is.
+	h.Broadcast([]byte("{\r\n  \"event\": \"created\",\r\n  \"ticket_id\": 1\r\n}"), Meta{})
+	ev := next()
+	if want := "{\n  \"event\": \"created\",\n  \"ticket_id\": 1\n}"; ev.Data != want {
+		t.Errorf("data = %q, want %q", ev.Data, want)
+	}
+	if ev.ID != "1" {
+		t.Errorf("id = %q, want 1", ev.ID)
+	}
+}
+
+// A chat message's line breaks are escaped by JSON, so a run of chat events
+// with multi-line messages stays one event each and decodes intact.
+func TestChatMessageNewlinesStayInOneEvent(t *testing.T) {
+	messages := []string{
+		"first line\nsecond line",
+		"ends with a break\n",
+		"\n\nblank lines\n\n",
+		"data: not a field\n\nid: 99",
+	}
+	var buf bytes.Buffer
+	for i, m := range messages {
+		b, err := json.Marshal(map[string]any{"event": "chat_message", "ticket_id": 1, "message": m})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeSSE(&buf, uint64(i+1), b)
+	}
+
+	got := parseSSE(buf.String())
+	if len(got) != len(messages) {
+		t.Fatalf("parsed %d events, want %d: %q", len(got), len(messages), buf.String())
+	}
+	for i, ev := range got {
+		var payload struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal([]byte(ev.Data), &payload); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if payload.Message != messages[i] {
+			t.Errorf("event %d message = %q, want %q", i, payload.Message, messages[i])
+		}
+	}
+}
+
+// Staff see events of unclaimed tickets routed to their department, matched
+// ignoring case, but not once someone else has claimed the ticket.
+func TestStaffSeeTheirDepartmentsEvents(t *testing.T) {
+	h := newTestHub(t)
+	next := openStream(t, h.SSEHandlerFor(authclient.User{ID: 7, Role: authclient.RoleStaff, Department: "Plumbing"}))
+
+	h.Broadcast([]byte(`{"n":1}`), Meta{Department: "Housekeeping"})
+	h.Broadcast([]byte(`{"n":2}`), Meta{Department: "Plumbing", AssignedToUserID: 8})
+	h.Broadcast([]byte(`{"n":3}`), Meta{Department: "plumbing"})
+	h.Broadcast([]byte(`{"n":4}`), Meta{})
+	h.Broadcast([]byte(`{"n":5}`), Meta{AssignedToUserID: 7})
+	for _, want := range []string{`{"n":3}`, `{"n":5}`} {
+		if ev := next(); ev.Data != want {
+			t.Fatalf("got %q, want %q", ev.Data, want)
+		}
+	}
+}
+
+// Guests see their room's events and those of tickets they raised, which is
+// how chat events, sent without a room, reach them.
+func TestGuestsSeeTheirRoomAndOwnTickets(t *testing.T) {
+	h := newTestHub(t)
+	next := openStream(t, h.SSEHandlerFor(authclient.User{ID: 50, Role: authclient.RoleGuest, Room: "101"}))
+
+	h.Broadcast([]byte(`{"n":1}`), Meta{Room: "102", CreatedByUserID: 51})
+	h.Broadcast([]byte(`{"n":2}`), Meta{Room: "101", CreatedByUserID: 51})
+	h.Broadcast([]byte(`{"n":3}`), Meta{AssignedToUserID: 7})
+	h.Broadcast([]byte(`{"n":4}`), Meta{AssignedToUserID: 7, CreatedByUserID: 50})
+	for _, want := range []string{`{"n":2}`, `{"n":4}`} {
+		if ev := next(); ev.Data != want {
+			t.Fatalf("got %q, want %q", ev.Data, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package tickets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"src/internal/authclient"
+)
+
+func TestListMyActivityPagesOnlyCallersEvents(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+	ctx := context.Background()
+	tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: 1})
+
+	// Every event shares one timestamp, so paging has to fall back on IDs
+	// at each page boundary.
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var want []int64
+	for i := 0; i < 7; i++ {
+		actor := int64(1)
+		if i%3 == 0 {
+			actor = 2
+		}
+		e, err := a.repo.InsertEvent(ctx, TicketEvent{TicketID: tk.ID, ActorUserID: actor, ActorRole: authclient.RoleStaff, Action: ActionChatSent, CreatedAt: at})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actor == 1 {
+			want = append([]int64{e.ID}, want...)
+		}
+	}
+
+	u := authclient.User{ID: 1, Role: authclient.RoleStaff}
+	var got []int64
+	q := url.Values{"limit": {"2"}}
+	for page := 0; page < 10; page++ {
+		w := httptest.NewRecorder()
+		a.ListMyActivity(w, request(http.MethodGet, "/api/me/activity?"+q.Encode(), ""), u)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var out struct {
+			Events       []TicketEvent `json:"events"`
+			NextBefore   *time.Time    `json:"next_before"`
+			NextBeforeID int64         `json:"next_before_id"`
+		}
+		decodeBody(t, w, &out)
+		for _, e := range out.Events {
+			if e.ActorUserID != u.ID {
+				t.Fatalf("event %d belongs to user %d", e.ID, e.ActorUserID)
+			}
+			got = append(got, e.ID)
+		}
+		if out.NextBefore == nil {
+			break
+		}
+		q.Set("before", out.NextBefore.Format(time.RFC3339Nano))
+		q.Set("before_id", strconv.FormatInt(out.NextBeforeID, 10))
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+	}
+}
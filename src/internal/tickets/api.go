@@ -1,39 +1,118 @@
 package tickets
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 
 	"src/internal/authclient"
+	"src/internal/httpjson"
+	"src/internal/logging"
 	"src/internal/mq"
 )
 
 type API struct {
-	logger *log.Logger
+	logger *slog.Logger
 	repo   *Repository
-	mqtt   mqtt.Client
+	pub    *mq.Publisher
+	auth   *authclient.Client
+	opts   Options
 }
 
-func NewAPI(logger *log.Logger, repo *Repository, mqttClient mqtt.Client) *API {
-	return &API{logger: logger, repo: repo, mqtt: mqttClient}
+// Options holds the tunables of the ticket API.
+type Options struct {
+	Upload UploadConfig
+
+	// Authors may edit a chat message for this long after sending it.
+	ChatEditWindow time.Duration
+
+	// ChatMaxLen caps a chat message in characters; 0 means DefaultChatMaxLen.
+	ChatMaxLen int
+
+	// ChatCap limits the messages stored per ticket; the zero value means
+	// no limit.
+	ChatCap ChatCap
+
+	// GuestTypes restricts the ticket types guests may choose; empty means
+	// all of Types.
+	GuestTypes []string
+
+	// RoomRegistry rejects tickets for rooms missing from the rooms table.
+	RoomRegistry bool
+
+	// DuplicatePolicy decides what happens when a guest reports a problem
+	// that already has an open ticket; "" behaves like DuplicateAllow.
+	DuplicatePolicy string
+
+	// GuestCanReopen lets the guest who created a resolved ticket reopen it.
+	GuestCanReopen bool
+
+	// TypeChangeRouting is RoutingOff, RoutingSuggest or RoutingAuto; ""
+	// behaves like RoutingOff.
+	TypeChangeRouting string
+
+	// Departments are the names tickets may be routed to, matched ignoring
+	// case; empty accepts any name.
+	Departments []string
+}
+
+// Duplicate policies for guest-created tickets of a type that is already
+// OPEN or IN_PROGRESS in the same room.
+const (
+	DuplicateAllow = "allow" // create it anyway
+	DuplicateWarn  = "warn"  // create it, pointing at the existing ticket via X-Duplicate-Of
+	DuplicateBlock = "block" // refuse with 409 and the existing ticket's ID
+)
+
+// errDuplicateTicket aborts a guest create under DuplicateBlock.
+var errDuplicateTicket = errors.New("tickets: duplicate open ticket")
+
+// NewAPI wires the ticket handlers; events go out through pub. auth may be
+// nil, in which case responses carry user IDs without usernames.
+func NewAPI(logger *slog.Logger, repo *Repository, pub *mq.Publisher, auth *authclient.Client, opts Options) *API {
+	return &API{logger: logger, repo: repo, pub: pub, auth: auth, opts: opts}
+}
+
+// log returns the API logger tagged with the request's ID.
+func (a *API) log(r *http.Request) *slog.Logger {
+	return logging.ForRequest(a.logger, r)
 }
 
 type CreateTicketReq struct {
 	Type        string `json:"type"`
 	Description string `json:"description"`
-	// Room is NOT allowed from guest; admin could use a separate endpoint if needed.
+	// Room is NOT allowed from guest; admins use CreateTicketAsAdmin instead.
+	// It is accepted (and ignored) so strict decoding doesn't reject older clients.
+	Room string `json:"room,omitempty"`
+}
+
+type AdminCreateTicketReq struct {
+	Room        string `json:"room"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// UpdateTicketReq edits a guest's own OPEN ticket; omitted fields are kept.
+type UpdateTicketReq struct {
+	Type        *string `json:"type,omitempty"`
+	Description *string `json:"description,omitempty"`
 }
 
+// UpdateStatusReq changes a ticket's status. Version, like an If-Match
+// header, makes the update conditional on the ticket being unchanged.
 type UpdateStatusReq struct {
-	Status string `json:"status"`
+	Status  string `json:"status"`
+	Version int64  `json:"version,omitempty"`
 }
 
 type AssignReq struct {
@@ -44,8 +123,17 @@ type EventPayload struct {
 	Event      string           `json:"event"`
 	Ticket     Ticket           `json:"ticket"`
 	AssignedTo *authclient.User `json:"assigned_to,omitempty"`
+	// SuggestedTo is the specialist a reassign_suggested event proposes.
+	SuggestedTo *authclient.User `json:"suggested_to,omitempty"`
+
+	// Set by publish.
+	EventID   string    `json:"event_id"`
+	EmittedAt time.Time `json:"emitted_at"`
 }
 
+func (p EventPayload) EventType() string    { return p.Event }
+func (p EventPayload) EventTime() time.Time { return p.EmittedAt }
+
 // --------------------
 // Chat request
 // --------------------
@@ -54,29 +142,174 @@ type SendChatReq struct {
 }
 
 func (a *API) ListTicketsForUser(w http.ResponseWriter, r *http.Request, u authclient.User) {
-	var items []Ticket
-	var err error
+	f, ok := a.listFilter(w, r)
+	if !ok {
+		return
+	}
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
+
+	if f.IncludeDeleted && u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "include_deleted is admin only")
+		return
+	}
 
 	switch u.Role {
 	case authclient.RoleAdmin:
-		items, err = a.repo.ListAll(r.Context())
 	case authclient.RoleGuest:
-		items, err = a.repo.ListByRoom(r.Context(), u.Room)
+		f.Room = u.Room
 	case authclient.RoleStaff:
-		items, err = a.repo.ListAssignedTo(r.Context(), u.ID)
+		f.AssignedToUserID = u.ID
 	default:
 		writeErr(w, http.StatusForbidden, "unknown role")
 		return
 	}
 
+	items, err := a.repo.ListFiltered(r.Context(), f)
+	if err != nil {
+		a.log(r).Error("list tickets", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	a.enrich(r.Context(), items)
+	a.fillUnread(r, u, items)
+	localizeTickets(items, loc)
+	writeJSON(w, http.StatusOK, items)
+}
+
+// ListAllTickets is the admin listing behind /api/admin/tickets; it takes
+// the same filters as ListTicketsForUser. Admin only.
+func (a *API) ListAllTickets(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	a.ListTicketsForUser(w, r, u)
+}
+
+// ListUnassignedTickets is the admin queue behind
+// /api/admin/tickets/unassigned: open and in-progress tickets nobody is
+// assigned to, oldest first.
+func (a *API) ListUnassignedTickets(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
+	items, err := a.repo.ListUnassigned(r.Context())
+	if err != nil {
+		a.log(r).Error("list unassigned", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	a.enrich(r.Context(), items)
+	a.fillUnread(r, u, items)
+	localizeTickets(items, loc)
+	writeJSON(w, http.StatusOK, items)
+}
+
+// listFilter parses the list query filters, writing a 400 itself when they
+// are invalid. A floor filter needs at least one room with a floor in the
+// registry; without one it would silently match nothing.
+func (a *API) listFilter(w http.ResponseWriter, r *http.Request) (ListFilter, bool) {
+	f, msg := parseListFilter(r)
+	if msg != "" {
+		writeErr(w, http.StatusBadRequest, msg)
+		return f, false
+	}
+	if f.Floor != nil {
+		mapped, err := a.repo.HasFloorMapping(r.Context())
+		if err != nil {
+			a.log(r).Error("check floor mapping", "err", err)
+			writeErr(w, http.StatusInternalServerError, "db error")
+			return f, false
+		}
+		if !mapped {
+			writeErr(w, http.StatusBadRequest, "floor filter unavailable: no room has a floor configured")
+			return f, false
+		}
+	}
+	return f, true
+}
+
+// ListMine returns the tickets the user created. Unlike the room-scoped
+// list it keeps showing a guest's tickets after they move rooms.
+func (a *API) ListMine(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
+	items, err := a.repo.ListCreatedBy(r.Context(), u.ID)
 	if err != nil {
-		a.logger.Printf("list tickets: %v", err)
+		a.log(r).Error("list my tickets", "err", err)
 		writeErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
+	a.enrich(r.Context(), items)
+	a.fillUnread(r, u, items)
+	localizeTickets(items, loc)
+	if items == nil {
+		items = []Ticket{}
+	}
 	writeJSON(w, http.StatusOK, items)
 }
 
+// parseListFilter reads the optional ?status=, ?type= and RFC3339 ?from=/?to=
+// query filters. It returns a client-facing message when one is invalid.
+func parseListFilter(r *http.Request) (ListFilter, string) {
+	var f ListFilter
+	q := r.URL.Query()
+	if s := q.Get("status"); s != "" {
+		if !IsValidStatus(s) {
+			return f, "invalid status (OPEN/IN_PROGRESS/RESOLVED/CANCELLED)"
+		}
+		f.Status = s
+	}
+	if t := q.Get("type"); t != "" {
+		if !IsValidType(t) {
+			return f, "invalid type (plumbing/ac/noise/cleaning/wifi/other)"
+		}
+		f.Type = t
+	}
+	if s := q.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return f, "invalid from (RFC3339)"
+		}
+		f.From = t
+	}
+	if s := q.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return f, "invalid to (RFC3339)"
+		}
+		f.To = t
+	}
+	if s := q.Get("floor"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return f, "invalid floor"
+		}
+		f.Floor = &n
+	}
+	if s := q.Get("include_deleted"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return f, "invalid include_deleted (true/false)"
+		}
+		f.IncludeDeleted = b
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.From.After(f.To) {
+		return f, "from must not be after to"
+	}
+	return f, ""
+}
+
 func (a *API) CreateTicketAsGuest(w http.ResponseWriter, r *http.Request, u authclient.User) {
 	if u.Role != authclient.RoleGuest {
 		writeErr(w, http.StatusForbidden, "only guests can create tickets here")
@@ -88,12 +321,12 @@ func (a *API) CreateTicketAsGuest(w http.ResponseWriter, r *http.Request, u auth
 	}
 
 	var req CreateTicketReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json")
+	if err := httpjson.Decode(r, &req, true); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
 		return
 	}
-	if !IsValidType(req.Type) {
-		writeErr(w, http.StatusBadRequest, "invalid type (plumbing/ac/noise/cleaning/wifi/other)")
+	if msg := a.checkType(u.Role, req.Type); msg != "" {
+		writeErr(w, http.StatusBadRequest, msg)
 		return
 	}
 	if req.Description == "" {
@@ -101,20 +334,96 @@ func (a *API) CreateTicketAsGuest(w http.ResponseWriter, r *http.Request, u auth
 		return
 	}
 
-	t, err := a.repo.Create(r.Context(), Ticket{
-		Type:            req.Type,
-		Room:            u.Room, // enforced from session
-		Description:     req.Description,
-		Status:          StatusOpen,
-		CreatedByUserID: u.ID,
+	a.createTicket(w, r, u, u.Room, req.Type, req.Description) // room enforced from session
+}
+
+// CreateTicketAsAdmin logs a ticket for any room on behalf of a guest, e.g.
+// a problem reported at the front desk. Admin only.
+func (a *API) CreateTicketAsAdmin(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+
+	var req AdminCreateTicketReq
+	if err := httpjson.Decode(r, &req, true); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+		return
+	}
+	room := strings.TrimSpace(req.Room)
+	if room == "" {
+		writeErr(w, http.StatusBadRequest, "room is required")
+		return
+	}
+	if msg := a.checkType(u.Role, req.Type); msg != "" {
+		writeErr(w, http.StatusBadRequest, msg)
+		return
+	}
+	if req.Description == "" {
+		writeErr(w, http.StatusBadRequest, "description is required")
+		return
+	}
+
+	a.createTicket(w, r, u, room, req.Type, req.Description)
+}
+
+// createTicket stores a new OPEN ticket created by u, applying the
+// duplicate policy, and writes the response.
+func (a *API) createTicket(w http.ResponseWriter, r *http.Request, u authclient.User, room, typ, description string) {
+	known, err := a.KnownRoom(r.Context(), room)
+	if err != nil {
+		a.log(r).Error("check room", "room", room, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if !known {
+		writeErr(w, http.StatusBadRequest, "unknown room")
+		return
+	}
+
+	// The lookup runs in the create transaction so two quick reports can't
+	// both miss each other.
+	var t, dup Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		if policy := a.opts.DuplicatePolicy; policy == DuplicateWarn || policy == DuplicateBlock {
+			dup, err = tx.FindOpenDuplicate(r.Context(), room, typ)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if err == nil && policy == DuplicateBlock {
+				return errDuplicateTicket
+			}
+		}
+		t, err = tx.Create(r.Context(), Ticket{
+			Type:            typ,
+			Room:            room,
+			Description:     description,
+			Status:          StatusOpen,
+			CreatedByUserID: u.ID,
+		})
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, t.ID, u, ActionCreated, t.Type)
 	})
+	if errors.Is(err, errDuplicateTicket) {
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":              "an open ticket already exists for this problem",
+			"existing_ticket_id": dup.ID,
+		})
+		return
+	}
 	if err != nil {
-		a.logger.Printf("create ticket: %v", err)
+		a.log(r).Error("create ticket", "err", err)
 		writeErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
 
 	a.publish(mq.TopicTicketCreated, EventPayload{Event: "created", Ticket: t})
+	if dup.ID != 0 {
+		w.Header().Set("X-Duplicate-Of", strconv.FormatInt(dup.ID, 10))
+	}
 	writeJSON(w, http.StatusCreated, t)
 }
 
@@ -124,14 +433,22 @@ func (a *API) GetTicket(w http.ResponseWriter, r *http.Request, u authclient.Use
 		writeErr(w, http.StatusBadRequest, "invalid id")
 		return
 	}
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
 
-	t, err := a.repo.Get(r.Context(), id)
+	get := a.repo.Get
+	if inc, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted")); inc && u.Role == authclient.RoleAdmin {
+		get = a.repo.GetWithDeleted
+	}
+	t, err := get(r.Context(), id)
 	if errors.Is(err, sql.ErrNoRows) {
 		writeErr(w, http.StatusNotFound, "not found")
 		return
 	}
 	if err != nil {
-		a.logger.Printf("get ticket: %v", err)
+		a.log(r).Error("get ticket", "ticket_id", id, "err", err)
 		writeErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
@@ -141,7 +458,12 @@ func (a *API) GetTicket(w http.ResponseWriter, r *http.Request, u authclient.Use
 		writeErr(w, http.StatusForbidden, "not allowed")
 		return
 	}
-	writeJSON(w, http.StatusOK, t)
+	one := []Ticket{t}
+	a.enrich(r.Context(), one)
+	a.fillUnread(r, u, one)
+	localizeTickets(one, loc)
+	w.Header().Set("ETag", etag(t))
+	writeJSON(w, http.StatusOK, one[0])
 }
 
 func (a *API) UpdateStatus(w http.ResponseWriter, r *http.Request, u authclient.User) {
@@ -152,14 +474,23 @@ func (a *API) UpdateStatus(w http.ResponseWriter, r *http.Request, u authclient.
 	}
 
 	var req UpdateStatusReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json")
+	if err := httpjson.Decode(r, &req, true); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
 		return
 	}
-	if !IsValidStatus(req.Status) {
+	if !IsValidStatus(req.Status) || req.Status == StatusCancelled {
 		writeErr(w, http.StatusBadRequest, "invalid status (OPEN/IN_PROGRESS/RESOLVED)")
 		return
 	}
+	expected := req.Version
+	if h := r.Header.Get("If-Match"); h != "" {
+		v, ok := parseETag(h)
+		if !ok {
+			writeErr(w, http.StatusBadRequest, "invalid If-Match")
+			return
+		}
+		expected = v
+	}
 
 	current, err := a.repo.Get(r.Context(), id)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -171,6 +502,15 @@ func (a *API) UpdateStatus(w http.ResponseWriter, r *http.Request, u authclient.
 		return
 	}
 
+	if current.Status == StatusCancelled {
+		writeErr(w, http.StatusConflict, "ticket is cancelled")
+		return
+	}
+	if current.Status == StatusResolved && req.Status != StatusResolved {
+		writeErr(w, http.StatusConflict, "ticket is resolved; reopen it with a reason")
+		return
+	}
+
 	// Admin can update any; Staff only assigned; Guest cannot update
 	if u.Role == authclient.RoleGuest {
 		writeErr(w, http.StatusForbidden, "guests cannot update status")
@@ -183,22 +523,292 @@ func (a *API) UpdateStatus(w http.ResponseWriter, r *http.Request, u authclient.
 		}
 	}
 
-	updated, err := a.repo.UpdateStatus(r.Context(), id, req.Status)
+	// Setting the status it already has changes nothing: no new version,
+	// audit entry or event, so a repeated "resolve" can't move the
+	// recorded resolution time.
+	if req.Status == current.Status {
+		if expected > 0 && expected != current.Version {
+			writeErr(w, http.StatusConflict, "ticket was modified; reload and retry")
+			return
+		}
+		w.Header().Set("ETag", etag(current))
+		writeJSON(w, http.StatusOK, current)
+		return
+	}
+
+	var updated Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		updated, err = tx.UpdateStatus(r.Context(), id, req.Status, expected)
+		if err != nil {
+			return err
+		}
+		return recordStatus(r.Context(), tx, id, u, ActionStatusUpdated, updated.Status, current.Status+" -> "+updated.Status)
+	})
 	if errors.Is(err, sql.ErrNoRows) {
 		writeErr(w, http.StatusNotFound, "not found")
 		return
 	}
+	if errors.Is(err, ErrVersionConflict) {
+		writeErr(w, http.StatusConflict, "ticket was modified; reload and retry")
+		return
+	}
 	if err != nil {
-		a.logger.Printf("update status: %v", err)
+		a.log(r).Error("update status", "ticket_id", id, "err", err)
 		writeErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
 
 	a.publish(mq.TopicTicketStatusUpdated, EventPayload{Event: "status_updated", Ticket: updated})
+	a.publish(mq.TicketStateTopic(updated.ID), EventPayload{Event: "status_updated", Ticket: updated})
+	w.Header().Set("ETag", etag(updated))
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// etag is a ticket's version as an HTTP entity tag, for use in If-Match.
+func etag(t Ticket) string {
+	return `"` + strconv.FormatInt(t.Version, 10) + `"`
+}
+
+// parseETag reads a version from an If-Match value as produced by etag. A
+// weak prefix is tolerated; "*" and lists are not supported.
+func parseETag(s string) (int64, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "W/")
+	s = strings.Trim(s, `"`)
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return v, true
+}
+
+// allowedTypes returns the ticket types role may create.
+func (a *API) allowedTypes(role string) []string {
+	if role == authclient.RoleGuest && len(a.opts.GuestTypes) > 0 {
+		return a.opts.GuestTypes
+	}
+	return Types
+}
+
+// checkType returns a client-facing message naming the types role may use
+// when t is not one of them.
+func (a *API) checkType(role, t string) string {
+	allowed := a.allowedTypes(role)
+	if slices.Contains(allowed, t) {
+		return ""
+	}
+	return "invalid type (" + strings.Join(allowed, "/") + ")"
+}
+
+// UpdateTicket lets the guest who created a ticket correct its type or
+// description while it is still OPEN.
+func (a *API) UpdateTicket(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if u.Role != authclient.RoleGuest {
+		writeErr(w, http.StatusForbidden, "only the creating guest can edit")
+		return
+	}
+
+	var req UpdateTicketReq
+	if err := httpjson.Decode(r, &req, true); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+		return
+	}
+	if req.Type == nil && req.Description == nil {
+		writeErr(w, http.StatusBadRequest, "nothing to update")
+		return
+	}
+	if req.Type != nil {
+		if msg := a.checkType(u.Role, *req.Type); msg != "" {
+			writeErr(w, http.StatusBadRequest, msg)
+			return
+		}
+	}
+	if req.Description != nil && *req.Description == "" {
+		writeErr(w, http.StatusBadRequest, "description is required")
+		return
+	}
+
+	current, err := a.repo.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if current.CreatedByUserID != u.ID {
+		writeErr(w, http.StatusForbidden, "only the creating guest can edit")
+		return
+	}
+	if current.Status != StatusOpen {
+		writeErr(w, http.StatusConflict, "only OPEN tickets can be edited")
+		return
+	}
+
+	typ, desc := current.Type, current.Description
+	if req.Type != nil {
+		typ = *req.Type
+	}
+	if req.Description != nil {
+		desc = *req.Description
+	}
+
+	var changed []string
+	var specialist *authclient.User
+	if typ != current.Type {
+		changed = append(changed, "type: "+current.Type+" -> "+typ)
+		specialist = a.specialistFor(r, current, typ)
+	}
+	reassign := specialist != nil && a.opts.TypeChangeRouting == RoutingAuto
+	suggest := specialist != nil && !reassign
+	if desc != current.Description {
+		changed = append(changed, "description")
+	}
+
+	var updated Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		updated, err = tx.UpdateDescription(r.Context(), id, u.ID, typ, desc)
+		if err != nil || len(changed) == 0 {
+			return err
+		}
+		if err := record(r.Context(), tx, id, u, ActionEdited, strings.Join(changed, ", ")); err != nil {
+			return err
+		}
+		if suggest {
+			return record(r.Context(), tx, id, u, ActionSuggested, "staff_user_id="+strconv.FormatInt(specialist.ID, 10)+" (type changed to "+typ+")")
+		}
+		if !reassign {
+			return nil
+		}
+		updated, err = tx.Assign(r.Context(), id, specialist.ID)
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, id, u, ActionAssigned, "staff_user_id="+strconv.FormatInt(specialist.ID, 10)+" (type changed to "+typ+")")
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusConflict, "only OPEN tickets can be edited")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("update ticket", "ticket_id", id, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	switch {
+	case reassign:
+		a.publish(mq.TopicTicketAssigned, EventPayload{Event: "assigned", Ticket: updated, AssignedTo: specialist})
+	case suggest:
+		// Admins see it on their event stream; the ticket is unchanged.
+		a.publish(mq.TopicTicketReassignSuggested, EventPayload{Event: ActionSuggested, Ticket: updated, SuggestedTo: specialist})
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// Delete soft-deletes a ticket (spam, test data) so it drops out of every
+// view while its row and history stay for audit. Admin only.
+func (a *API) Delete(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var deleted Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		deleted, err = tx.SoftDelete(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, id, u, ActionDeleted, "")
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("delete ticket", "ticket_id", id, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	a.publish(mq.TopicTicketDeleted, EventPayload{Event: "deleted", Ticket: deleted})
+	a.publish(mq.TicketStateTopic(deleted.ID), EventPayload{Event: "deleted", Ticket: deleted})
+	writeJSON(w, http.StatusOK, deleted)
+}
+
+// Cancel lets the guest who created a ticket withdraw it while it is still
+// OPEN. Tickets already being worked can't be cancelled.
+func (a *API) Cancel(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if u.Role != authclient.RoleGuest {
+		writeErr(w, http.StatusForbidden, "only the creating guest can cancel")
+		return
+	}
+
+	current, err := a.repo.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if current.CreatedByUserID != u.ID {
+		writeErr(w, http.StatusForbidden, "only the creating guest can cancel")
+		return
+	}
+	if current.Status != StatusOpen {
+		writeErr(w, http.StatusConflict, "only OPEN tickets can be cancelled")
+		return
+	}
+
+	// Conditional update: someone may have started work since the read.
+	var updated Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		updated, err = tx.CancelIfOpen(r.Context(), id, u.ID)
+		if err != nil {
+			return err
+		}
+		return recordStatus(r.Context(), tx, id, u, ActionCancelled, updated.Status, "")
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusConflict, "only OPEN tickets can be cancelled")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("cancel", "ticket_id", id, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	a.publish(mq.TopicTicketCancelled, EventPayload{Event: "cancelled", Ticket: updated})
+	a.publish(mq.TicketStateTopic(updated.ID), EventPayload{Event: "cancelled", Ticket: updated})
 	writeJSON(w, http.StatusOK, updated)
 }
 
-func (a *API) Assign(w http.ResponseWriter, r *http.Request, u authclient.User, assignedTo authclient.User) {
+// Assign sets a ticket's assignee after checking the target is an existing
+// staff user.
+func (a *API) Assign(w http.ResponseWriter, r *http.Request, u authclient.User) {
 	if u.Role != authclient.RoleAdmin {
 		writeErr(w, http.StatusForbidden, "admin only")
 		return
@@ -211,8 +821,8 @@ func (a *API) Assign(w http.ResponseWriter, r *http.Request, u authclient.User,
 	}
 
 	var req AssignReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json")
+	if err := httpjson.Decode(r, &req, false); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
 		return
 	}
 	if req.StaffUserID <= 0 {
@@ -220,14 +830,36 @@ func (a *API) Assign(w http.ResponseWriter, r *http.Request, u authclient.User,
 		return
 	}
 
-	// You already fetched/validated assignedTo in gateway before calling
-	t, err := a.repo.Assign(r.Context(), id, req.StaffUserID)
+	if a.auth == nil {
+		writeErr(w, http.StatusBadGateway, "auth service unavailable")
+		return
+	}
+	assignedTo, ok, err := a.lookupStaff(r.Context(), req.StaffUserID)
+	if err != nil {
+		a.log(r).Error("assign lookup", "ticket_id", id, "staff_user_id", req.StaffUserID, "err", err)
+		writeErr(w, http.StatusBadGateway, "auth service unavailable")
+		return
+	}
+	if !ok {
+		writeErr(w, http.StatusBadRequest, "staff user not found")
+		return
+	}
+
+	var t Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		t, err = tx.Assign(r.Context(), id, req.StaffUserID)
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, id, u, ActionAssigned, "staff_user_id="+strconv.FormatInt(req.StaffUserID, 10))
+	})
 	if errors.Is(err, sql.ErrNoRows) {
 		writeErr(w, http.StatusNotFound, "not found")
 		return
 	}
 	if err != nil {
-		a.logger.Printf("assign: %v", err)
+		a.log(r).Error("assign", "ticket_id", id, "err", err)
 		writeErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
@@ -244,10 +876,11 @@ func (a *API) Assign(w http.ResponseWriter, r *http.Request, u authclient.User,
 // Chat endpoints
 // --------------------
 
-// ListChat: ADMIN can view any; STAFF only assigned; GUEST forbidden.
+// ListChat: ADMIN can view any; STAFF only assigned; GUEST only tickets they
+// created, read-only.
 func (a *API) ListChat(w http.ResponseWriter, r *http.Request, u authclient.User) {
 	ticketID, err := parseID(chi.URLParam(r, "id"))
-	if err != nil || ticketID <= 0 {
+	if err != nil {
 		writeErr(w, http.StatusBadRequest, "invalid id")
 		return
 	}
@@ -262,7 +895,7 @@ func (a *API) ListChat(w http.ResponseWriter, r *http.Request, u authclient.User
 		return
 	}
 
-	// Only admin or assigned staff can view chat
+	// Admin, assigned staff, or the guest who raised the ticket can view chat
 	if u.Role == authclient.RoleAdmin {
 		// ok
 	} else if u.Role == authclient.RoleStaff {
@@ -270,23 +903,124 @@ func (a *API) ListChat(w http.ResponseWriter, r *http.Request, u authclient.User
 			writeErr(w, http.StatusForbidden, "staff can view chat only for assigned tickets")
 			return
 		}
+	} else if u.Role == authclient.RoleGuest {
+		if t.CreatedByUserID != u.ID {
+			writeErr(w, http.StatusForbidden, "guests can view chat only for their own tickets")
+			return
+		}
 	} else {
 		writeErr(w, http.StatusForbidden, "chat is for admin/staff only")
 		return
 	}
 
-	msgs, err := a.repo.ListChatMessages(r.Context(), ticketID, 200)
+	var beforeID int64
+	if s := r.URL.Query().Get("before_id"); s != "" {
+		n, err := parseID(s)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid before_id")
+			return
+		}
+		beforeID = n
+	}
+	limit := 200
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > 500 {
+			writeErr(w, http.StatusBadRequest, "invalid limit (1-500)")
+			return
+		}
+		limit = n
+	}
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
+
+	msgs, hasMore, err := a.repo.ListChatMessages(r.Context(), ticketID, beforeID, limit)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"messages": msgs})
+	out := map[string]any{"messages": msgs, "has_more": hasMore}
+	if hasMore {
+		out["next_before_id"] = msgs[0].ID
+	}
+	localizeChat(msgs, loc)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// ListChatInbox merges the chats of the caller's own tickets into one list,
+// newest first: for a guest the tickets they created, for a staffer those
+// assigned to them. Admins own no tickets and read every chat through
+// ListAllChats instead. Takes ?before_id=, ?limit= (1-500, default 100) and
+// ?tz=.
+func (a *API) ListChatInbox(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	var f ChatInboxFilter
+	switch u.Role {
+	case authclient.RoleGuest:
+		f.CreatedBy = u.ID
+	case authclient.RoleStaff:
+		f.AssignedTo = u.ID
+	default:
+		writeErr(w, http.StatusForbidden, "admins read all chats at /api/admin/chat")
+		return
+	}
+	a.chatInbox(w, r, f)
+}
+
+// ListAllChats is ListChatInbox over every ticket, for admins.
+func (a *API) ListAllChats(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	a.chatInbox(w, r, ChatInboxFilter{All: true})
+}
+
+// chatInbox serves the chat messages of the tickets f selects, paged by
+// ?before_id= and ?limit=.
+func (a *API) chatInbox(w http.ResponseWriter, r *http.Request, f ChatInboxFilter) {
+	var beforeID int64
+	if s := r.URL.Query().Get("before_id"); s != "" {
+		n, err := parseID(s)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid before_id")
+			return
+		}
+		beforeID = n
+	}
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > 500 {
+			writeErr(w, http.StatusBadRequest, "invalid limit (1-500)")
+			return
+		}
+		limit = n
+	}
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
+
+	msgs, hasMore, err := a.repo.ListChatInbox(r.Context(), f, beforeID, limit)
+	if err != nil {
+		a.log(r).Error("chat inbox", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	out := map[string]any{"messages": msgs, "has_more": hasMore}
+	if hasMore {
+		out["next_before_id"] = msgs[len(msgs)-1].ID
+	}
+	localizeChat(msgs, loc)
+	writeJSON(w, http.StatusOK, out)
 }
 
 // SendChat: ADMIN can chat any; STAFF only assigned; GUEST forbidden.
 func (a *API) SendChat(w http.ResponseWriter, r *http.Request, u authclient.User) {
 	ticketID, err := parseID(chi.URLParam(r, "id"))
-	if err != nil || ticketID <= 0 {
+	if err != nil {
 		writeErr(w, http.StatusBadRequest, "invalid id")
 		return
 	}
@@ -315,30 +1049,42 @@ func (a *API) SendChat(w http.ResponseWriter, r *http.Request, u authclient.User
 	}
 
 	var req SendChatReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json")
+	if err := httpjson.Decode(r, &req, false); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
 		return
 	}
-	if req.Message == "" {
-		writeErr(w, http.StatusBadRequest, "message is required")
-		return
-	}
-	if len(req.Message) > 500 {
-		writeErr(w, http.StatusBadRequest, "message too long (max 500)")
+	text, msg := a.cleanChatMessage(req.Message)
+	if msg != "" {
+		writeErr(w, http.StatusBadRequest, msg)
 		return
 	}
 
 	now := time.Now().UTC()
 
 	// Store message
-	_, err = a.repo.InsertChatMessage(r.Context(), ChatMessage{
-		TicketID:     ticketID,
-		FromUserID:   u.ID,
-		FromUsername: u.Username,
-		FromRole:     u.Role,
-		Message:      req.Message,
-		SentAt:       now,
+	var stored ChatMessage
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		stored, err = tx.InsertChatMessage(r.Context(), ChatMessage{
+			TicketID:     ticketID,
+			FromUserID:   u.ID,
+			FromUsername: u.Username,
+			FromRole:     u.Role,
+			Message:      text,
+			SentAt:       now,
+		}, a.opts.ChatCap)
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, ticketID, u, ActionChatSent, "")
 	})
+	if errors.Is(err, ErrChatFull) {
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error": "this ticket's chat has reached its message limit",
+			"limit": a.opts.ChatCap.Max,
+		})
+		return
+	}
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "db error")
 		return
@@ -348,10 +1094,11 @@ func (a *API) SendChat(w http.ResponseWriter, r *http.Request, u authclient.User
 	chatEvt := ChatEventPayload{
 		Event:        "chat_message",
 		TicketID:     ticketID,
+		MessageID:    stored.ID,
 		FromUserID:   u.ID,
 		FromUsername: u.Username,
 		FromRole:     u.Role,
-		Message:      req.Message,
+		Message:      text,
 		SentAt:       now,
 	}
 	a.publishChat(mq.ChatTopic(ticketID), chatEvt)
@@ -359,55 +1106,269 @@ func (a *API) SendChat(w http.ResponseWriter, r *http.Request, u authclient.User
 	writeJSON(w, http.StatusCreated, map[string]any{"ok": true})
 }
 
-func canView(u authclient.User, t Ticket) bool {
+// ChatEventTyping is the Event of the typing indicators ChatTyping publishes.
+const ChatEventTyping = "chat_typing"
+
+// ChatTyping tells the other chat participants that u is typing. The event
+// goes out on the ticket's chat topic but is never stored, and is dropped
+// rather than queued while the broker is down. The same users as SendChat
+// may call it; the gateway rate-limits it per user.
+func (a *API) ChatTyping(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	ticketID, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	t, err := a.repo.Get(r.Context(), ticketID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
 	switch u.Role {
 	case authclient.RoleAdmin:
-		return true
-	case authclient.RoleGuest:
-		return u.Room != "" && t.Room == u.Room
 	case authclient.RoleStaff:
-		return t.AssignedToUserID != nil && *t.AssignedToUserID == u.ID
+		if t.AssignedToUserID == nil || *t.AssignedToUserID != u.ID {
+			writeErr(w, http.StatusForbidden, "staff can chat only for assigned tickets")
+			return
+		}
 	default:
-		return false
+		writeErr(w, http.StatusForbidden, "chat is for admin/staff only")
+		return
 	}
+
+	now := time.Now().UTC()
+	_ = a.pub.PublishEphemeral(mq.ChatTopic(ticketID), ChatEventPayload{
+		Event:        ChatEventTyping,
+		TicketID:     ticketID,
+		FromUserID:   u.ID,
+		FromUsername: u.Username,
+		FromRole:     u.Role,
+		SentAt:       now,
+		EventID:      newEventID(),
+		EmittedAt:    now,
+	})
+
+	writeJSON(w, http.StatusAccepted, map[string]any{"ok": true})
 }
 
-func (a *API) publish(topic string, payload EventPayload) {
-	if a.mqtt == nil || !a.mqtt.IsConnected() {
-		a.logger.Printf("mqtt not connected; skipping publish topic=%s", topic)
-		return
+// --------------------
+// Activity
+// --------------------
+
+// ListMyActivity returns the caller's own recorded actions, newest first.
+// Paging: pass the previous response's next_before and next_before_id as
+// ?before= and ?before_id=.
+func (a *API) ListMyActivity(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	var before time.Time
+	if s := r.URL.Query().Get("before"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid before (RFC3339)")
+			return
+		}
+		before = t
 	}
-	b, err := json.Marshal(payload)
+	var beforeID int64
+	if s := r.URL.Query().Get("before_id"); s != "" {
+		n, err := parseID(s)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid before_id")
+			return
+		}
+		beforeID = n
+	}
+	limit := 50
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > 200 {
+			writeErr(w, http.StatusBadRequest, "invalid limit (1-200)")
+			return
+		}
+		limit = n
+	}
+
+	items, err := a.repo.ListEventsByActor(r.Context(), u.ID, before, beforeID, limit)
 	if err != nil {
-		a.logger.Printf("marshal event: %v", err)
+		a.log(r).Error("list activity", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
-	tok := a.mqtt.Publish(topic, 1, false, b)
-	tok.WaitTimeout(3 * time.Second)
-	if err := tok.Error(); err != nil {
-		a.logger.Printf("publish error topic=%s: %v", topic, err)
+
+	out := map[string]any{"events": items}
+	if len(items) == limit {
+		last := items[len(items)-1]
+		out["next_before"] = last.CreatedAt
+		out["next_before_id"] = last.ID
 	}
+	writeJSON(w, http.StatusOK, out)
 }
 
-func (a *API) publishChat(topic string, payload ChatEventPayload) {
-	if a.mqtt == nil || !a.mqtt.IsConnected() {
-		a.logger.Printf("mqtt not connected; skipping publish topic=%s", topic)
+// lookupStaff finds a staff user by ID, first in the client's cached staff
+// listing and then, for someone added since, with a direct lookup.
+func (a *API) lookupStaff(ctx context.Context, id int64) (authclient.User, bool, error) {
+	if staff, err := a.auth.ListUsersByRole(ctx, authclient.RoleStaff); err == nil {
+		for _, s := range staff {
+			if s.ID == id {
+				return s, true, nil
+			}
+		}
+	}
+	found, err := a.auth.GetUsersByIDs(ctx, []int64{id})
+	if err != nil {
+		return authclient.User{}, false, err
+	}
+	if len(found) != 1 || found[0].Role != authclient.RoleStaff {
+		return authclient.User{}, false, nil
+	}
+	return found[0], true, nil
+}
+
+// record appends an entry to the audit log through tx, the transaction that
+// makes the change, so the change and its entry commit or fail together.
+func record(ctx context.Context, tx *Repository, ticketID int64, u authclient.User, action, detail string) error {
+	_, err := tx.InsertEvent(ctx, TicketEvent{
+		TicketID:    ticketID,
+		ActorUserID: u.ID,
+		ActorRole:   u.Role,
+		Action:      action,
+		Detail:      detail,
+	})
+	return err
+}
+
+// recordStatus is record for a change that moved the ticket to status,
+// which is stored alongside detail for reports to match on.
+func recordStatus(ctx context.Context, tx *Repository, ticketID int64, u authclient.User, action, status, detail string) error {
+	_, err := tx.InsertEvent(ctx, TicketEvent{
+		TicketID:    ticketID,
+		ActorUserID: u.ID,
+		ActorRole:   u.Role,
+		Action:      action,
+		Detail:      detail,
+		ToStatus:    status,
+	})
+	return err
+}
+
+// enrich fills in creator and assignee usernames with a single batch lookup.
+// If the auth service fails the tickets keep their IDs without names rather
+// than failing the request.
+func (a *API) enrich(ctx context.Context, items []Ticket) {
+	if a.auth == nil || len(items) == 0 {
 		return
 	}
-	b, err := json.Marshal(payload)
+	seen := map[int64]bool{}
+	var ids []int64
+	add := func(id int64) {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, t := range items {
+		add(t.CreatedByUserID)
+		if t.AssignedToUserID != nil {
+			add(*t.AssignedToUserID)
+		}
+	}
+
+	users, err := a.auth.GetUsersByIDs(ctx, ids)
 	if err != nil {
-		a.logger.Printf("marshal chat: %v", err)
+		a.logger.Warn("enrich tickets", "err", err)
 		return
 	}
-	tok := a.mqtt.Publish(topic, 1, false, b)
-	tok.WaitTimeout(3 * time.Second)
-	if err := tok.Error(); err != nil {
-		a.logger.Printf("publish chat error topic=%s: %v", topic, err)
+	names := make(map[int64]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Username
+	}
+	for i := range items {
+		items[i].CreatedByUsername = names[items[i].CreatedByUserID]
+		if items[i].AssignedToUserID != nil {
+			items[i].AssignedToUsername = names[*items[i].AssignedToUserID]
+		}
+	}
+}
+
+// canChat reports whether u may read and write t's chat: admins on any
+// ticket, staff on tickets assigned to them. Guests never write; see
+// canReadChat.
+func canChat(u authclient.User, t Ticket) bool {
+	switch u.Role {
+	case authclient.RoleAdmin:
+		return true
+	case authclient.RoleStaff:
+		return t.AssignedToUserID != nil && *t.AssignedToUserID == u.ID
+	default:
+		return false
+	}
+}
+
+// canReadChat reports whether u may read t's chat: anyone who can write it,
+// and the guest who created the ticket.
+func canReadChat(u authclient.User, t Ticket) bool {
+	if u.Role == authclient.RoleGuest {
+		return t.CreatedByUserID == u.ID
 	}
+	return canChat(u, t)
 }
 
+func canView(u authclient.User, t Ticket) bool {
+	switch u.Role {
+	case authclient.RoleAdmin:
+		return true
+	case authclient.RoleGuest:
+		// Their current room's tickets, plus any they raised elsewhere.
+		return (u.Room != "" && t.Room == u.Room) || t.CreatedByUserID == u.ID
+	case authclient.RoleStaff:
+		if t.AssignedToUserID != nil {
+			return *t.AssignedToUserID == u.ID
+		}
+		// Unclaimed tickets of their department, so they can decide to claim.
+		return sameDepartment(t.AssignedDepartment, u.Department)
+	default:
+		return false
+	}
+}
+
+// Events announce committed changes only. Handlers call publish or
+// publishChat as the last step before writing the response, after the
+// repository call (and its transaction) has succeeded, with nothing that
+// can fail in between. Publishing itself is fire-and-forget: a failure is
+// logged or the event is queued, but the request still succeeds because the
+// change is already stored.
+
+func (a *API) publish(topic string, payload EventPayload) {
+	payload.EventID, payload.EmittedAt = newEventID(), time.Now().UTC()
+	_ = a.pub.Publish(topic, payload)
+}
+
+func (a *API) publishChat(topic string, payload ChatEventPayload) {
+	payload.EventID, payload.EmittedAt = newEventID(), time.Now().UTC()
+	_ = a.pub.Publish(topic, payload)
+}
+
+// newEventID returns a random UUID identifying one published event, so
+// consumers can drop MQTT redeliveries.
+func newEventID() string {
+	return uuid.NewString()
+}
+
+// parseID parses a positive ticket/message ID from a path segment.
 func parseID(s string) (int64, error) {
-	return strconv.ParseInt(s, 10, 64)
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if id <= 0 {
+		return 0, errors.New("id must be positive")
+	}
+	return id, nil
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -0,0 +1,299 @@
+package tickets
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"src/internal/authclient"
+	"src/internal/httpjson"
+)
+
+// maxAttachmentURLLen keeps a pasted URL from bloating the table.
+const maxAttachmentURLLen = 2048
+
+// UploadConfig says where uploaded photos are stored and how big they may be.
+type UploadConfig struct {
+	Dir      string
+	MaxBytes int64
+}
+
+// Accepted upload types and the extension they are stored with.
+var uploadTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Stored names are random hex plus an extension; anything else in the
+// download path is rejected before touching the filesystem.
+var storedNameRe = regexp.MustCompile(`^[0-9a-f]{32}\.(jpg|png)$`)
+
+type AddAttachmentReq struct {
+	URL string `json:"url"`
+}
+
+// ListAttachments returns the attachments of a ticket the user can view.
+func (a *API) ListAttachments(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	t, ok := a.viewableTicket(w, r, u)
+	if !ok {
+		return
+	}
+	items, err := a.repo.ListAttachments(r.Context(), t.ID)
+	if err != nil {
+		a.log(r).Error("list attachments", "ticket_id", t.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"attachments": items})
+}
+
+// AddAttachment attaches to a ticket the user can view either an external
+// http(s) URL (JSON body) or an uploaded JPEG/PNG photo (multipart form,
+// field "file").
+func (a *API) AddAttachment(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	t, ok := a.viewableTicket(w, r, u)
+	if !ok {
+		return
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		a.uploadAttachment(w, r, u, t)
+		return
+	}
+
+	var req AddAttachmentReq
+	if err := httpjson.Decode(r, &req, true); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+		return
+	}
+	raw := strings.TrimSpace(req.URL)
+	if raw == "" {
+		writeErr(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	if len(raw) > maxAttachmentURLLen {
+		writeErr(w, http.StatusBadRequest, "url too long")
+		return
+	}
+	if !isHTTPURL(raw) {
+		writeErr(w, http.StatusBadRequest, "url must be an absolute http(s) URL")
+		return
+	}
+
+	var at Attachment
+	err := a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		at, err = tx.InsertAttachment(r.Context(), Attachment{
+			TicketID:         t.ID,
+			URL:              raw,
+			UploadedByUserID: u.ID,
+		})
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, t.ID, u, ActionAttached, at.URL)
+	})
+	if err != nil {
+		a.log(r).Error("add attachment", "ticket_id", t.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, at)
+}
+
+// viewableTicket loads the {id} ticket and checks canView, writing the error
+// response itself when it returns false.
+func (a *API) viewableTicket(w http.ResponseWriter, r *http.Request, u authclient.User) (Ticket, bool) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return Ticket{}, false
+	}
+	t, err := a.repo.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return Ticket{}, false
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return Ticket{}, false
+	}
+	if !canView(u, t) {
+		writeErr(w, http.StatusForbidden, "not allowed")
+		return Ticket{}, false
+	}
+	return t, true
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (a *API) uploadAttachment(w http.ResponseWriter, r *http.Request, u authclient.User, t Ticket) {
+	if a.opts.Upload.Dir == "" {
+		writeErr(w, http.StatusNotImplemented, "uploads are disabled")
+		return
+	}
+	tooLarge := fmt.Sprintf("file too large (max %d bytes)", a.opts.Upload.MaxBytes)
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			writeErr(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		writeErr(w, http.StatusBadRequest, "invalid multipart form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	f, hdr, err := r.FormFile("file")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer f.Close()
+	if a.opts.Upload.MaxBytes > 0 && hdr.Size > a.opts.Upload.MaxBytes {
+		writeErr(w, http.StatusRequestEntityTooLarge, tooLarge)
+		return
+	}
+
+	// Trust the bytes, not the client's Content-Type header.
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		writeErr(w, http.StatusBadRequest, "empty file")
+		return
+	}
+	ctype := http.DetectContentType(head[:n])
+	ext, ok := uploadTypes[ctype]
+	if !ok {
+		writeErr(w, http.StatusUnsupportedMediaType, "only JPEG and PNG images are allowed")
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		writeErr(w, http.StatusInternalServerError, "upload error")
+		return
+	}
+
+	name, err := randomName(ext)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "upload error")
+		return
+	}
+	dir := filepath.Join(a.opts.Upload.Dir, strconv.FormatInt(t.ID, 10))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		a.log(r).Error("upload mkdir", "ticket_id", t.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "upload error")
+		return
+	}
+	path := filepath.Join(dir, name)
+	size, err := writeFile(path, f)
+	if err != nil {
+		a.log(r).Error("upload write", "ticket_id", t.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "upload error")
+		return
+	}
+
+	var at Attachment
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		at, err = tx.InsertAttachment(r.Context(), Attachment{
+			TicketID:         t.ID,
+			URL:              fmt.Sprintf("/api/tickets/%d/attachments/%s", t.ID, name),
+			UploadedByUserID: u.ID,
+			FileName:         name,
+			ContentType:      ctype,
+			Size:             size,
+		})
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, t.ID, u, ActionAttached, name)
+	})
+	if err != nil {
+		_ = os.Remove(path)
+		a.log(r).Error("add attachment", "ticket_id", t.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, at)
+}
+
+// GetAttachmentFile serves an uploaded photo to users who can view the ticket.
+func (a *API) GetAttachmentFile(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	t, ok := a.viewableTicket(w, r, u)
+	if !ok {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if !storedNameRe.MatchString(name) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	at, err := a.repo.GetAttachmentFile(r.Context(), t.ID, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	f, err := os.Open(filepath.Join(a.opts.Upload.Dir, strconv.FormatInt(t.ID, 10), at.FileName))
+	if err != nil {
+		a.log(r).Error("open attachment", "ticket_id", t.ID, "name", name, "err", err)
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", at.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, at.FileName, at.CreatedAt, f)
+}
+
+func randomName(ext string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b) + ext, nil
+}
+
+// writeFile copies src to a new file at path, removing it again on failure.
+func writeFile(path string, src io.Reader) (int64, error) {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, src)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return 0, err
+	}
+	return n, nil
+}
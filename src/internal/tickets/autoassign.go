@@ -0,0 +1,84 @@
+package tickets
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"src/internal/authclient"
+	"src/internal/bulk"
+	"src/internal/mq"
+)
+
+// AutoAssignUnassigned hands every unassigned, unresolved ticket to the
+// least-loaded staffer (fewest unresolved tickets, lowest ID on ties),
+// oldest ticket first. Tickets assigned concurrently are reported as
+// skipped, so it is safe to re-run.
+func (a *API) AutoAssignUnassigned(w http.ResponseWriter, r *http.Request, u authclient.User, staff []authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	if len(staff) == 0 {
+		writeErr(w, http.StatusConflict, "no staff available")
+		return
+	}
+
+	pending, err := a.repo.ListUnassigned(r.Context())
+	if err != nil {
+		a.log(r).Error("auto-assign list", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	load, err := a.repo.CountOpenByStaff(r.Context())
+	if err != nil {
+		a.log(r).Error("auto-assign load", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	res := bulk.New()
+	for _, t := range pending {
+		pick := leastLoaded(staff, load)
+
+		var assigned Ticket
+		err := a.repo.WithTx(r.Context(), func(tx *Repository) error {
+			var err error
+			assigned, err = tx.AssignIfUnassigned(r.Context(), t.ID, pick.ID)
+			if err != nil {
+				return err
+			}
+			return record(r.Context(), tx, t.ID, u, ActionAssigned, "staff_user_id="+strconv.FormatInt(pick.ID, 10)+" (auto)")
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			res.Skip(bulk.Item{ID: t.ID, Error: "already assigned"})
+			continue
+		}
+		if err != nil {
+			a.log(r).Error("auto-assign", "ticket_id", t.ID, "err", err)
+			res.Fail(bulk.Item{ID: t.ID, Error: "db error"})
+			continue
+		}
+		load[pick.ID]++
+
+		a.publish(mq.TopicTicketAssigned, EventPayload{
+			Event:      "assigned",
+			Ticket:     assigned,
+			AssignedTo: &pick,
+		})
+		res.OK(t.ID)
+	}
+
+	writeJSON(w, res.Status(), res)
+}
+
+func leastLoaded(staff []authclient.User, load map[int64]int) authclient.User {
+	best := staff[0]
+	for _, s := range staff[1:] {
+		if load[s.ID] < load[best.ID] || (load[s.ID] == load[best.ID] && s.ID < best.ID) {
+			best = s
+		}
+	}
+	return best
+}
@@ -0,0 +1,78 @@
+package tickets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"src/internal/authclient"
+)
+
+func TestLeastLoaded(t *testing.T) {
+	staff := []authclient.User{{ID: 7}, {ID: 3}, {ID: 5}}
+	tests := []struct {
+		name string
+		load map[int64]int
+		want int64
+	}{
+		{"fewest open tickets wins", map[int64]int{7: 0, 3: 2, 5: 1}, 7},
+		{"tie goes to lowest ID", map[int64]int{7: 1, 3: 1, 5: 1}, 3},
+		{"tie among the least loaded only", map[int64]int{7: 0, 3: 4, 5: 0}, 5},
+		{"missing load counts as zero", map[int64]int{3: 1}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := leastLoaded(staff, tt.load); got.ID != tt.want {
+				t.Errorf("leastLoaded = %d, want %d", got.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoAssignSpreadsByWorkload(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+	busy := int64(1)
+	mustCreate(t, a.repo, Ticket{CreatedByUserID: 9, AssignedToUserID: &busy})
+	var pending []int64
+	for i := 0; i < 3; i++ {
+		pending = append(pending, mustCreate(t, a.repo, Ticket{CreatedByUserID: 9}).ID)
+	}
+
+	staff := []authclient.User{{ID: 1, Role: authclient.RoleStaff}, {ID: 2, Role: authclient.RoleStaff}}
+	admin := authclient.User{ID: 99, Role: authclient.RoleAdmin}
+	w := httptest.NewRecorder()
+	a.AutoAssignUnassigned(w, request(http.MethodPost, "/api/admin/tickets/auto-assign", ""), admin, staff)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	// Staff 1 starts with one ticket, so 2 gets the oldest; after that they
+	// tie and the lower ID wins, then 2 again.
+	want := map[int64]int64{pending[0]: 2, pending[1]: 1, pending[2]: 2}
+	for id, staffID := range want {
+		tk, err := a.repo.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tk.AssignedToUserID == nil || *tk.AssignedToUserID != staffID {
+			t.Errorf("ticket %d assigned to %v, want %d", id, tk.AssignedToUserID, staffID)
+		}
+	}
+}
+
+// A ticket someone else assigned between listing and assigning is what
+// AutoAssignUnassigned reports as skipped rather than failed.
+func TestAssignIfUnassignedLosesRace(t *testing.T) {
+	repo := newTestRepo(t)
+	tk := mustCreate(t, repo, Ticket{CreatedByUserID: 9})
+	if _, err := repo.Assign(context.Background(), tk.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err := repo.AssignIfUnassigned(context.Background(), tk.ID, 2)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,115 @@
+package tickets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"src/internal/authclient"
+)
+
+func TestSendChatCap(t *testing.T) {
+	const limit = 3
+	admin := authclient.User{ID: 1, Role: authclient.RoleAdmin, Username: "admin"}
+
+	tests := []struct {
+		name     string
+		policy   string
+		existing int
+		wantCode int
+		// wantKept lists the pre-existing messages, by index, left after the
+		// send; the new message is expected last when the send succeeds.
+		wantKept []int
+	}{
+		{"reject below the cap", ChatCapReject, limit - 1, http.StatusCreated, []int{0, 1}},
+		{"reject at the cap", ChatCapReject, limit, http.StatusConflict, []int{0, 1, 2}},
+		{"reject over the cap", ChatCapReject, limit + 1, http.StatusConflict, []int{0, 1, 2, 3}},
+		{"prune below the cap", ChatCapPrune, limit - 1, http.StatusCreated, []int{0, 1}},
+		{"prune at the cap", ChatCapPrune, limit, http.StatusCreated, []int{1, 2}},
+		{"prune over the cap", ChatCapPrune, limit + 1, http.StatusCreated, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAPI(t, Options{ChatCap: ChatCap{Max: limit, Policy: tt.policy}})
+			ctx := context.Background()
+			tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: 50})
+			// Seed past the cap directly, as if it had been lowered since.
+			for i := 0; i < tt.existing; i++ {
+				mustChat(t, a.repo, tk.ID, fmt.Sprintf("m%d", i))
+			}
+
+			id := strconv.FormatInt(tk.ID, 10)
+			w := httptest.NewRecorder()
+			a.SendChat(w, request(http.MethodPost, "/api/tickets/"+id+"/chat", `{"message":"new"}`, "id", id), admin)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if w.Code == http.StatusConflict {
+				var body struct {
+					Limit int `json:"limit"`
+				}
+				decodeBody(t, w, &body)
+				if body.Limit != limit {
+					t.Errorf("limit in body = %d, want %d", body.Limit, limit)
+				}
+			}
+
+			var want []string
+			for _, i := range tt.wantKept {
+				want = append(want, fmt.Sprintf("m%d", i))
+			}
+			if w.Code == http.StatusCreated {
+				want = append(want, "new")
+			}
+			msgs, _, err := a.repo.ListChatMessages(ctx, tk.ID, 0, 500)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range msgs {
+				got = append(got, m.Message)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("messages = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestChatCapIgnoresDeletedMessages(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	tk := mustCreate(t, repo, Ticket{CreatedByUserID: 50})
+	limit := ChatCap{Max: 2, Policy: ChatCapReject}
+
+	first := mustChat(t, repo, tk.ID, "a")
+	mustChat(t, repo, tk.ID, "b")
+	if _, err := repo.DeleteChatMessage(ctx, tk.ID, first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// One live message plus a tombstone leaves room for one more.
+	if _, err := repo.InsertChatMessage(ctx, ChatMessage{TicketID: tk.ID, FromUserID: 1, Message: "c", SentAt: time.Now()}, limit); err != nil {
+		t.Fatalf("insert with a deleted message at the cap: %v", err)
+	}
+	if _, err := repo.InsertChatMessage(ctx, ChatMessage{TicketID: tk.ID, FromUserID: 1, Message: "d", SentAt: time.Now()}, limit); err != ErrChatFull {
+		t.Fatalf("insert over the cap: err = %v, want ErrChatFull", err)
+	}
+}
+
+// mustChat stores an admin chat message without a cap.
+func mustChat(t *testing.T, repo *Repository, ticketID int64, text string) ChatMessage {
+	t.Helper()
+	m, err := repo.InsertChatMessage(context.Background(), ChatMessage{
+		TicketID: ticketID, FromUserID: 1, FromUsername: "admin", FromRole: authclient.RoleAdmin,
+		Message: text, SentAt: time.Now(),
+	}, ChatCap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
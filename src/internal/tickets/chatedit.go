@@ -0,0 +1,205 @@
+package tickets
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"src/internal/authclient"
+	"src/internal/httpjson"
+	"src/internal/mq"
+)
+
+type EditChatReq struct {
+	Message string `json:"message"`
+}
+
+// EditChat lets the author correct a chat message within the configured
+// edit window.
+func (a *API) EditChat(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	t, m, ok := a.chatMessage(w, r, u)
+	if !ok {
+		return
+	}
+	if m.FromUserID != u.ID {
+		writeErr(w, http.StatusForbidden, "only the author can edit a message")
+		return
+	}
+	if m.DeletedAt != nil {
+		writeErr(w, http.StatusConflict, "message is deleted")
+		return
+	}
+	if a.opts.ChatEditWindow > 0 && time.Since(m.SentAt) > a.opts.ChatEditWindow {
+		writeErr(w, http.StatusForbidden, "edit window has passed")
+		return
+	}
+
+	var req EditChatReq
+	if err := httpjson.Decode(r, &req, true); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+		return
+	}
+	text, msg := a.cleanChatMessage(req.Message)
+	if msg != "" {
+		writeErr(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	var updated ChatMessage
+	err := a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		updated, err = tx.EditChatMessage(r.Context(), t.ID, m.ID, text)
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, t.ID, u, ActionChatEdited, "message "+strconv.FormatInt(m.ID, 10))
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusConflict, "message is deleted")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("edit chat", "ticket_id", t.ID, "message_id", m.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	a.publishChat(mq.ChatTopic(t.ID), chatEvent("chat_updated", updated))
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DeleteChat removes a message's text, leaving a tombstone in the thread.
+// The author or an admin may delete.
+func (a *API) DeleteChat(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	t, m, ok := a.chatMessage(w, r, u)
+	if !ok {
+		return
+	}
+	if m.FromUserID != u.ID && u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "only the author or an admin can delete a message")
+		return
+	}
+
+	var deleted ChatMessage
+	err := a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		deleted, err = tx.DeleteChatMessage(r.Context(), t.ID, m.ID)
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, t.ID, u, ActionChatDeleted, "message "+strconv.FormatInt(m.ID, 10))
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("delete chat", "ticket_id", t.ID, "message_id", m.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	a.publishChat(mq.ChatTopic(t.ID), chatEvent("chat_deleted", deleted))
+	writeJSON(w, http.StatusOK, deleted)
+}
+
+// chatMessage loads the {id} ticket and its {msgId} message, checking the
+// user may take part in the ticket's chat. It writes the error response
+// itself when it returns false.
+func (a *API) chatMessage(w http.ResponseWriter, r *http.Request, u authclient.User) (Ticket, ChatMessage, bool) {
+	ticketID, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return Ticket{}, ChatMessage{}, false
+	}
+	msgID, err := parseID(chi.URLParam(r, "msgId"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid message id")
+		return Ticket{}, ChatMessage{}, false
+	}
+
+	t, err := a.repo.Get(r.Context(), ticketID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return Ticket{}, ChatMessage{}, false
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return Ticket{}, ChatMessage{}, false
+	}
+	switch u.Role {
+	case authclient.RoleAdmin:
+	case authclient.RoleStaff:
+		if t.AssignedToUserID == nil || *t.AssignedToUserID != u.ID {
+			writeErr(w, http.StatusForbidden, "staff can chat only for assigned tickets")
+			return Ticket{}, ChatMessage{}, false
+		}
+	default:
+		writeErr(w, http.StatusForbidden, "chat is for admin/staff only")
+		return Ticket{}, ChatMessage{}, false
+	}
+
+	m, err := a.repo.GetChatMessage(r.Context(), ticketID, msgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "message not found")
+		return Ticket{}, ChatMessage{}, false
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return Ticket{}, ChatMessage{}, false
+	}
+	return t, m, true
+}
+
+func chatEvent(event string, m ChatMessage) ChatEventPayload {
+	return ChatEventPayload{
+		Event:        event,
+		TicketID:     m.TicketID,
+		MessageID:    m.ID,
+		FromUserID:   m.FromUserID,
+		FromUsername: m.FromUsername,
+		FromRole:     m.FromRole,
+		Message:      m.Message,
+		SentAt:       m.SentAt,
+	}
+}
+
+// DefaultChatMaxLen is used when Options.ChatMaxLen is unset.
+const DefaultChatMaxLen = 2000
+
+// cleanChatMessage normalises line endings to \n and checks the text is
+// non-empty, within the length limit and free of other control characters,
+// which have no business in a chat line and confuse event-stream clients.
+// It returns a client-facing message when the text is rejected.
+func (a *API) cleanChatMessage(s string) (string, string) {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	if strings.TrimSpace(s) == "" {
+		return "", "message is required"
+	}
+	if !utf8.ValidString(s) {
+		return "", "message must be valid UTF-8"
+	}
+
+	max := a.opts.ChatMaxLen
+	if max <= 0 {
+		max = DefaultChatMaxLen
+	}
+	if utf8.RuneCountInString(s) > max {
+		return "", fmt.Sprintf("message too long (max %d)", max)
+	}
+	for _, c := range s {
+		if c != '\n' && c != '\t' && unicode.IsControl(c) {
+			return "", "message contains control characters"
+		}
+	}
+	return s, ""
+}
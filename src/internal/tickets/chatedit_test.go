@@ -0,0 +1,35 @@
+package tickets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanChatMessage(t *testing.T) {
+	a := &API{opts: Options{ChatMaxLen: 10}}
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{"plain", "hello", "hello", ""},
+		{"CRLF becomes LF", "a\r\nb", "a\nb", ""},
+		{"bare CR becomes LF", "a\rb\r", "a\nb\n", ""},
+		{"tab kept", "a\tb", "a\tb", ""},
+		{"blank", " \r\n\t", "", "message is required"},
+		{"control character", "a\x00b", "", "message contains control characters"},
+		{"escape sequence", "\x1b[2J", "", "message contains control characters"},
+		{"at the limit", strings.Repeat("é", 10), strings.Repeat("é", 10), ""},
+		{"over the limit", strings.Repeat("x", 11), "", "message too long (max 10)"},
+		{"invalid UTF-8", "a\xffb", "", "message must be valid UTF-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, msg := a.cleanChatMessage(tt.in)
+			if got != tt.want || msg != tt.wantErr {
+				t.Errorf("cleanChatMessage(%q) = %q, %q; want %q, %q", tt.in, got, msg, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package tickets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+
+	"src/internal/authclient"
+)
+
+func TestListChatInboxByRole(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+	admin := authclient.User{ID: 1, Role: authclient.RoleAdmin, Username: "admin"}
+	staff := authclient.User{ID: 7, Role: authclient.RoleStaff, Username: "sam"}
+	guest := authclient.User{ID: 50, Role: authclient.RoleGuest, Room: "101"}
+	other := authclient.User{ID: 51, Role: authclient.RoleGuest, Room: "102"}
+
+	// Guests create tickets; the admin created none of them.
+	mine := mustCreate(t, a.repo, Ticket{CreatedByUserID: guest.ID, AssignedToUserID: &staff.ID})
+	theirs := mustCreate(t, a.repo, Ticket{CreatedByUserID: other.ID, Room: "102"})
+	m1 := mustChat(t, a.repo, mine.ID, "one")
+	m2 := mustChat(t, a.repo, theirs.ID, "two")
+	m3 := mustChat(t, a.repo, mine.ID, "three")
+
+	type page struct {
+		code int
+		ids  []int64
+		next int64
+	}
+	call := func(h func(http.ResponseWriter, *http.Request, authclient.User), u authclient.User, query string) page {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h(w, request(http.MethodGet, "/api/chat"+query, ""), u)
+		if w.Code != http.StatusOK {
+			return page{code: w.Code}
+		}
+		var got struct {
+			Messages     []ChatMessage `json:"messages"`
+			NextBeforeID int64         `json:"next_before_id"`
+		}
+		decodeBody(t, w, &got)
+		p := page{code: w.Code, next: got.NextBeforeID}
+		for _, m := range got.Messages {
+			p.ids = append(p.ids, m.ID)
+		}
+		return p
+	}
+	same := func(got []int64, want ...int64) bool {
+		return slices.Equal(got, want)
+	}
+
+	// Guests and staff get the chat of their own tickets.
+	if p := call(a.ListChatInbox, guest, ""); !same(p.ids, m3.ID, m1.ID) {
+		t.Errorf("guest inbox = %+v, want [%d %d]", p, m3.ID, m1.ID)
+	}
+	if p := call(a.ListChatInbox, other, ""); !same(p.ids, m2.ID) {
+		t.Errorf("other guest inbox = %+v, want [%d]", p, m2.ID)
+	}
+	if p := call(a.ListChatInbox, staff, ""); !same(p.ids, m3.ID, m1.ID) {
+		t.Errorf("staff inbox = %+v, want [%d %d]", p, m3.ID, m1.ID)
+	}
+	if p := call(a.ListChatInbox, admin, ""); p.code != http.StatusForbidden {
+		t.Errorf("admin inbox: status %d, want 403", p.code)
+	}
+
+	// Admins page through every ticket's chat on their own route.
+	p := call(a.ListAllChats, admin, "?limit=2")
+	if !same(p.ids, m3.ID, m2.ID) || p.next != m2.ID {
+		t.Errorf("all chats first page = %+v, want [%d %d] next %d", p, m3.ID, m2.ID, m2.ID)
+	}
+	p = call(a.ListAllChats, admin, "?limit=2&before_id="+strconv.FormatInt(p.next, 10))
+	if !same(p.ids, m1.ID) || p.next != 0 {
+		t.Errorf("all chats second page = %+v, want [%d]", p, m1.ID)
+	}
+	for _, u := range []authclient.User{staff, guest} {
+		if p := call(a.ListAllChats, u, ""); p.code != http.StatusForbidden {
+			t.Errorf("all chats as %s: status %d, want 403", u.Role, p.code)
+		}
+	}
+}
+
+// Guests read, but can't write, the chat of tickets they created.
+func TestGuestChatIsReadOnly(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+	guest := authclient.User{ID: 50, Role: authclient.RoleGuest, Room: "101"}
+	neighbour := authclient.User{ID: 51, Role: authclient.RoleGuest, Room: "101"}
+	tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: guest.ID})
+	mustChat(t, a.repo, tk.ID, "we're on it")
+	id := strconv.FormatInt(tk.ID, 10)
+
+	list := func(u authclient.User) int {
+		w := httptest.NewRecorder()
+		a.ListChat(w, request(http.MethodGet, "/api/tickets/"+id+"/chat", "", "id", id), u)
+		return w.Code
+	}
+	if code := list(guest); code != http.StatusOK {
+		t.Errorf("creator reads chat: status %d, want 200", code)
+	}
+	// Same room, someone else's ticket.
+	if code := list(neighbour); code != http.StatusForbidden {
+		t.Errorf("other guest reads chat: status %d, want 403", code)
+	}
+
+	w := httptest.NewRecorder()
+	a.SendChat(w, request(http.MethodPost, "/api/tickets/"+id+"/chat", `{"message":"thanks"}`, "id", id), guest)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("creator sends chat: status %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package tickets
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"src/internal/authclient"
+	"src/internal/httpjson"
+)
+
+type MarkChatReadReq struct {
+	LastReadID int64 `json:"last_read_id"`
+}
+
+// MarkChatRead records how far the user has read a ticket's chat. The body
+// is optional; without last_read_id everything up to now is marked read.
+// Only users who may read the chat (see canReadChat) can mark it read.
+func (a *API) MarkChatRead(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	t, ok := a.viewableTicket(w, r, u)
+	if !ok {
+		return
+	}
+	if !canReadChat(u, t) {
+		writeErr(w, http.StatusForbidden, "not allowed to read this chat")
+		return
+	}
+
+	var req MarkChatReadReq
+	if err := httpjson.Decode(r, &req, true); err != nil && !errors.Is(err, io.EOF) {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+		return
+	}
+	if req.LastReadID < 0 {
+		writeErr(w, http.StatusBadRequest, "invalid last_read_id")
+		return
+	}
+
+	last, err := a.repo.MarkChatRead(r.Context(), t.ID, u.ID, req.LastReadID)
+	if err != nil {
+		a.log(r).Error("mark chat read", "ticket_id", t.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ticket_id": t.ID, "last_read_id": last})
+}
+
+// fillUnread sets UnreadCount on each ticket whose chat u can read: staff
+// replies on a guest's own tickets count, but a guest is never shown a badge
+// for another guest's ticket in their room. Like enrich it
+// is best effort: on error the counts stay unset.
+func (a *API) fillUnread(r *http.Request, u authclient.User, items []Ticket) {
+	var ids []int64
+	for _, t := range items {
+		if canReadChat(u, t) {
+			ids = append(ids, t.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	counts, err := a.repo.UnreadChatCounts(r.Context(), u.ID, ids)
+	if err != nil {
+		a.log(r).Warn("unread chat counts", "err", err)
+		return
+	}
+	for i := range items {
+		if canReadChat(u, items[i]) {
+			n := counts[items[i].ID]
+			items[i].UnreadCount = &n
+		}
+	}
+}
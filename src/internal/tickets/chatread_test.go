@@ -0,0 +1,80 @@
+package tickets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"src/internal/authclient"
+)
+
+func TestUnreadCountFollowsChatAccess(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+	ctx := context.Background()
+	guest := authclient.User{ID: 50, Role: authclient.RoleGuest, Room: "101"}
+	neighbour := authclient.User{ID: 51, Role: authclient.RoleGuest, Room: "101"}
+	staff := authclient.User{ID: 7, Role: authclient.RoleStaff, Username: "sam"}
+	tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: guest.ID, AssignedToUserID: &staff.ID})
+	id := strconv.FormatInt(tk.ID, 10)
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.repo.InsertChatMessage(ctx, ChatMessage{
+			TicketID: tk.ID, FromUserID: 1, FromUsername: "admin", FromRole: authclient.RoleAdmin,
+			Message: "on it", SentAt: time.Now(),
+		}, ChatCap{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unread := func(u authclient.User) *int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		a.GetTicket(w, request(http.MethodGet, "/api/tickets/"+id, "", "id", id), u)
+		if w.Code != http.StatusOK {
+			t.Fatalf("get as %s: status %d: %s", u.Role, w.Code, w.Body)
+		}
+		var got Ticket
+		decodeBody(t, w, &got)
+		return got.UnreadCount
+	}
+
+	// The guest who raised the ticket sees the staff replies as unread and
+	// can mark them read.
+	if n := unread(guest); n == nil || *n != 2 {
+		t.Fatalf("creator unread_count = %v, want 2", n)
+	}
+	w := httptest.NewRecorder()
+	a.MarkChatRead(w, request(http.MethodPost, "/api/tickets/"+id+"/chat/read", "", "id", id), guest)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creator mark read: status %d: %s", w.Code, w.Body)
+	}
+	if n := unread(guest); n == nil || *n != 0 {
+		t.Errorf("creator unread_count after read = %v, want 0", n)
+	}
+
+	// Another guest in the room sees the ticket but not its chat, so gets
+	// neither a count nor a marker.
+	if n := unread(neighbour); n != nil {
+		t.Errorf("neighbour unread_count = %d, want absent", *n)
+	}
+	w = httptest.NewRecorder()
+	a.MarkChatRead(w, request(http.MethodPost, "/api/tickets/"+id+"/chat/read", "", "id", id), neighbour)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("neighbour mark read: status %d, want 403", w.Code)
+	}
+
+	if n := unread(staff); n == nil || *n != 2 {
+		t.Fatalf("staff unread_count = %v, want 2", n)
+	}
+	w = httptest.NewRecorder()
+	a.MarkChatRead(w, request(http.MethodPost, "/api/tickets/"+id+"/chat/read", "", "id", id), staff)
+	if w.Code != http.StatusOK {
+		t.Fatalf("staff mark read: status %d: %s", w.Code, w.Body)
+	}
+	if n := unread(staff); n == nil || *n != 0 {
+		t.Errorf("staff unread_count after read = %v, want 0", n)
+	}
+}
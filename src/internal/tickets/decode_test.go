@@ -0,0 +1,62 @@
+package tickets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"src/internal/authclient"
+)
+
+func TestStrictDecodeOfTicketRequests(t *testing.T) {
+	guest := authclient.User{ID: 50, Role: authclient.RoleGuest, Room: "101"}
+	admin := authclient.User{ID: 1, Role: authclient.RoleAdmin}
+
+	tests := []struct {
+		name     string
+		create   bool // CreateTicketAsGuest, else UpdateStatus
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{"create valid", true, `{"type":"other","description":"leak"}`, http.StatusCreated, ""},
+		// Older clients send room; it is accepted and the session's room used.
+		{"create with room", true, `{"type":"other","description":"leak","room":"999"}`, http.StatusCreated, ""},
+		{"create misspelled field", true, `{"type":"other","descriptoin":"leak"}`, http.StatusBadRequest, `invalid json: unknown field "descriptoin"`},
+		{"status valid", false, `{"status":"IN_PROGRESS"}`, http.StatusOK, ""},
+		{"status extra field", false, `{"status":"IN_PROGRESS","note":"on my way"}`, http.StatusBadRequest, `invalid json: unknown field "note"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAPI(t, Options{})
+			w := httptest.NewRecorder()
+			if tt.create {
+				a.CreateTicketAsGuest(w, request(http.MethodPost, "/api/tickets", tt.body), guest)
+			} else {
+				id := strconv.FormatInt(mustCreate(t, a.repo, Ticket{CreatedByUserID: guest.ID}).ID, 10)
+				a.UpdateStatus(w, request(http.MethodPatch, "/api/tickets/"+id+"/status", tt.body, "id", id), admin)
+			}
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantErr != "" {
+				var body struct {
+					Error string `json:"error"`
+				}
+				decodeBody(t, w, &body)
+				if body.Error != tt.wantErr {
+					t.Errorf("error = %q, want %q", body.Error, tt.wantErr)
+				}
+				return
+			}
+			if tt.create {
+				var tk Ticket
+				decodeBody(t, w, &tk)
+				if tk.Room != guest.Room || tk.Description != "leak" {
+					t.Errorf("created room %q description %q, want %q and leak", tk.Room, tk.Description, guest.Room)
+				}
+			}
+		})
+	}
+}
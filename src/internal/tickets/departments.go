@@ -0,0 +1,200 @@
+package tickets
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"src/internal/authclient"
+	"src/internal/httpjson"
+	"src/internal/mq"
+)
+
+// maxDepartmentLen matches the auth service's limit on staff departments.
+const maxDepartmentLen = 64
+
+// checkDepartment trims dept and, when Options.Departments is set, matches
+// it against that list ignoring case, returning the configured spelling so
+// it compares equal to the staff departments the auth service stores. ""
+// clears the routing. A non-empty msg means dept is invalid.
+func (a *API) checkDepartment(dept string) (string, string) {
+	dept = strings.TrimSpace(dept)
+	if dept == "" {
+		return "", ""
+	}
+	if len(dept) > maxDepartmentLen {
+		return "", "department too long (max " + strconv.Itoa(maxDepartmentLen) + ")"
+	}
+	if len(a.opts.Departments) == 0 {
+		return dept, ""
+	}
+	for _, d := range a.opts.Departments {
+		if strings.EqualFold(d, dept) {
+			return d, ""
+		}
+	}
+	return "", "unknown department (allowed: " + strings.Join(a.opts.Departments, ", ") + ")"
+}
+
+// sameDepartment reports whether a ticket routed to ticketDept belongs to a
+// staffer of staffDept. Like the auth service it ignores case.
+func sameDepartment(ticketDept, staffDept string) bool {
+	return staffDept != "" && strings.EqualFold(ticketDept, staffDept)
+}
+
+type AssignDepartmentReq struct {
+	Department string `json:"department"`
+}
+
+// AssignDepartment routes a ticket to a department, whose staff can then
+// claim it; an empty department clears the routing. An individual
+// assignment is left as it is. RESOLVED and CANCELLED tickets answer 409.
+// Admin only.
+func (a *API) AssignDepartment(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req AssignDepartmentReq
+	if err := httpjson.Decode(r, &req, false); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+		return
+	}
+	dept, msg := a.checkDepartment(req.Department)
+	if msg != "" {
+		writeErr(w, http.StatusBadRequest, msg)
+		return
+	}
+	req.Department = dept
+
+	current, err := a.repo.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("assign department lookup", "ticket_id", id, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if current.Status == StatusResolved || current.Status == StatusCancelled {
+		writeErr(w, http.StatusConflict, "ticket is "+current.Status)
+		return
+	}
+
+	var t Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		t, err = tx.AssignDepartment(r.Context(), id, req.Department)
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, id, u, ActionDepartment, req.Department)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted or finished since the lookup.
+		writeErr(w, http.StatusConflict, "ticket was resolved, cancelled or deleted")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("assign department", "ticket_id", id, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	a.publish(mq.TopicTicketAssigned, EventPayload{Event: "department_assigned", Ticket: t})
+	writeJSON(w, http.StatusOK, t)
+}
+
+// ListDepartmentQueue returns the unclaimed tickets routed to the calling
+// staffer's department, oldest first. Staff only.
+func (a *API) ListDepartmentQueue(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleStaff {
+		writeErr(w, http.StatusForbidden, "staff only")
+		return
+	}
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
+	items := []Ticket{}
+	if u.Department != "" {
+		var err error
+		items, err = a.repo.ListDepartmentQueue(r.Context(), u.Department)
+		if err != nil {
+			a.log(r).Error("list department queue", "err", err)
+			writeErr(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		a.enrich(r.Context(), items)
+		localizeTickets(items, loc)
+		if items == nil {
+			items = []Ticket{}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"department": u.Department, "tickets": items})
+}
+
+// Claim assigns an unclaimed ticket of the staffer's department to them.
+// A ticket someone else claimed first answers 409. Staff only.
+func (a *API) Claim(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleStaff {
+		writeErr(w, http.StatusForbidden, "staff only")
+		return
+	}
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	current, err := a.repo.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("claim lookup", "ticket_id", id, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if !sameDepartment(current.AssignedDepartment, u.Department) {
+		writeErr(w, http.StatusForbidden, "not your department's ticket")
+		return
+	}
+	if current.Status == StatusResolved || current.Status == StatusCancelled {
+		writeErr(w, http.StatusConflict, "ticket is "+current.Status)
+		return
+	}
+
+	var t Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		t, err = tx.AssignIfUnassigned(r.Context(), id, u.ID)
+		if err != nil {
+			return err
+		}
+		return record(r.Context(), tx, id, u, ActionClaimed, "staff_user_id="+strconv.FormatInt(u.ID, 10))
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusConflict, "already claimed")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("claim", "ticket_id", id, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	a.publish(mq.TopicTicketAssigned, EventPayload{Event: "assigned", Ticket: t, AssignedTo: &u})
+	writeJSON(w, http.StatusOK, t)
+}
@@ -0,0 +1,76 @@
+package tickets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"src/internal/authclient"
+)
+
+func TestDepartmentRoutingIgnoresCase(t *testing.T) {
+	a, _ := newTestAPI(t, Options{Departments: []string{"Plumbing", "Housekeeping"}})
+	admin := authclient.User{ID: 1, Role: authclient.RoleAdmin, Username: "admin"}
+	// Auth stores the configured spelling, but older accounts may differ.
+	staff := authclient.User{ID: 7, Role: authclient.RoleStaff, Username: "sam", Department: "PLUMBING"}
+	tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: 50})
+	id := strconv.FormatInt(tk.ID, 10)
+
+	route := func(dept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.AssignDepartment(w, request(http.MethodPatch, "/api/tickets/"+id+"/department", `{"department":"`+dept+`"}`, "id", id), admin)
+		return w
+	}
+	if w := route("plumbin"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown department: status %d, want 400", w.Code)
+	}
+	w := route(" plumbing ")
+	if w.Code != http.StatusOK {
+		t.Fatalf("route: status %d: %s", w.Code, w.Body)
+	}
+	var routed Ticket
+	decodeBody(t, w, &routed)
+	if routed.AssignedDepartment != "Plumbing" {
+		t.Errorf("stored department %q, want the configured Plumbing", routed.AssignedDepartment)
+	}
+
+	queue, err := a.repo.ListDepartmentQueue(context.Background(), staff.Department)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].ID != tk.ID {
+		t.Errorf("queue for %q = %+v, want ticket %d", staff.Department, queue, tk.ID)
+	}
+	if !canView(staff, routed) {
+		t.Error("staff of the department can't view the routed ticket")
+	}
+
+	w = httptest.NewRecorder()
+	a.Claim(w, request(http.MethodPost, "/api/tickets/"+id+"/claim", "", "id", id), staff)
+	if w.Code != http.StatusOK {
+		t.Fatalf("claim: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAssignDepartmentRefusesFinishedTickets(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+	admin := authclient.User{ID: 1, Role: authclient.RoleAdmin, Username: "admin"}
+	for _, status := range []string{StatusResolved, StatusCancelled} {
+		tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: 50, Status: status})
+		id := strconv.FormatInt(tk.ID, 10)
+		w := httptest.NewRecorder()
+		a.AssignDepartment(w, request(http.MethodPatch, "/api/tickets/"+id+"/department", `{"department":"plumbing"}`, "id", id), admin)
+		if w.Code != http.StatusConflict {
+			t.Errorf("%s ticket: status %d, want 409", status, w.Code)
+		}
+		got, err := a.repo.Get(context.Background(), tk.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.AssignedDepartment != "" {
+			t.Errorf("%s ticket routed to %q", status, got.AssignedDepartment)
+		}
+	}
+}
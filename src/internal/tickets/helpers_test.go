@@ -0,0 +1,159 @@
+package tickets
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-chi/chi/v5"
+
+	"src/internal/authclient"
+	"src/internal/mq"
+	"src/internal/sqlitedb"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newTestRepo opens a fresh, migrated database in t's temp dir.
+func newTestRepo(t *testing.T) *Repository {
+	t.Helper()
+	db, err := sqlitedb.Open(filepath.Join(t.TempDir(), "tickets.db"), sqlitedb.Pool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := InitSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(db)
+}
+
+// newTestAPI wires an API over a fresh database, publishing to a fake broker
+// and without an auth service.
+func newTestAPI(t *testing.T, opts Options) (*API, *fakeMQTT) {
+	t.Helper()
+	broker := &fakeMQTT{}
+	pub := mq.NewPublisher(broker, nil, discardLogger)
+	return NewAPI(discardLogger, newTestRepo(t), pub, nil, opts), broker
+}
+
+// fakeMQTT is an always-connected mqtt.Client that records what is
+// published to it. Methods the tests don't use panic via the nil embed.
+type fakeMQTT struct {
+	mqtt.Client
+
+	mu        sync.Mutex
+	published []fakePublish
+}
+
+type fakePublish struct {
+	Topic    string
+	Retained bool
+	Payload  []byte
+}
+
+func (f *fakeMQTT) IsConnected() bool { return true }
+
+func (f *fakeMQTT) Publish(topic string, _ byte, retained bool, payload any) mqtt.Token {
+	b, _ := payload.([]byte)
+	f.mu.Lock()
+	f.published = append(f.published, fakePublish{Topic: topic, Retained: retained, Payload: append([]byte(nil), b...)})
+	f.mu.Unlock()
+	return doneToken{}
+}
+
+func (f *fakeMQTT) Published() []fakePublish {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakePublish(nil), f.published...)
+}
+
+// Topics lists the published topics in order.
+func (f *fakeMQTT) Topics() []string {
+	var out []string
+	for _, p := range f.Published() {
+		out = append(out, p.Topic)
+	}
+	return out
+}
+
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{}          { ch := make(chan struct{}); close(ch); return ch }
+func (doneToken) Error() error                   { return nil }
+
+// request builds a request with chi URL params given as name, value pairs.
+func request(method, target, body string, params ...string) *http.Request {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	rctx := chi.NewRouteContext()
+	for i := 0; i+1 < len(params); i += 2 {
+		rctx.URLParams.Add(params[i], params[i+1])
+	}
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+// decodeBody unmarshals a recorded JSON response into v.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+}
+
+// mustCreate stores a ticket, failing the test on error.
+func mustCreate(t *testing.T, repo *Repository, in Ticket) Ticket {
+	t.Helper()
+	if in.Type == "" {
+		in.Type = "other"
+	}
+	if in.Room == "" {
+		in.Room = "101"
+	}
+	if in.Description == "" {
+		in.Description = "leak"
+	}
+	tk, err := repo.Create(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tk
+}
+
+// newFakeAuth serves GET /api/users from users, filtered by ?role=, and
+// returns a client for it.
+func newFakeAuth(t *testing.T, users []authclient.User) *authclient.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/users" {
+			http.NotFound(w, r)
+			return
+		}
+		out := []authclient.User{}
+		for _, u := range users {
+			if role := r.URL.Query().Get("role"); role == "" || u.Role == role {
+				out = append(out, u)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(authclient.ListUsersResponse{Users: out, Total: len(out)})
+	}))
+	t.Cleanup(srv.Close)
+	return authclient.New(srv.URL, "test-key", authclient.Options{})
+}
@@ -0,0 +1,142 @@
+package tickets
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"src/internal/authclient"
+)
+
+// ResolutionStats summarises a group of resolution times, in seconds.
+// Percentiles use the nearest-rank method.
+type ResolutionStats struct {
+	Count      int   `json:"count"`
+	AvgSeconds int64 `json:"avg_seconds"`
+	P50Seconds int64 `json:"p50_seconds"`
+	P90Seconds int64 `json:"p90_seconds"`
+	MaxSeconds int64 `json:"max_seconds"`
+}
+
+// AssigneeResolution is ResolutionStats for one staffer's tickets.
+type AssigneeResolution struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username,omitempty"`
+	ResolutionStats
+}
+
+// ResolutionMetrics answers GET /api/admin/metrics/resolution.
+type ResolutionMetrics struct {
+	Overall    ResolutionStats            `json:"overall"`
+	ByType     map[string]ResolutionStats `json:"by_type"`
+	ByAssignee []AssigneeResolution       `json:"by_assignee"`
+	Unassigned *ResolutionStats           `json:"unassigned,omitempty"`
+}
+
+// ResolutionMetrics reports how long resolved tickets took, overall, by
+// type and by current assignee. Optional RFC3339 ?from=/?to= limit it to
+// tickets resolved in that range. Admin only.
+func (a *API) ResolutionMetrics(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	var from, to time.Time
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		s := r.URL.Query().Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid "+p.name+" (RFC3339)")
+			return
+		}
+		*p.dst = t
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		writeErr(w, http.StatusBadRequest, "from must not be after to")
+		return
+	}
+
+	samples, err := a.repo.ResolutionTimes(r.Context(), from, to)
+	if err != nil {
+		a.log(r).Error("resolution metrics", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	var all, unassigned []time.Duration
+	byType := map[string][]time.Duration{}
+	byUser := map[int64][]time.Duration{}
+	for _, s := range samples {
+		all = append(all, s.Duration)
+		byType[s.Type] = append(byType[s.Type], s.Duration)
+		if s.AssignedToUserID == nil {
+			unassigned = append(unassigned, s.Duration)
+			continue
+		}
+		byUser[*s.AssignedToUserID] = append(byUser[*s.AssignedToUserID], s.Duration)
+	}
+
+	out := ResolutionMetrics{
+		Overall:    resolutionStats(all),
+		ByType:     make(map[string]ResolutionStats, len(byType)),
+		ByAssignee: make([]AssigneeResolution, 0, len(byUser)),
+	}
+	for typ, ds := range byType {
+		out.ByType[typ] = resolutionStats(ds)
+	}
+	if len(unassigned) > 0 {
+		st := resolutionStats(unassigned)
+		out.Unassigned = &st
+	}
+
+	ids := make([]int64, 0, len(byUser))
+	for id := range byUser {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	names := map[int64]string{}
+	if len(ids) > 0 && a.auth != nil {
+		// Names are a convenience; the IDs alone still answer the question.
+		users, err := a.auth.GetUsersByIDs(r.Context(), ids)
+		if err != nil {
+			a.log(r).Warn("resolution metrics: staff names unavailable", "err", err)
+		}
+		for _, su := range users {
+			names[su.ID] = su.Username
+		}
+	}
+	for _, id := range ids {
+		out.ByAssignee = append(out.ByAssignee, AssigneeResolution{UserID: id, Username: names[id], ResolutionStats: resolutionStats(byUser[id])})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func resolutionStats(ds []time.Duration) ResolutionStats {
+	if len(ds) == 0 {
+		return ResolutionStats{}
+	}
+	sorted := slices.Clone(ds)
+	slices.Sort(sorted)
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	rank := func(p float64) int64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		return int64(sorted[max(i, 0)].Seconds())
+	}
+	return ResolutionStats{
+		Count:      len(sorted),
+		AvgSeconds: int64((sum / time.Duration(len(sorted))).Seconds()),
+		P50Seconds: rank(0.5),
+		P90Seconds: rank(0.9),
+		MaxSeconds: int64(sorted[len(sorted)-1].Seconds()),
+	}
+}
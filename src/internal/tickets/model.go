@@ -1,35 +1,66 @@
 package tickets
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type Ticket struct {
-	ID               int64     `json:"id"`
-	Type             string    `json:"type"`
-	Room             string    `json:"room"`
-	Description      string    `json:"description"`
-	Status           string    `json:"status"`
-	CreatedAt        time.Time `json:"created_at"`
-	CreatedByUserID  int64     `json:"created_by_user_id"`
-	AssignedToUserID *int64    `json:"assigned_to_user_id,omitempty"`
+	ID                 int64      `json:"id"`
+	Type               string     `json:"type"`
+	Room               string     `json:"room"`
+	Description        string     `json:"description"`
+	Status             string     `json:"status"`
+	CreatedAt          time.Time  `json:"created_at"`
+	CreatedByUserID    int64      `json:"created_by_user_id"`
+	AssignedToUserID   *int64     `json:"assigned_to_user_id,omitempty"`
+	AssignedDepartment string     `json:"assigned_department,omitempty"`
+	DeletedAt          *time.Time `json:"deleted_at,omitempty"`
+	Version            int64      `json:"version"`
+	AttachmentCount    int        `json:"attachment_count"`
+
+	// Chat messages the requesting user hasn't read; never stored. Absent
+	// when the user can't open the ticket's chat.
+	UnreadCount *int `json:"unread_count,omitempty"`
+
+	// Filled from the auth service when available; never stored.
+	CreatedByUsername  string `json:"created_by_username,omitempty"`
+	AssignedToUsername string `json:"assigned_to_username,omitempty"`
 }
 
 const (
 	StatusOpen       = "OPEN"
 	StatusInProgress = "IN_PROGRESS"
 	StatusResolved   = "RESOLVED"
+
+	// StatusCancelled is set by the creating guest while a ticket is still
+	// OPEN; it is final and not reachable through the status endpoint.
+	StatusCancelled = "CANCELLED"
 )
 
+// RoomSummary is one row of the open-tickets-per-room overview.
+type RoomSummary struct {
+	Room         string    `json:"room"`
+	Open         int       `json:"open"`
+	OldestOpenAt time.Time `json:"oldest_open_at"`
+}
+
+// Room is an entry of the room registry.
+type Room struct {
+	Room      string    `json:"room"`
+	Floor     *int      `json:"floor,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 func IsValidStatus(s string) bool {
-	return s == StatusOpen || s == StatusInProgress || s == StatusResolved
+	return s == StatusOpen || s == StatusInProgress || s == StatusResolved || s == StatusCancelled
 }
 
+// Types lists every ticket type; a role may be restricted to a subset.
+var Types = []string{"plumbing", "ac", "noise", "cleaning", "wifi", "other"}
+
 func IsValidType(t string) bool {
-	switch t {
-	case "plumbing", "ac", "noise", "cleaning", "wifi", "other":
-		return true
-	default:
-		return false
-	}
+	return slices.Contains(Types, t)
 }
 
 // --------------------
@@ -44,14 +75,77 @@ type ChatMessage struct {
 	FromRole     string    `json:"from_role"`
 	Message      string    `json:"message"`
 	SentAt       time.Time `json:"sent_at"`
+
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
+// ChatDeletedText replaces the text of a deleted message in listings.
+const ChatDeletedText = "message deleted"
+
 type ChatEventPayload struct {
-	Event        string    `json:"event"` // "chat_message"
+	Event        string    `json:"event"` // "chat_message", "chat_updated", "chat_deleted", "chat_typing"
 	TicketID     int64     `json:"ticket_id"`
+	MessageID    int64     `json:"message_id,omitempty"`
 	FromUserID   int64     `json:"from_user_id"`
 	FromUsername string    `json:"from_username"`
 	FromRole     string    `json:"from_role"`
 	Message      string    `json:"message"`
 	SentAt       time.Time `json:"sent_at"`
+
+	// Set by publishChat. MessageID above is the chat message's row ID.
+	EventID   string    `json:"event_id"`
+	EmittedAt time.Time `json:"emitted_at"`
+}
+
+func (p ChatEventPayload) EventType() string    { return p.Event }
+func (p ChatEventPayload) EventTime() time.Time { return p.EmittedAt }
+
+// --------------------
+// Activity (audit log)
+// --------------------
+
+const (
+	ActionCreated       = "created"
+	ActionStatusUpdated = "status_updated"
+	ActionAssigned      = "assigned"
+	ActionChatSent      = "chat_sent"
+	ActionChatEdited    = "chat_edited"
+	ActionChatDeleted   = "chat_deleted"
+	ActionCancelled     = "cancelled"
+	ActionEdited        = "edited"
+	ActionDeleted       = "deleted"
+	ActionAttached      = "attached"
+	ActionDepartment    = "department_assigned"
+	ActionClaimed       = "claimed"
+	ActionReopened      = "reopened"
+	ActionSuggested     = "reassign_suggested"
+)
+
+type TicketEvent struct {
+	ID          int64     `json:"id"`
+	TicketID    int64     `json:"ticket_id"`
+	ActorUserID int64     `json:"actor_user_id"`
+	ActorRole   string    `json:"actor_role"`
+	Action      string    `json:"action"`
+	Detail      string    `json:"detail,omitempty"`
+	ToStatus    string    `json:"to_status,omitempty"` // status a status change moved the ticket to
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// --------------------
+// Attachments
+// --------------------
+
+type Attachment struct {
+	ID               int64     `json:"id"`
+	TicketID         int64     `json:"ticket_id"`
+	URL              string    `json:"url"`
+	UploadedByUserID int64     `json:"uploaded_by_user_id"`
+	CreatedAt        time.Time `json:"created_at"`
+
+	// Set for uploaded files only; URL then points at the download route.
+	FileName    string `json:"file_name,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size_bytes,omitempty"`
 }
@@ -0,0 +1,46 @@
+package tickets
+
+import (
+	"context"
+	"time"
+
+	"src/internal/mq"
+)
+
+// RunOverdueWatcher periodically publishes an "overdue" event for every
+// unresolved ticket older than sla. Each ticket alerts at most once; the
+// overdue_notified_at column remembers which ones already fired.
+// It blocks until ctx is cancelled.
+func (a *API) RunOverdueWatcher(ctx context.Context, sla, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.scanOverdue(ctx, sla)
+		}
+	}
+}
+
+func (a *API) scanOverdue(ctx context.Context, sla time.Duration) {
+	// Don't mark tickets as notified while the event would be dropped.
+	if !a.pub.Connected() {
+		return
+	}
+
+	items, err := a.repo.ListOverdueUnnotified(ctx, time.Now().UTC().Add(-sla))
+	if err != nil {
+		a.logger.Error("overdue scan", "err", err)
+		return
+	}
+	for _, t := range items {
+		if err := a.repo.MarkOverdueNotified(ctx, t.ID); err != nil {
+			a.logger.Error("mark overdue", "ticket_id", t.ID, "err", err)
+			continue
+		}
+		a.publish(mq.TopicTicketOverdue, EventPayload{Event: "overdue", Ticket: t})
+	}
+}
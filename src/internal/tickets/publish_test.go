@@ -0,0 +1,78 @@
+package tickets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"src/internal/authclient"
+)
+
+// TestNoPublishWhenWriteFails makes every audit-log insert fail, so each
+// handler's transaction rolls back after its main write went through, and
+// checks that nothing reaches the broker.
+func TestNoPublishWhenWriteFails(t *testing.T) {
+	guest := authclient.User{ID: 50, Role: authclient.RoleGuest, Room: "101"}
+	admin := authclient.User{ID: 1, Role: authclient.RoleAdmin, Username: "admin"}
+	staff := authclient.User{ID: 7, Role: authclient.RoleStaff, Username: "sam"}
+
+	tests := []struct {
+		name string
+		call func(a *API, w http.ResponseWriter, id string)
+	}{
+		{"create", func(a *API, w http.ResponseWriter, id string) {
+			a.CreateTicketAsGuest(w, request(http.MethodPost, "/api/tickets", `{"type":"other","description":"leak"}`), guest)
+		}},
+		{"update status", func(a *API, w http.ResponseWriter, id string) {
+			a.UpdateStatus(w, request(http.MethodPatch, "/api/tickets/"+id+"/status", `{"status":"IN_PROGRESS"}`, "id", id), admin)
+		}},
+		{"assign", func(a *API, w http.ResponseWriter, id string) {
+			a.Assign(w, request(http.MethodPost, "/api/tickets/"+id+"/assign", `{"staff_user_id":7}`, "id", id), admin)
+		}},
+		{"send chat", func(a *API, w http.ResponseWriter, id string) {
+			a.SendChat(w, request(http.MethodPost, "/api/tickets/"+id+"/chat", `{"message":"hi"}`, "id", id), admin)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, broker := newTestAPI(t, Options{})
+			a.auth = newFakeAuth(t, []authclient.User{staff})
+			tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: guest.ID})
+			before, err := a.repo.Get(context.Background(), tk.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.repo.db.ExecContext(context.Background(), `
+				CREATE TRIGGER fail_events BEFORE INSERT ON ticket_events
+				BEGIN SELECT RAISE(ABORT, 'forced failure'); END`); err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			tt.call(a, w, strconv.FormatInt(tk.ID, 10))
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status %d, want 500: %s", w.Code, w.Body)
+			}
+			if got := broker.Topics(); len(got) != 0 {
+				t.Errorf("published %v after a failed write", got)
+			}
+
+			// The rolled-back change is not visible either.
+			got, err := a.repo.Get(context.Background(), tk.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != before.Version || got.Status != before.Status || got.AssignedToUserID != nil {
+				t.Errorf("ticket changed despite the failure: %+v", got)
+			}
+			if n := countRows(t, a.repo.db, "tickets", ""); n != 1 {
+				t.Errorf("%d tickets stored, want 1", n)
+			}
+			if n := countRows(t, a.repo.db, "chat_messages", ""); n != 0 {
+				t.Errorf("%d chat messages stored, want 0", n)
+			}
+		})
+	}
+}
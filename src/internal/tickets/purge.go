@@ -0,0 +1,66 @@
+package tickets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// purgeBatch bounds how many tickets one purge transaction removes, so the
+// write lock is never held for long.
+const purgeBatch = 200
+
+// RunPurge periodically deletes finished tickets (RESOLVED or CANCELLED)
+// with no activity for retention, along with their chat, audit log and
+// attachments. It blocks until ctx is cancelled.
+func (a *API) RunPurge(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.purge(ctx, retention)
+		}
+	}
+}
+
+func (a *API) purge(ctx context.Context, retention time.Duration) {
+	cutoff := time.Now().UTC().Add(-retention)
+	var total PurgeResult
+	var tickets int
+	for ctx.Err() == nil {
+		res, err := a.repo.PurgeFinished(ctx, cutoff, purgeBatch)
+		if err != nil {
+			a.logger.Error("purge", "err", err)
+			break
+		}
+		// Rows are gone, so drop the files even if this fails part way.
+		if a.opts.Upload.Dir != "" {
+			for _, id := range res.TicketIDs {
+				dir := filepath.Join(a.opts.Upload.Dir, strconv.FormatInt(id, 10))
+				if err := os.RemoveAll(dir); err != nil {
+					a.logger.Warn("purge attachments", "ticket_id", id, "err", err)
+				}
+			}
+		}
+		tickets += len(res.TicketIDs)
+		total.ChatMessages += res.ChatMessages
+		total.Attachments += res.Attachments
+		total.Events += res.Events
+		if len(res.TicketIDs) < purgeBatch {
+			break
+		}
+	}
+	a.logger.Info("purge finished tickets",
+		"cutoff", cutoff.Format(time.RFC3339),
+		"tickets", tickets,
+		"chat_messages", total.ChatMessages,
+		"attachments", total.Attachments,
+		"events", total.Events,
+	)
+}
@@ -0,0 +1,144 @@
+package tickets
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"src/internal/authclient"
+	"src/internal/httpjson"
+	"src/internal/mq"
+)
+
+// maxReopenReasonLen caps a reopen reason in characters.
+const maxReopenReasonLen = 500
+
+// ReopenReq reopens a finished ticket. Status is IN_PROGRESS or OPEN; blank
+// means IN_PROGRESS when someone is assigned and OPEN otherwise.
+type ReopenReq struct {
+	Reason  string `json:"reason"`
+	Status  string `json:"status,omitempty"`
+	Version int64  `json:"version,omitempty"`
+}
+
+// Reopen moves a RESOLVED ticket back into work and records why. Admins may
+// reopen any ticket, the assigned staffer their own, and the creating guest
+// theirs when Options.GuestCanReopen is set. Only admins may reopen a
+// CANCELLED ticket.
+func (a *API) Reopen(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req ReopenReq
+	if err := httpjson.Decode(r, &req, true); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeErr(w, http.StatusBadRequest, "reason required")
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > maxReopenReasonLen {
+		writeErr(w, http.StatusBadRequest, "reason too long")
+		return
+	}
+	if req.Status != "" && req.Status != StatusOpen && req.Status != StatusInProgress {
+		writeErr(w, http.StatusBadRequest, "invalid status (OPEN/IN_PROGRESS)")
+		return
+	}
+	expected := req.Version
+	if h := r.Header.Get("If-Match"); h != "" {
+		v, ok := parseETag(h)
+		if !ok {
+			writeErr(w, http.StatusBadRequest, "invalid If-Match")
+			return
+		}
+		expected = v
+	}
+
+	current, err := a.repo.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	switch u.Role {
+	case authclient.RoleAdmin:
+	case authclient.RoleStaff:
+		if current.AssignedToUserID == nil || *current.AssignedToUserID != u.ID {
+			writeErr(w, http.StatusForbidden, "staff can reopen only their own tickets")
+			return
+		}
+	case authclient.RoleGuest:
+		if !a.opts.GuestCanReopen || current.CreatedByUserID != u.ID {
+			writeErr(w, http.StatusForbidden, "not allowed to reopen")
+			return
+		}
+	default:
+		writeErr(w, http.StatusForbidden, "not allowed to reopen")
+		return
+	}
+	switch current.Status {
+	case StatusResolved:
+	case StatusCancelled:
+		if u.Role != authclient.RoleAdmin {
+			writeErr(w, http.StatusConflict, "only an admin can reopen a cancelled ticket")
+			return
+		}
+	default:
+		writeErr(w, http.StatusConflict, "only RESOLVED or CANCELLED tickets can be reopened")
+		return
+	}
+
+	status := req.Status
+	if status == "" {
+		status = StatusOpen
+		if current.AssignedToUserID != nil {
+			status = StatusInProgress
+		}
+	}
+	// Without a client version, pin the one just read so a concurrent
+	// change can't be reopened over.
+	if expected == 0 {
+		expected = current.Version
+	}
+
+	var updated Ticket
+	err = a.repo.WithTx(r.Context(), func(tx *Repository) error {
+		var err error
+		updated, err = tx.UpdateStatus(r.Context(), id, status, expected)
+		if err != nil {
+			return err
+		}
+		return recordStatus(r.Context(), tx, id, u, ActionReopened, updated.Status, current.Status+" -> "+updated.Status+": "+req.Reason)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		writeErr(w, http.StatusConflict, "ticket was modified; reload and retry")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("reopen", "ticket_id", id, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	a.publish(mq.TopicTicketStatusUpdated, EventPayload{Event: "reopened", Ticket: updated})
+	a.publish(mq.TicketStateTopic(updated.ID), EventPayload{Event: "reopened", Ticket: updated})
+	w.Header().Set("ETag", etag(updated))
+	writeJSON(w, http.StatusOK, updated)
+}
@@ -4,15 +4,45 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"math"
+	"strings"
 	"time"
 )
 
+// dbtx is what the repository methods need; both *sql.DB and *sql.Tx have it.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Repository struct {
-	db *sql.DB
+	db   dbtx
+	conn *sql.DB // nil when the repository is bound to a transaction
 }
 
 func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+	return &Repository{db: db, conn: db}
+}
+
+// WithTx runs fn with a repository bound to a single transaction, committing
+// if fn returns nil and rolling back otherwise. fn's error is returned as is.
+// Called on a repository that is already in a transaction, it just runs fn
+// in that transaction.
+func (r *Repository) WithTx(ctx context.Context, fn func(tx *Repository) error) error {
+	if r.conn == nil {
+		return fn(r)
+	}
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(&Repository{db: tx}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // InitSchema performs a tiny migration that works even if you ran the old schema before.
@@ -53,30 +83,300 @@ CREATE INDEX IF NOT EXISTS idx_tickets_assigned ON tickets(assigned_to_user_id);
 			return err
 		}
 	}
+	if !cols["overdue_notified_at"] {
+		if _, err := db.Exec(`ALTER TABLE tickets ADD COLUMN overdue_notified_at TEXT NULL`); err != nil {
+			return err
+		}
+	}
+	if !cols["deleted_at"] {
+		if _, err := db.Exec(`ALTER TABLE tickets ADD COLUMN deleted_at TEXT NULL`); err != nil {
+			return err
+		}
+	}
+	// Bumped by every update, for optimistic concurrency control.
+	if !cols["version"] {
+		if _, err := db.Exec(`ALTER TABLE tickets ADD COLUMN version INTEGER NOT NULL DEFAULT 1`); err != nil {
+			return err
+		}
+	}
+	// Department a ticket is routed to before (or instead of) a person.
+	if !cols["assigned_department"] {
+		if _, err := db.Exec(`ALTER TABLE tickets ADD COLUMN assigned_department TEXT NULL`); err != nil {
+			return err
+		}
+	}
 
 	// --------------------
-	// Chat messages table
+	// Ticket child tables: chat, read markers, audit log, assignment
+	// history and attachments
+	// --------------------
+	for _, t := range ticketChildTables {
+		if err := migrateChildTable(db, t); err != nil {
+			return err
+		}
+	}
+
+	// --------------------
+	// Room registry (enforced only when RoomRegistry is on)
 	// --------------------
 	_, err = db.Exec(`
-CREATE TABLE IF NOT EXISTS chat_messages (
+CREATE TABLE IF NOT EXISTS rooms (
+  room TEXT PRIMARY KEY,
+  created_at TEXT NOT NULL
+);
+`)
+	if err != nil {
+		return err
+	}
+	rcols, err := tableColumns(db, "rooms")
+	if err != nil {
+		return err
+	}
+	// Optional floor, for dispatching by floor.
+	if !rcols["floor"] {
+		if _, err := db.Exec(`ALTER TABLE rooms ADD COLUMN floor INTEGER NULL`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// childTable is a table whose rows belong to one ticket.
+type childTable struct {
+	name string
+	// columns is the table's full, current definition. A column added
+	// since the table first shipped must be nullable or have a default, so
+	// older databases can gain it with ALTER TABLE.
+	columns string
+	indexes string
+	// seed runs once, when the table is created.
+	seed string
+	// backfill runs once per column, when it is added to an existing table.
+	backfill map[string]string
+}
+
+// ticketChildTables are the only definition of the ticket child tables:
+// InitSchema creates, extends and, if need be, rebuilds each of them from
+// its entry, in this order.
+var ticketChildTables = []childTable{
+	{name: "chat_messages", columns: `
   id INTEGER PRIMARY KEY AUTOINCREMENT,
-  ticket_id INTEGER NOT NULL,
+  ticket_id INTEGER NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
   from_user_id INTEGER NOT NULL,
   from_username TEXT NOT NULL,
   from_role TEXT NOT NULL,
   message TEXT NOT NULL,
-  sent_at TEXT NOT NULL
-);
+  sent_at TEXT NOT NULL,
+  edited_at TEXT NULL,
+  deleted_at TEXT NULL`, indexes: `
 CREATE INDEX IF NOT EXISTS idx_chat_ticket_id ON chat_messages(ticket_id);
-CREATE INDEX IF NOT EXISTS idx_chat_sent_at ON chat_messages(sent_at);
-`)
+CREATE INDEX IF NOT EXISTS idx_chat_sent_at ON chat_messages(sent_at);`},
+
+	// How far each user has read a ticket's chat.
+	{name: "chat_reads", columns: `
+  ticket_id INTEGER NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL,
+  last_read_id INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (ticket_id, user_id)`},
+
+	// Audit log. to_status is the status a status change moved the ticket
+	// to, so reports don't have to parse detail. Older entries are filled in
+	// from their detail, which reads "FROM -> TO", or "FROM -> TO: reason"
+	// for a reopen.
+	{name: "ticket_events", columns: `
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ticket_id INTEGER NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+  actor_user_id INTEGER NOT NULL,
+  actor_role TEXT NOT NULL,
+  action TEXT NOT NULL,
+  detail TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  to_status TEXT NULL`, indexes: `
+CREATE INDEX IF NOT EXISTS idx_ticket_events_ticket_id ON ticket_events(ticket_id);
+CREATE INDEX IF NOT EXISTS idx_ticket_events_actor ON ticket_events(actor_user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_ticket_events_to_status ON ticket_events(to_status, ticket_id);`,
+		backfill: map[string]string{"to_status": `
+UPDATE ticket_events SET to_status = substr(detail, instr(detail, ' -> ') + 4)
+WHERE action = 'status_updated' AND instr(detail, ' -> ') > 0;
+UPDATE ticket_events SET to_status = substr(detail, instr(detail, ' -> ') + 4, instr(detail, ': ') - instr(detail, ' -> ') - 4)
+WHERE action = 'reopened' AND instr(detail, ' -> ') > 0 AND instr(detail, ': ') > instr(detail, ' -> ');
+UPDATE ticket_events SET to_status = 'CANCELLED' WHERE action = 'cancelled';`}},
+
+	// Assignment history: every staffer a ticket was ever assigned to. A new
+	// table is seeded from current assignees and the audit log, whose assign
+	// entries read "staff_user_id=N" (CAST stops at the first non-digit).
+	{name: "ticket_assignments", columns: `
+  ticket_id INTEGER NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+  staff_user_id INTEGER NOT NULL,
+  assigned_at TEXT NOT NULL,
+  PRIMARY KEY (ticket_id, staff_user_id)`, indexes: `
+CREATE INDEX IF NOT EXISTS idx_ticket_assignments_staff ON ticket_assignments(staff_user_id);`,
+		seed: `
+INSERT OR IGNORE INTO ticket_assignments(ticket_id, staff_user_id, assigned_at)
+SELECT ticket_id, CAST(substr(detail, 15) AS INTEGER), MIN(created_at)
+FROM ticket_events
+WHERE action = 'assigned' AND detail LIKE 'staff_user_id=%'
+  AND ticket_id IN (SELECT id FROM tickets)
+GROUP BY 1, 2;
+INSERT OR IGNORE INTO ticket_assignments(ticket_id, staff_user_id, assigned_at)
+SELECT id, assigned_to_user_id, created_at FROM tickets WHERE assigned_to_user_id IS NOT NULL;`},
+
+	// Attachments: external links, or uploaded files with their stored
+	// name, MIME type and size (blank/0 for links).
+	{name: "ticket_attachments", columns: `
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ticket_id INTEGER NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+  url TEXT NOT NULL,
+  uploaded_by_user_id INTEGER NOT NULL,
+  created_at TEXT NOT NULL,
+  file_name TEXT NOT NULL DEFAULT '',
+  content_type TEXT NOT NULL DEFAULT '',
+  size_bytes INTEGER NOT NULL DEFAULT 0`, indexes: `
+CREATE INDEX IF NOT EXISTS idx_ticket_attachments_ticket_id ON ticket_attachments(ticket_id);`},
+}
+
+// migrateChildTable brings t up to its definition: it creates the table,
+// or adds the columns an older one lacks, then makes sure ticket_id
+// cascades (see rebuildWithTicketFK) and the indexes exist.
+func migrateChildTable(db *sql.DB, t childTable) error {
+	existing, err := tableColumns(db, t.name)
 	if err != nil {
 		return err
 	}
+	cols, err := columnDefs(t.columns)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.name, err)
+	}
 
+	var added []string
+	if len(existing) == 0 {
+		if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + t.name + ` (` + t.columns + `
+)`); err != nil {
+			return err
+		}
+	} else {
+		for _, c := range cols {
+			if existing[c.name] {
+				continue
+			}
+			if _, err := db.Exec(`ALTER TABLE ` + t.name + ` ADD COLUMN ` + c.def); err != nil {
+				return fmt.Errorf("add %s.%s: %w", t.name, c.name, err)
+			}
+			added = append(added, c.name)
+		}
+		if err := rebuildWithTicketFK(db, t, cols); err != nil {
+			return err
+		}
+	}
+	if t.indexes != "" {
+		if _, err := db.Exec(t.indexes); err != nil {
+			return err
+		}
+	}
+
+	if len(existing) == 0 && t.seed != "" {
+		if _, err := db.Exec(t.seed); err != nil {
+			return fmt.Errorf("seed %s: %w", t.name, err)
+		}
+	}
+	for _, name := range added {
+		if q := t.backfill[name]; q != "" {
+			if _, err := db.Exec(q); err != nil {
+				return fmt.Errorf("backfill %s.%s: %w", t.name, name, err)
+			}
+		}
+	}
 	return nil
 }
 
+// rebuildWithTicketFK gives a table created before ticket_id carried a
+// foreign key a cascading one, so hard-deleting a ticket takes its chat,
+// audit log and the rest with it. SQLite can't add a constraint in place,
+// so the table is copied into a new one built from its definition and
+// swapped in, in one transaction. Rows pointing at tickets that no longer
+// exist are dropped on the way. The table must already have every column
+// of cols.
+func rebuildWithTicketFK(db *sql.DB, t childTable, cols []columnDef) error {
+	ok, err := hasTicketFK(db, t.name)
+	if err != nil || ok {
+		return err
+	}
+
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.name
+	}
+	list := strings.Join(names, ", ")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Keep AUTOINCREMENT from reusing ids of rows deleted from the end.
+	var seq sql.NullInt64
+	_ = tx.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name=?`, t.name).Scan(&seq)
+
+	_, err = tx.Exec(`CREATE TABLE ` + t.name + `_new (` + t.columns + `
+);
+INSERT INTO ` + t.name + `_new(` + list + `)
+SELECT ` + list + ` FROM ` + t.name + ` WHERE ticket_id IN (SELECT id FROM tickets);
+DROP TABLE ` + t.name + `;
+ALTER TABLE ` + t.name + `_new RENAME TO ` + t.name + `;`)
+	if err == nil && seq.Valid {
+		_, err = tx.Exec(`UPDATE sqlite_sequence SET seq=MAX(seq, ?) WHERE name=?`, seq.Int64, t.name)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("add ticket foreign key to %s: %w", t.name, err)
+	}
+	return tx.Commit()
+}
+
+// hasTicketFK reports whether table's ticket_id references tickets with
+// ON DELETE CASCADE.
+func hasTicketFK(db *sql.DB, table string) (bool, error) {
+	rows, err := db.Query(`SELECT "table", "from", on_delete FROM pragma_foreign_key_list(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var parent, from, onDelete string
+		if err := rows.Scan(&parent, &from, &onDelete); err != nil {
+			return false, err
+		}
+		if parent == "tickets" && from == "ticket_id" && onDelete == "CASCADE" {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// columnDef is one column of a childTable definition: its name and its
+// full definition, as ALTER TABLE ADD COLUMN takes it.
+type columnDef struct {
+	name, def string
+}
+
+// columnDefs lists the columns of a childTable definition, skipping table
+// constraints.
+func columnDefs(def string) ([]columnDef, error) {
+	var out []columnDef
+	for _, line := range strings.Split(def, "\n") {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+		f := strings.Fields(line)
+		if len(f) == 0 || f[0] == "PRIMARY" {
+			continue
+		}
+		out = append(out, columnDef{name: f[0], def: line})
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no columns")
+	}
+	return out, nil
+}
+
 func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
 	rows, err := db.Query(`PRAGMA table_info(` + table + `)`)
 	if err != nil {
@@ -121,14 +421,30 @@ func (r *Repository) Create(ctx context.Context, in Ticket) (Ticket, error) {
 	return in, nil
 }
 
+// Get returns a live ticket; soft-deleted tickets are reported as
+// sql.ErrNoRows.
 func (r *Repository) Get(ctx context.Context, id int64) (Ticket, error) {
+	return r.get(ctx, id, false)
+}
+
+// GetWithDeleted is Get that also returns soft-deleted tickets.
+func (r *Repository) GetWithDeleted(ctx context.Context, id int64) (Ticket, error) {
+	return r.get(ctx, id, true)
+}
+
+func (r *Repository) get(ctx context.Context, id int64, includeDeleted bool) (Ticket, error) {
+	q := `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets WHERE id=?`
+	if !includeDeleted {
+		q += ` AND deleted_at IS NULL`
+	}
 	var t Ticket
 	var created string
 	var assigned sql.NullInt64
-	err := r.db.QueryRowContext(ctx,
-		`SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id
-		 FROM tickets WHERE id=?`, id,
-	).Scan(&t.ID, &t.Type, &t.Room, &t.Description, &t.Status, &created, &t.CreatedByUserID, &assigned)
+	var deleted, dept sql.NullString
+	err := r.db.QueryRowContext(ctx, q, id).
+		Scan(&t.ID, &t.Type, &t.Room, &t.Description, &t.Status, &created, &t.CreatedByUserID, &assigned, &deleted, &t.Version, &dept, &t.AttachmentCount)
 	if errors.Is(err, sql.ErrNoRows) {
 		return Ticket{}, sql.ErrNoRows
 	}
@@ -140,29 +456,218 @@ func (r *Repository) Get(ctx context.Context, id int64) (Ticket, error) {
 		v := assigned.Int64
 		t.AssignedToUserID = &v
 	}
+	if deleted.Valid {
+		v := parseTime(deleted.String)
+		t.DeletedAt = &v
+	}
+	t.AssignedDepartment = dept.String
 	return t, nil
 }
 
+// FindOpenDuplicate returns the newest live OPEN or IN_PROGRESS ticket of
+// type typ for room, or sql.ErrNoRows if there is none.
+func (r *Repository) FindOpenDuplicate(ctx context.Context, room, typ string) (Ticket, error) {
+	items, err := r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets WHERE room=? AND type=? AND status IN (?, ?) AND deleted_at IS NULL
+		 ORDER BY id DESC LIMIT 1`, room, typ, StatusOpen, StatusInProgress)
+	if err != nil {
+		return Ticket{}, err
+	}
+	if len(items) == 0 {
+		return Ticket{}, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
 func (r *Repository) ListAll(ctx context.Context) ([]Ticket, error) {
-	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id
-		 FROM tickets
+	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets WHERE deleted_at IS NULL
 		 ORDER BY datetime(created_at) DESC, id DESC`)
 }
 
 func (r *Repository) ListByRoom(ctx context.Context, room string) ([]Ticket, error) {
-	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id
-		 FROM tickets WHERE room=?
+	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets WHERE room=? AND deleted_at IS NULL
 		 ORDER BY datetime(created_at) DESC, id DESC`, room)
 }
 
 func (r *Repository) ListAssignedTo(ctx context.Context, staffUserID int64) ([]Ticket, error) {
-	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id
-		 FROM tickets WHERE assigned_to_user_id=?
+	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets WHERE assigned_to_user_id=? AND deleted_at IS NULL
 		 ORDER BY datetime(created_at) DESC, id DESC`, staffUserID)
 }
 
-func (r *Repository) UpdateStatus(ctx context.Context, id int64, status string) (Ticket, error) {
-	res, err := r.db.ExecContext(ctx, `UPDATE tickets SET status=? WHERE id=?`, status, id)
+// ListCreatedBy returns the tickets a user raised, whatever room they were
+// in at the time.
+func (r *Repository) ListCreatedBy(ctx context.Context, userID int64) ([]Ticket, error) {
+	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets WHERE created_by_user_id=? AND deleted_at IS NULL
+		 ORDER BY datetime(created_at) DESC, id DESC`, userID)
+}
+
+// ListRooms returns the registered rooms in name order.
+func (r *Repository) ListRooms(ctx context.Context) ([]Room, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT room, floor, created_at FROM rooms ORDER BY room`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Room{}
+	for rows.Next() {
+		var rm Room
+		var floor sql.NullInt64
+		var created string
+		if err := rows.Scan(&rm.Room, &floor, &created); err != nil {
+			return nil, err
+		}
+		if floor.Valid {
+			v := int(floor.Int64)
+			rm.Floor = &v
+		}
+		rm.CreatedAt = parseTime(created)
+		out = append(out, rm)
+	}
+	return out, rows.Err()
+}
+
+// AddRoom registers room and reports whether it was new. For a room that
+// already exists a non-nil floor replaces the stored one.
+func (r *Repository) AddRoom(ctx context.Context, room string, floor *int) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `INSERT OR IGNORE INTO rooms(room, floor, created_at) VALUES(?,?,?)`,
+		room, floor, time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+	if floor != nil {
+		_, err = r.db.ExecContext(ctx, `UPDATE rooms SET floor=? WHERE room=?`, *floor, room)
+	}
+	return false, err
+}
+
+// HasFloorMapping reports whether any registered room has a floor.
+func (r *Repository) HasFloorMapping(ctx context.Context) (bool, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM rooms WHERE floor IS NOT NULL`).Scan(&n)
+	return n > 0, err
+}
+
+// DeleteRoom unregisters room; it returns sql.ErrNoRows if it wasn't
+// registered. Tickets already filed for the room are left alone.
+func (r *Repository) DeleteRoom(ctx context.Context, room string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM rooms WHERE room=?`, room)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RoomExists reports whether room is registered.
+func (r *Repository) RoomExists(ctx context.Context, room string) (bool, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM rooms WHERE room=?`, room).Scan(&n)
+	return n > 0, err
+}
+
+// ListFilter narrows a ticket listing. Zero fields are ignored, so the zero
+// value lists everything.
+type ListFilter struct {
+	Room             string
+	AssignedToUserID int64
+	Status           string
+	Type             string
+	From             time.Time // inclusive
+	To               time.Time // inclusive
+
+	// Floor keeps tickets whose room is registered on that floor.
+	Floor *int
+
+	// IncludeDeleted also lists soft-deleted tickets (admin only).
+	IncludeDeleted bool
+}
+
+func (f ListFilter) query() (string, []any) {
+	q := `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets WHERE 1=1`
+	var args []any
+	if !f.IncludeDeleted {
+		q += ` AND deleted_at IS NULL`
+	}
+	if f.Room != "" {
+		q += ` AND room=?`
+		args = append(args, f.Room)
+	}
+	if f.AssignedToUserID != 0 {
+		q += ` AND assigned_to_user_id=?`
+		args = append(args, f.AssignedToUserID)
+	}
+	if f.Status != "" {
+		q += ` AND status=?`
+		args = append(args, f.Status)
+	}
+	if f.Type != "" {
+		q += ` AND type=?`
+		args = append(args, f.Type)
+	}
+	if f.Floor != nil {
+		q += ` AND room IN (SELECT room FROM rooms WHERE floor=?)`
+		args = append(args, *f.Floor)
+	}
+	// datetime() normalizes the stored RFC3339Nano strings the same way the
+	// ORDER BY does, so the comparison isn't a raw string compare.
+	switch {
+	case !f.From.IsZero() && !f.To.IsZero():
+		q += ` AND datetime(created_at) BETWEEN datetime(?) AND datetime(?)`
+		args = append(args, f.From.UTC().Format(time.RFC3339), f.To.UTC().Format(time.RFC3339))
+	case !f.From.IsZero():
+		q += ` AND datetime(created_at) >= datetime(?)`
+		args = append(args, f.From.UTC().Format(time.RFC3339))
+	case !f.To.IsZero():
+		q += ` AND datetime(created_at) <= datetime(?)`
+		args = append(args, f.To.UTC().Format(time.RFC3339))
+	}
+	q += ` ORDER BY datetime(created_at) DESC, id DESC`
+	return q, args
+}
+
+func (r *Repository) ListFiltered(ctx context.Context, f ListFilter) ([]Ticket, error) {
+	q, args := f.query()
+	return r.list(ctx, q, args...)
+}
+
+// EachFiltered streams the tickets matching f to fn, newest first.
+func (r *Repository) EachFiltered(ctx context.Context, f ListFilter, fn func(Ticket) error) error {
+	q, args := f.query()
+	return r.each(ctx, fn, q, args...)
+}
+
+// ErrVersionConflict means the ticket changed since the version the caller
+// read; it should reload and retry.
+var ErrVersionConflict = errors.New("tickets: ticket was modified concurrently")
+
+// UpdateStatus sets a ticket's status. With expectedVersion > 0 the update
+// only applies if the ticket is still at that version, and
+// ErrVersionConflict is returned otherwise.
+func (r *Repository) UpdateStatus(ctx context.Context, id int64, status string, expectedVersion int64) (Ticket, error) {
+	q := `UPDATE tickets SET status=?, version=version+1 WHERE id=? AND deleted_at IS NULL`
+	args := []any{status, id}
+	if expectedVersion > 0 {
+		q += ` AND version=?`
+		args = append(args, expectedVersion)
+	}
+	res, err := r.db.ExecContext(ctx, q, args...)
 	if err != nil {
 		return Ticket{}, err
 	}
@@ -171,13 +676,63 @@ func (r *Repository) UpdateStatus(ctx context.Context, id int64, status string)
 		return Ticket{}, err
 	}
 	if n == 0 {
+		if expectedVersion > 0 {
+			if _, err := r.Get(ctx, id); err == nil {
+				return Ticket{}, ErrVersionConflict
+			}
+		}
 		return Ticket{}, sql.ErrNoRows
 	}
 	return r.Get(ctx, id)
 }
 
 func (r *Repository) Assign(ctx context.Context, id int64, staffUserID int64) (Ticket, error) {
-	res, err := r.db.ExecContext(ctx, `UPDATE tickets SET assigned_to_user_id=? WHERE id=?`, staffUserID, id)
+	var t Ticket
+	err := r.WithTx(ctx, func(tx *Repository) error {
+		res, err := tx.db.ExecContext(ctx, `UPDATE tickets SET assigned_to_user_id=?, version=version+1 WHERE id=? AND deleted_at IS NULL`, staffUserID, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+		if err := tx.recordAssignment(ctx, id, staffUserID); err != nil {
+			return err
+		}
+		t, err = tx.Get(ctx, id)
+		return err
+	})
+	return t, err
+}
+
+// recordAssignment adds staffUserID to the ticket's assignment history.
+func (r *Repository) recordAssignment(ctx context.Context, ticketID, staffUserID int64) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO ticket_assignments(ticket_id, staff_user_id, assigned_at) VALUES(?,?,?)`,
+		ticketID, staffUserID, time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// ListWorkedBy returns every ticket staffUserID has ever been assigned to,
+// including ones since reassigned or closed.
+func (r *Repository) ListWorkedBy(ctx context.Context, staffUserID int64) ([]Ticket, error) {
+	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets
+		 WHERE id IN (SELECT ticket_id FROM ticket_assignments WHERE staff_user_id=?) AND deleted_at IS NULL
+		 ORDER BY datetime(created_at) DESC, id DESC`, staffUserID)
+}
+
+// UpdateDescription rewrites the type and description of a ticket created by
+// creatorID that is still OPEN. It returns sql.ErrNoRows when none matched.
+func (r *Repository) UpdateDescription(ctx context.Context, id int64, creatorID int64, typ, description string) (Ticket, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE tickets SET type=?, description=?, version=version+1 WHERE id=? AND created_by_user_id=? AND status=? AND deleted_at IS NULL`,
+		typ, description, id, creatorID, StatusOpen)
 	if err != nil {
 		return Ticket{}, err
 	}
@@ -191,36 +746,312 @@ func (r *Repository) Assign(ctx context.Context, id int64, staffUserID int64) (T
 	return r.Get(ctx, id)
 }
 
-func (r *Repository) list(ctx context.Context, q string, args ...any) ([]Ticket, error) {
-	rows, err := r.db.QueryContext(ctx, q, args...)
+// CancelIfOpen cancels the ticket if creatorID opened it and it is still
+// OPEN. It returns sql.ErrNoRows when no such ticket matched.
+func (r *Repository) CancelIfOpen(ctx context.Context, id int64, creatorID int64) (Ticket, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE tickets SET status=?, version=version+1 WHERE id=? AND created_by_user_id=? AND status=? AND deleted_at IS NULL`,
+		StatusCancelled, id, creatorID, StatusOpen)
+	if err != nil {
+		return Ticket{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Ticket{}, err
+	}
+	if n == 0 {
+		return Ticket{}, sql.ErrNoRows
+	}
+	return r.Get(ctx, id)
+}
+
+// SoftDelete hides a ticket from every listing while keeping the row for
+// audit. It returns sql.ErrNoRows if the ticket is missing or already deleted.
+func (r *Repository) SoftDelete(ctx context.Context, id int64) (Ticket, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE tickets SET deleted_at=?, version=version+1 WHERE id=? AND deleted_at IS NULL`,
+		time.Now().UTC().Format(time.RFC3339Nano), id)
+	if err != nil {
+		return Ticket{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Ticket{}, err
+	}
+	if n == 0 {
+		return Ticket{}, sql.ErrNoRows
+	}
+	return r.GetWithDeleted(ctx, id)
+}
+
+// AssignDepartment routes ticket id to department, or clears the routing
+// when department is "". A person already assigned keeps the ticket.
+// Finished tickets are left alone and reported as sql.ErrNoRows.
+func (r *Repository) AssignDepartment(ctx context.Context, id int64, department string) (Ticket, error) {
+	var t Ticket
+	err := r.WithTx(ctx, func(tx *Repository) error {
+		res, err := tx.db.ExecContext(ctx, `UPDATE tickets SET assigned_department=NULLIF(?, ''), version=version+1 WHERE id=? AND status NOT IN (?, ?) AND deleted_at IS NULL`,
+			department, id, StatusResolved, StatusCancelled)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+		t, err = tx.Get(ctx, id)
+		return err
+	})
+	return t, err
+}
+
+// ListDepartmentQueue returns the unresolved tickets routed to department,
+// ignoring case, that nobody has claimed yet, oldest first.
+func (r *Repository) ListDepartmentQueue(ctx context.Context, department string) ([]Ticket, error) {
+	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets
+		 WHERE assigned_department=? COLLATE NOCASE AND assigned_to_user_id IS NULL AND status NOT IN (?, ?) AND deleted_at IS NULL
+		 ORDER BY datetime(created_at) ASC, id ASC`, department, StatusResolved, StatusCancelled)
+}
+
+// ListUnassigned returns unresolved (and uncancelled) tickets nobody is working, oldest first.
+func (r *Repository) ListUnassigned(ctx context.Context) ([]Ticket, error) {
+	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets
+		 WHERE assigned_to_user_id IS NULL AND status NOT IN (?, ?) AND deleted_at IS NULL
+		 ORDER BY datetime(created_at) ASC, id ASC`, StatusResolved, StatusCancelled)
+}
+
+// CountOpenByStaff returns the number of unresolved tickets per assignee.
+// Staff with no open tickets are absent from the map.
+func (r *Repository) CountOpenByStaff(ctx context.Context) (map[int64]int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT assigned_to_user_id, COUNT(*)
+		FROM tickets
+		WHERE assigned_to_user_id IS NOT NULL AND status NOT IN (?, ?) AND deleted_at IS NULL
+		GROUP BY assigned_to_user_id
+	`, StatusResolved, StatusCancelled)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	out := map[int64]int{}
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		out[id] = n
+	}
+	return out, rows.Err()
+}
+
+// StaffCounts are one staffer's ticket aggregates.
+type StaffCounts struct {
+	Assigned             int // tickets ever assigned to them
+	Resolved             int // currently RESOLVED and assigned to them
+	Open                 int // unresolved and assigned to them
+	AvgResolutionSeconds int64
+}
+
+// StaffCounts computes a staffer's aggregates in one round trip. A staffer
+// with no tickets gets zeros. The average resolution time covers the
+// resolved tickets, each measured like ResolutionTimes.
+func (r *Repository) StaffCounts(ctx context.Context, staffUserID int64) (StaffCounts, error) {
+	var c StaffCounts
+	var avg sql.NullFloat64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT
+		  (SELECT COUNT(DISTINCT a.ticket_id) FROM ticket_assignments a
+		     JOIN tickets t ON t.id = a.ticket_id
+		    WHERE a.staff_user_id = ? AND t.deleted_at IS NULL),
+		  COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0),
+		  COALESCE(SUM(CASE WHEN status NOT IN (?, ?) THEN 1 ELSE 0 END), 0),
+		  (SELECT AVG(d) FROM (
+		     SELECT (MAX(julianday(e.created_at)) - julianday(t.created_at)) * 86400.0 AS d
+		       FROM tickets t
+		       JOIN ticket_events e ON e.ticket_id = t.id
+		      WHERE t.assigned_to_user_id = ? AND t.status = ? AND t.deleted_at IS NULL
+		        AND e.to_status = ?
+		      GROUP BY t.id))
+		FROM tickets
+		WHERE assigned_to_user_id = ? AND deleted_at IS NULL
+	`, staffUserID, StatusResolved, StatusResolved, StatusCancelled,
+		staffUserID, StatusResolved, StatusResolved, staffUserID).
+		Scan(&c.Assigned, &c.Resolved, &c.Open, &avg)
+	if err != nil {
+		return StaffCounts{}, err
+	}
+	if avg.Valid {
+		c.AvgResolutionSeconds = int64(math.Round(max(avg.Float64, 0)))
+	}
+	return c, nil
+}
+
+// OpenTicketCountsByRoom returns the number of unresolved tickets per room,
+// busiest room first, with the creation time of the oldest one.
+func (r *Repository) OpenTicketCountsByRoom(ctx context.Context) ([]RoomSummary, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT room, COUNT(*), strftime('%Y-%m-%dT%H:%M:%fZ', MIN(julianday(created_at)))
+		FROM tickets
+		WHERE status NOT IN (?, ?) AND deleted_at IS NULL
+		GROUP BY room
+		ORDER BY COUNT(*) DESC, room ASC
+	`, StatusResolved, StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []RoomSummary{}
+	for rows.Next() {
+		var s RoomSummary
+		var oldest string
+		if err := rows.Scan(&s.Room, &s.Open, &oldest); err != nil {
+			return nil, err
+		}
+		s.OldestOpenAt = parseTime(oldest)
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// ListOverdueUnnotified returns unresolved tickets created before cutoff
+// that have not yet fired an overdue event.
+func (r *Repository) ListOverdueUnnotified(ctx context.Context, cutoff time.Time) ([]Ticket, error) {
+	return r.list(ctx, `SELECT id, type, room, description, status, created_at, created_by_user_id, assigned_to_user_id, deleted_at, version, assigned_department,
+		        (SELECT COUNT(*) FROM ticket_attachments a WHERE a.ticket_id = tickets.id)
+		 FROM tickets
+		 WHERE status NOT IN (?, ?) AND deleted_at IS NULL AND overdue_notified_at IS NULL AND julianday(created_at) < julianday(?)
+		 ORDER BY datetime(created_at) ASC, id ASC`, StatusResolved, StatusCancelled, cutoff.UTC().Format(time.RFC3339Nano))
+}
+
+func (r *Repository) MarkOverdueNotified(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE tickets SET overdue_notified_at=? WHERE id=?`,
+		time.Now().UTC().Format(time.RFC3339Nano), id)
+	return err
+}
+
+// AssignIfUnassigned is Assign guarded against overwriting an existing
+// assignee; it returns sql.ErrNoRows if the ticket is missing or taken.
+func (r *Repository) AssignIfUnassigned(ctx context.Context, id int64, staffUserID int64) (Ticket, error) {
+	var t Ticket
+	err := r.WithTx(ctx, func(tx *Repository) error {
+		res, err := tx.db.ExecContext(ctx, `UPDATE tickets SET assigned_to_user_id=?, version=version+1 WHERE id=? AND assigned_to_user_id IS NULL AND deleted_at IS NULL`, staffUserID, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+		if err := tx.recordAssignment(ctx, id, staffUserID); err != nil {
+			return err
+		}
+		t, err = tx.Get(ctx, id)
+		return err
+	})
+	return t, err
+}
+
+func (r *Repository) list(ctx context.Context, q string, args ...any) ([]Ticket, error) {
 	var out []Ticket
+	err := r.each(ctx, func(t Ticket) error {
+		out = append(out, t)
+		return nil
+	}, q, args...)
+	return out, err
+}
+
+// each streams the rows of a ticket SELECT to fn without holding them all in
+// memory. Returning an error from fn stops the iteration.
+func (r *Repository) each(ctx context.Context, fn func(Ticket) error, q string, args ...any) error {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var t Ticket
 		var created string
 		var assigned sql.NullInt64
-		if err := rows.Scan(&t.ID, &t.Type, &t.Room, &t.Description, &t.Status, &created, &t.CreatedByUserID, &assigned); err != nil {
-			return nil, err
+		var deleted, dept sql.NullString
+		if err := rows.Scan(&t.ID, &t.Type, &t.Room, &t.Description, &t.Status, &created, &t.CreatedByUserID, &assigned, &deleted, &t.Version, &dept, &t.AttachmentCount); err != nil {
+			return err
 		}
 		t.CreatedAt = parseTime(created)
 		if assigned.Valid {
 			v := assigned.Int64
 			t.AssignedToUserID = &v
 		}
-		out = append(out, t)
+		if deleted.Valid {
+			v := parseTime(deleted.String)
+			t.DeletedAt = &v
+		}
+		t.AssignedDepartment = dept.String
+		if err := fn(t); err != nil {
+			return err
+		}
 	}
-	return out, rows.Err()
+	return rows.Err()
 }
 
 // --------------------
 // Chat repo methods
 // --------------------
 
-func (r *Repository) InsertChatMessage(ctx context.Context, m ChatMessage) (ChatMessage, error) {
+// Chat cap policies: what InsertChatMessage does once a ticket holds
+// ChatCap.Max messages.
+const (
+	ChatCapReject = "reject" // refuse the new message with ErrChatFull
+	ChatCapPrune  = "prune"  // delete the oldest messages to make room
+)
+
+// ChatCap limits how many live messages a ticket's chat holds. Deleted
+// messages are emptied tombstones, so they don't count towards it. Max <= 0
+// means no limit.
+type ChatCap struct {
+	Max    int
+	Policy string // ChatCapReject or ChatCapPrune; "" rejects
+}
+
+// ErrChatFull means a ticket's chat is at its ChatCap under ChatCapReject.
+var ErrChatFull = errors.New("tickets: chat message limit reached")
+
+// InsertChatMessage stores m, first enforcing limit on the ticket's chat.
+// Call it inside WithTx so the count and the insert (and any pruning) happen
+// atomically.
+func (r *Repository) InsertChatMessage(ctx context.Context, m ChatMessage, limit ChatCap) (ChatMessage, error) {
+	if limit.Max > 0 {
+		var n int
+		if err := r.db.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM chat_messages WHERE ticket_id=? AND deleted_at IS NULL`, m.TicketID,
+		).Scan(&n); err != nil {
+			return ChatMessage{}, err
+		}
+		if n >= limit.Max {
+			if limit.Policy != ChatCapPrune {
+				return ChatMessage{}, ErrChatFull
+			}
+			if _, err := r.db.ExecContext(ctx, `
+				DELETE FROM chat_messages WHERE id IN (
+					SELECT id FROM chat_messages WHERE ticket_id=? AND deleted_at IS NULL ORDER BY id LIMIT ?
+				)`, m.TicketID, n-limit.Max+1); err != nil {
+				return ChatMessage{}, err
+			}
+		}
+	}
+
 	res, err := r.db.ExecContext(ctx, `
 		INSERT INTO chat_messages(ticket_id, from_user_id, from_username, from_role, message, sent_at)
 		VALUES(?,?,?,?,?,?)
@@ -236,32 +1067,373 @@ func (r *Repository) InsertChatMessage(ctx context.Context, m ChatMessage) (Chat
 	return m, nil
 }
 
-func (r *Repository) ListChatMessages(ctx context.Context, ticketID int64, limit int) ([]ChatMessage, error) {
+// ListChatMessages returns up to limit messages of a ticket in chronological
+// order: the newest ones, or with beforeID > 0 the ones just before that
+// message. hasMore reports whether older messages remain.
+func (r *Repository) ListChatMessages(ctx context.Context, ticketID int64, beforeID int64, limit int) (msgs []ChatMessage, hasMore bool, err error) {
 	if limit <= 0 || limit > 500 {
 		limit = 200
 	}
 
-	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, ticket_id, from_user_id, from_username, from_role, message, sent_at
+	q := `SELECT id, ticket_id, from_user_id, from_username, from_role, message, sent_at, edited_at, deleted_at
 		FROM chat_messages
-		WHERE ticket_id=?
-		ORDER BY id ASC
-		LIMIT ?
-	`, ticketID, limit)
+		WHERE ticket_id=?`
+	args := []any{ticketID}
+	if beforeID > 0 {
+		q += ` AND id < ?`
+		args = append(args, beforeID)
+	}
+	// One extra row tells us whether there is another page.
+	q += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	out := []ChatMessage{}
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(out) > limit {
+		out = out[:limit]
+		hasMore = true
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, hasMore, nil
+}
+
+// ChatInboxFilter picks the tickets whose chats ListChatInbox merges: every
+// ticket with All, else those created by CreatedBy or currently assigned to
+// AssignedTo. Exactly one should be set.
+type ChatInboxFilter struct {
+	All        bool
+	CreatedBy  int64
+	AssignedTo int64
+}
+
+// ListChatInbox returns chat messages across the tickets f selects, newest
+// first, skipping deleted tickets. Paging works like ListChatMessages:
+// beforeID continues below the last page's oldest message.
+func (r *Repository) ListChatInbox(ctx context.Context, f ChatInboxFilter, beforeID int64, limit int) (msgs []ChatMessage, hasMore bool, err error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	q := `SELECT c.id, c.ticket_id, c.from_user_id, c.from_username, c.from_role, c.message, c.sent_at, c.edited_at, c.deleted_at
+		FROM chat_messages c
+		JOIN tickets t ON t.id = c.ticket_id
+		WHERE t.deleted_at IS NULL`
+	var args []any
+	switch {
+	case f.All:
+	case f.CreatedBy > 0:
+		q += ` AND t.created_by_user_id = ?`
+		args = append(args, f.CreatedBy)
+	case f.AssignedTo > 0:
+		q += ` AND t.assigned_to_user_id = ?`
+		args = append(args, f.AssignedTo)
+	default:
+		return []ChatMessage{}, false, nil
+	}
+	if beforeID > 0 {
+		q += ` AND c.id < ?`
+		args = append(args, beforeID)
+	}
+	q += ` ORDER BY c.id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	out := []ChatMessage{}
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(out) > limit {
+		out = out[:limit]
+		hasMore = true
+	}
+	return out, hasMore, nil
+}
+
+// GetChatMessage returns one message of a ticket, including deleted ones.
+func (r *Repository) GetChatMessage(ctx context.Context, ticketID, msgID int64) (ChatMessage, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, ticket_id, from_user_id, from_username, from_role, message, sent_at, edited_at, deleted_at
+		FROM chat_messages
+		WHERE ticket_id=? AND id=?
+	`, ticketID, msgID)
+	return scanChatMessage(row)
+}
+
+// EditChatMessage replaces the text of a message that isn't deleted.
+func (r *Repository) EditChatMessage(ctx context.Context, ticketID, msgID int64, text string) (ChatMessage, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE chat_messages SET message=?, edited_at=? WHERE ticket_id=? AND id=? AND deleted_at IS NULL`,
+		text, time.Now().UTC().Format(time.RFC3339Nano), ticketID, msgID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	if n == 0 {
+		return ChatMessage{}, sql.ErrNoRows
+	}
+	return r.GetChatMessage(ctx, ticketID, msgID)
+}
+
+// DeleteChatMessage blanks a message's text and marks it deleted; the row
+// stays so the thread keeps its shape.
+func (r *Repository) DeleteChatMessage(ctx context.Context, ticketID, msgID int64) (ChatMessage, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE chat_messages SET message='', deleted_at=? WHERE ticket_id=? AND id=? AND deleted_at IS NULL`,
+		time.Now().UTC().Format(time.RFC3339Nano), ticketID, msgID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	if n == 0 {
+		return ChatMessage{}, sql.ErrNoRows
+	}
+	return r.GetChatMessage(ctx, ticketID, msgID)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanChatMessage(row rowScanner) (ChatMessage, error) {
+	var m ChatMessage
+	var sent string
+	var edited, deleted sql.NullString
+	if err := row.Scan(&m.ID, &m.TicketID, &m.FromUserID, &m.FromUsername, &m.FromRole, &m.Message, &sent, &edited, &deleted); err != nil {
+		return ChatMessage{}, err
+	}
+	m.SentAt = parseTime(sent)
+	if edited.Valid {
+		v := parseTime(edited.String)
+		m.EditedAt = &v
+	}
+	if deleted.Valid {
+		v := parseTime(deleted.String)
+		m.DeletedAt = &v
+		m.Message = ChatDeletedText
+	}
+	return m, nil
+}
+
+// MarkChatRead records that userID has read a ticket's chat up to
+// lastReadID, or up to the newest message when lastReadID is 0. The marker
+// never moves backwards. It returns the stored marker.
+func (r *Repository) MarkChatRead(ctx context.Context, ticketID, userID, lastReadID int64) (int64, error) {
+	if lastReadID <= 0 {
+		if err := r.db.QueryRowContext(ctx,
+			`SELECT COALESCE(MAX(id), 0) FROM chat_messages WHERE ticket_id=?`, ticketID,
+		).Scan(&lastReadID); err != nil {
+			return 0, err
+		}
+	}
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO chat_reads(ticket_id, user_id, last_read_id) VALUES(?,?,?)
+		ON CONFLICT(ticket_id, user_id) DO UPDATE SET last_read_id=MAX(last_read_id, excluded.last_read_id)
+	`, ticketID, userID, lastReadID)
+	if err != nil {
+		return 0, err
+	}
+	var stored int64
+	err = r.db.QueryRowContext(ctx,
+		`SELECT last_read_id FROM chat_reads WHERE ticket_id=? AND user_id=?`, ticketID, userID,
+	).Scan(&stored)
+	return stored, err
+}
+
+// UnreadChatCounts returns, per ticket, how many messages userID hasn't read
+// yet. Their own and deleted messages don't count; tickets with nothing
+// unread are absent from the map.
+func (r *Repository) UnreadChatCounts(ctx context.Context, userID int64, ticketIDs []int64) (map[int64]int, error) {
+	out := map[int64]int{}
+	if len(ticketIDs) == 0 {
+		return out, nil
+	}
+	args := []any{userID, userID}
+	for _, id := range ticketIDs {
+		args = append(args, id)
+	}
+	q := `SELECT m.ticket_id, COUNT(*)
+		FROM chat_messages m
+		LEFT JOIN chat_reads cr ON cr.ticket_id = m.ticket_id AND cr.user_id = ?
+		WHERE m.from_user_id <> ? AND m.deleted_at IS NULL
+		  AND m.id > COALESCE(cr.last_read_id, 0)
+		  AND m.ticket_id IN (?` + strings.Repeat(",?", len(ticketIDs)-1) + `)
+		GROUP BY m.ticket_id`
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		out[id] = n
+	}
+	return out, rows.Err()
+}
+
+// --------------------
+// Ticket events repo methods
+// --------------------
 
-	var out []ChatMessage
+func (r *Repository) InsertEvent(ctx context.Context, e TicketEvent) (TicketEvent, error) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	res, err := r.db.ExecContext(ctx, `
+		INSERT INTO ticket_events(ticket_id, actor_user_id, actor_role, action, detail, to_status, created_at)
+		VALUES(?,?,?,?,?,NULLIF(?, ''),?)
+	`, e.TicketID, e.ActorUserID, e.ActorRole, e.Action, e.Detail, e.ToStatus, e.CreatedAt.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return TicketEvent{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return TicketEvent{}, err
+	}
+	e.ID = id
+	return e, nil
+}
+
+// ListEventsByActor returns the newest events recorded for actorUserID,
+// ordered by (created_at, id). If before is non-zero only events after that
+// position are returned: those older than before, and with beforeID > 0 also
+// those at the same instant with a smaller ID, so events sharing a timestamp
+// across a page boundary are neither skipped nor repeated.
+func (r *Repository) ListEventsByActor(ctx context.Context, actorUserID int64, before time.Time, beforeID int64, limit int) ([]TicketEvent, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	q := `SELECT id, ticket_id, actor_user_id, actor_role, action, detail, COALESCE(to_status, ''), created_at
+		FROM ticket_events
+		WHERE actor_user_id=?`
+	args := []any{actorUserID}
+	if !before.IsZero() {
+		b := before.UTC().Format(time.RFC3339Nano)
+		if beforeID > 0 {
+			q += ` AND (julianday(created_at) < julianday(?) OR (julianday(created_at) = julianday(?) AND id < ?))`
+			args = append(args, b, b, beforeID)
+		} else {
+			q += ` AND julianday(created_at) < julianday(?)`
+			args = append(args, b)
+		}
+	}
+	q += ` ORDER BY julianday(created_at) DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []TicketEvent
 	for rows.Next() {
-		var m ChatMessage
-		var sent string
-		if err := rows.Scan(&m.ID, &m.TicketID, &m.FromUserID, &m.FromUsername, &m.FromRole, &m.Message, &sent); err != nil {
+		var e TicketEvent
+		var created string
+		if err := rows.Scan(&e.ID, &e.TicketID, &e.ActorUserID, &e.ActorRole, &e.Action, &e.Detail, &e.ToStatus, &created); err != nil {
 			return nil, err
 		}
-		m.SentAt = parseTime(sent)
-		out = append(out, m)
+		e.CreatedAt = parseTime(created)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ResolutionSample is how long one resolved ticket took, from creation to
+// its last move to RESOLVED.
+type ResolutionSample struct {
+	TicketID         int64
+	Type             string
+	AssignedToUserID *int64
+	Duration         time.Duration
+}
+
+// ResolutionTimes measures every ticket that is currently RESOLVED using the
+// audit log's moves to RESOLVED (to_status). A ticket that was reopened is
+// measured to its final resolution. from and to, when non-zero, bound that
+// resolution time (inclusive).
+func (r *Repository) ResolutionTimes(ctx context.Context, from, to time.Time) ([]ResolutionSample, error) {
+	q := `SELECT t.id, t.type, t.assigned_to_user_id,
+			(MAX(julianday(e.created_at)) - julianday(t.created_at)) * 86400.0
+		FROM tickets t
+		JOIN ticket_events e ON e.ticket_id = t.id
+		WHERE t.status = ? AND t.deleted_at IS NULL
+		  AND e.to_status = ?
+		GROUP BY t.id`
+	args := []any{StatusResolved, StatusResolved}
+	var having []string
+	if !from.IsZero() {
+		having = append(having, `MAX(julianday(e.created_at)) >= julianday(?)`)
+		args = append(args, from.UTC().Format(time.RFC3339Nano))
+	}
+	if !to.IsZero() {
+		having = append(having, `MAX(julianday(e.created_at)) <= julianday(?)`)
+		args = append(args, to.UTC().Format(time.RFC3339Nano))
+	}
+	if len(having) > 0 {
+		q += ` HAVING ` + strings.Join(having, ` AND `)
+	}
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ResolutionSample
+	for rows.Next() {
+		var s ResolutionSample
+		var assigned sql.NullInt64
+		var secs float64
+		if err := rows.Scan(&s.TicketID, &s.Type, &assigned, &secs); err != nil {
+			return nil, err
+		}
+		if assigned.Valid {
+			s.AssignedToUserID = &assigned.Int64
+		}
+		s.Duration = time.Duration(max(secs, 0) * float64(time.Second)).Round(time.Second)
+		out = append(out, s)
 	}
 	return out, rows.Err()
 }
@@ -275,3 +1447,136 @@ func parseTime(s string) time.Time {
 	}
 	return time.Now().UTC()
 }
+
+// --------------------
+// Attachment repo methods
+// --------------------
+
+func (r *Repository) InsertAttachment(ctx context.Context, at Attachment) (Attachment, error) {
+	at.CreatedAt = time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `
+		INSERT INTO ticket_attachments(ticket_id, url, uploaded_by_user_id, created_at, file_name, content_type, size_bytes)
+		VALUES(?,?,?,?,?,?,?)
+	`, at.TicketID, at.URL, at.UploadedByUserID, at.CreatedAt.Format(time.RFC3339Nano), at.FileName, at.ContentType, at.Size)
+	if err != nil {
+		return Attachment{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Attachment{}, err
+	}
+	at.ID = id
+	return at, nil
+}
+
+// ListAttachments returns a ticket's attachments, oldest first.
+func (r *Repository) ListAttachments(ctx context.Context, ticketID int64) ([]Attachment, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, ticket_id, url, uploaded_by_user_id, created_at, file_name, content_type, size_bytes
+		FROM ticket_attachments
+		WHERE ticket_id=?
+		ORDER BY id ASC
+	`, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Attachment{}
+	for rows.Next() {
+		var at Attachment
+		var created string
+		if err := rows.Scan(&at.ID, &at.TicketID, &at.URL, &at.UploadedByUserID, &created, &at.FileName, &at.ContentType, &at.Size); err != nil {
+			return nil, err
+		}
+		at.CreatedAt = parseTime(created)
+		out = append(out, at)
+	}
+	return out, rows.Err()
+}
+
+// GetAttachmentFile looks up an uploaded file of a ticket by its stored name.
+func (r *Repository) GetAttachmentFile(ctx context.Context, ticketID int64, fileName string) (Attachment, error) {
+	var at Attachment
+	var created string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, ticket_id, url, uploaded_by_user_id, created_at, file_name, content_type, size_bytes
+		FROM ticket_attachments
+		WHERE ticket_id=? AND file_name=?
+	`, ticketID, fileName).Scan(&at.ID, &at.TicketID, &at.URL, &at.UploadedByUserID, &created, &at.FileName, &at.ContentType, &at.Size)
+	if err != nil {
+		return Attachment{}, err
+	}
+	at.CreatedAt = parseTime(created)
+	return at, nil
+}
+
+// PurgeResult counts the rows removed by one PurgeFinished batch.
+type PurgeResult struct {
+	TicketIDs    []int64
+	ChatMessages int64
+	Attachments  int64
+	Events       int64
+}
+
+// PurgeFinished deletes up to limit RESOLVED or CANCELLED tickets whose
+// last activity (latest audit entry, else creation) is before cutoff,
+// together with their chat, read markers, audit log, assignment history
+// and attachment rows. Files on disk are left to the caller.
+func (r *Repository) PurgeFinished(ctx context.Context, cutoff time.Time, limit int) (PurgeResult, error) {
+	var res PurgeResult
+	err := r.WithTx(ctx, func(tx *Repository) error {
+		rows, err := tx.db.QueryContext(ctx, `
+			SELECT id FROM tickets
+			WHERE status IN (?, ?)
+			  AND datetime(COALESCE((SELECT MAX(e.created_at) FROM ticket_events e WHERE e.ticket_id = tickets.id), created_at)) < datetime(?)
+			ORDER BY id
+			LIMIT ?
+		`, StatusResolved, StatusCancelled, cutoff.UTC().Format(time.RFC3339), limit)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			res.TicketIDs = append(res.TicketIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(res.TicketIDs) == 0 {
+			return err
+		}
+
+		marks := strings.TrimSuffix(strings.Repeat("?,", len(res.TicketIDs)), ",")
+		args := make([]any, len(res.TicketIDs))
+		for i, id := range res.TicketIDs {
+			args[i] = id
+		}
+		for _, step := range []struct {
+			table string
+			count *int64
+		}{
+			{"chat_messages", &res.ChatMessages},
+			{"chat_reads", nil},
+			{"ticket_events", &res.Events},
+			{"ticket_assignments", nil},
+			{"ticket_attachments", &res.Attachments},
+		} {
+			out, err := tx.db.ExecContext(ctx, `DELETE FROM `+step.table+` WHERE ticket_id IN (`+marks+`)`, args...)
+			if err != nil {
+				return err
+			}
+			if step.count != nil {
+				*step.count, _ = out.RowsAffected()
+			}
+		}
+		_, err = tx.db.ExecContext(ctx, `DELETE FROM tickets WHERE id IN (`+marks+`)`, args...)
+		return err
+	})
+	if err != nil {
+		return PurgeResult{}, err
+	}
+	return res, nil
+}
@@ -0,0 +1,171 @@
+package tickets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"src/internal/authclient"
+)
+
+// resolvedTicket stores a RESOLVED ticket assigned to staff, created at
+// created, whose audit log holds events in order.
+func resolvedTicket(t *testing.T, repo *Repository, staff int64, created time.Time, events ...TicketEvent) Ticket {
+	t.Helper()
+	ctx := context.Background()
+	tk := mustCreate(t, repo, Ticket{CreatedByUserID: 50, AssignedToUserID: &staff, Status: StatusResolved})
+	if _, err := repo.db.ExecContext(ctx, `UPDATE tickets SET created_at=? WHERE id=?`, created.Format(time.RFC3339Nano), tk.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		e.TicketID, e.ActorUserID, e.ActorRole = tk.ID, staff, authclient.RoleStaff
+		if _, err := repo.InsertEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tk
+}
+
+func TestStaffCountsAverageUsesToStatus(t *testing.T) {
+	repo := newTestRepo(t)
+	const staff = 7
+	t0 := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	// Resolved after 1h; the later IN_PROGRESS move and the chat don't
+	// count, nor does how detail happens to be worded.
+	resolvedTicket(t, repo, staff, t0,
+		TicketEvent{Action: ActionStatusUpdated, ToStatus: StatusInProgress, Detail: "started", CreatedAt: t0.Add(10 * time.Minute)},
+		TicketEvent{Action: ActionStatusUpdated, ToStatus: StatusResolved, Detail: "fixed", CreatedAt: t0.Add(time.Hour)},
+		TicketEvent{Action: ActionChatSent, CreatedAt: t0.Add(5 * time.Hour)},
+	)
+	// Resolved after 3h, reopened in between: measured to the last move.
+	resolvedTicket(t, repo, staff, t0,
+		TicketEvent{Action: ActionStatusUpdated, ToStatus: StatusResolved, CreatedAt: t0.Add(time.Hour)},
+		TicketEvent{Action: ActionReopened, ToStatus: StatusInProgress, CreatedAt: t0.Add(2 * time.Hour)},
+		TicketEvent{Action: ActionStatusUpdated, ToStatus: StatusResolved, CreatedAt: t0.Add(3 * time.Hour)},
+	)
+
+	c, err := repo.StaffCounts(context.Background(), staff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Resolved != 2 || c.AvgResolutionSeconds != 2*3600 {
+		t.Errorf("StaffCounts = %+v, want 2 resolved averaging 7200s", c)
+	}
+}
+
+func TestStatusChangesRecordToStatus(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+	admin := authclient.User{ID: 1, Role: authclient.RoleAdmin, Username: "admin"}
+	tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: 50})
+	id := strconv.FormatInt(tk.ID, 10)
+
+	for _, status := range []string{StatusInProgress, StatusResolved} {
+		w := httptest.NewRecorder()
+		a.UpdateStatus(w, request(http.MethodPatch, "/api/tickets/"+id+"/status", `{"status":"`+status+`"}`, "id", id), admin)
+		if w.Code != http.StatusOK {
+			t.Fatalf("update to %s: status %d: %s", status, w.Code, w.Body)
+		}
+	}
+
+	events, err := a.repo.ListEventsByActor(context.Background(), admin.ID, time.Time{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, e.ToStatus)
+	}
+	if len(got) != 2 || got[0] != StatusResolved || got[1] != StatusInProgress {
+		t.Errorf("to_status of the admin's events, newest first = %q", got)
+	}
+}
+
+func TestResolutionTimesUsesToStatus(t *testing.T) {
+	repo := newTestRepo(t)
+	t0 := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	quick := resolvedTicket(t, repo, 7, t0,
+		TicketEvent{Action: ActionStatusUpdated, ToStatus: StatusResolved, Detail: "done", CreatedAt: t0.Add(30 * time.Minute)},
+		TicketEvent{Action: ActionEdited, Detail: "note -> RESOLVED", CreatedAt: t0.Add(9 * time.Hour)},
+	)
+	reopened := resolvedTicket(t, repo, 8, t0,
+		TicketEvent{Action: ActionStatusUpdated, ToStatus: StatusResolved, CreatedAt: t0.Add(time.Hour)},
+		TicketEvent{Action: ActionReopened, ToStatus: StatusOpen, CreatedAt: t0.Add(2 * time.Hour)},
+		TicketEvent{Action: ActionStatusUpdated, ToStatus: StatusResolved, CreatedAt: t0.Add(4 * time.Hour)},
+	)
+	// Only a detail that reads like a resolution: not measured.
+	resolvedTicket(t, repo, 7, t0,
+		TicketEvent{Action: ActionStatusUpdated, Detail: "IN_PROGRESS -> RESOLVED", CreatedAt: t0.Add(time.Hour)},
+	)
+
+	got, err := repo.ResolutionTimes(context.Background(), time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]time.Duration{quick.ID: 30 * time.Minute, reopened.ID: 4 * time.Hour}
+	if len(got) != len(want) {
+		t.Fatalf("ResolutionTimes = %+v, want %d samples", got, len(want))
+	}
+	for _, s := range got {
+		if s.Duration != want[s.TicketID] {
+			t.Errorf("ticket %d took %v, want %v", s.TicketID, s.Duration, want[s.TicketID])
+		}
+	}
+
+	// from/to bound the final resolution, so only the reopened ticket fits.
+	got, err = repo.ResolutionTimes(context.Background(), t0.Add(3*time.Hour), t0.Add(5*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].TicketID != reopened.ID {
+		t.Errorf("ResolutionTimes in [3h, 5h] = %+v, want only ticket %d", got, reopened.ID)
+	}
+}
+
+// Resolving an already resolved ticket again is a no-op, so it can't push
+// the measured resolution time out.
+func TestRepeatedStatusIsNoOp(t *testing.T) {
+	a, broker := newTestAPI(t, Options{})
+	admin := authclient.User{ID: 1, Role: authclient.RoleAdmin, Username: "admin"}
+	tk := mustCreate(t, a.repo, Ticket{CreatedByUserID: 50})
+	id := strconv.FormatInt(tk.ID, 10)
+
+	resolve := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.UpdateStatus(w, request(http.MethodPatch, "/api/tickets/"+id+"/status", body, "id", id), admin)
+		return w
+	}
+	if w := resolve(`{"status":"RESOLVED"}`); w.Code != http.StatusOK {
+		t.Fatalf("resolve: status %d: %s", w.Code, w.Body)
+	}
+	before, err := a.repo.Get(context.Background(), tk.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := len(broker.Published())
+
+	w := resolve(`{"status":"RESOLVED"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("resolve again: status %d: %s", w.Code, w.Body)
+	}
+	var got Ticket
+	decodeBody(t, w, &got)
+	if got.Version != before.Version || got.Status != StatusResolved {
+		t.Errorf("second resolve returned %+v, want version %d unchanged", got, before.Version)
+	}
+	if n := countRows(t, a.repo.db, "ticket_events", "WHERE ticket_id = ? AND to_status = ?", tk.ID, StatusResolved); n != 1 {
+		t.Errorf("%d RESOLVED events, want 1", n)
+	}
+	if n := len(broker.Published()); n != published {
+		t.Errorf("second resolve published %d events", n-published)
+	}
+
+	// A stale version is still refused.
+	if w := resolve(`{"status":"RESOLVED","version":` + strconv.FormatInt(before.Version-1, 10) + `}`); w.Code != http.StatusConflict {
+		t.Errorf("stale no-op: status %d, want 409", w.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package tickets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"src/internal/authclient"
+	"src/internal/httpjson"
+)
+
+type RoomTicketsResponse struct {
+	Room    string         `json:"room"`
+	Counts  map[string]int `json:"counts"`
+	Tickets []Ticket       `json:"tickets"`
+}
+
+// ListRoomTickets returns every ticket for the {room} path parameter along
+// with per-status counts. Admin only.
+func (a *API) ListRoomTickets(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	room := strings.TrimSpace(chi.URLParam(r, "room"))
+	if room == "" {
+		writeErr(w, http.StatusBadRequest, "room required")
+		return
+	}
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
+
+	items, err := a.repo.ListByRoom(r.Context(), room)
+	if err != nil {
+		a.log(r).Error("list room tickets", "room", room, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	a.enrich(r.Context(), items)
+	a.fillUnread(r, u, items)
+	localizeTickets(items, loc)
+
+	counts := map[string]int{StatusOpen: 0, StatusInProgress: 0, StatusResolved: 0, StatusCancelled: 0}
+	for _, t := range items {
+		counts[t.Status]++
+	}
+	if items == nil {
+		items = []Ticket{}
+	}
+	writeJSON(w, http.StatusOK, RoomTicketsResponse{Room: room, Counts: counts, Tickets: items})
+}
+
+// RoomsSummary lists rooms with unresolved tickets, busiest first. Admin only.
+func (a *API) RoomsSummary(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	rooms, err := a.repo.OpenTicketCountsByRoom(r.Context())
+	if err != nil {
+		a.log(r).Error("rooms summary", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rooms": rooms})
+}
+
+type AddRoomReq struct {
+	Room  string `json:"room"`
+	Floor *int   `json:"floor,omitempty"`
+}
+
+// KnownRoom reports whether room may be used for tickets and guest
+// accounts: always when the registry is off, otherwise only if registered.
+func (a *API) KnownRoom(ctx context.Context, room string) (bool, error) {
+	if !a.opts.RoomRegistry {
+		return true, nil
+	}
+	return a.repo.RoomExists(ctx, room)
+}
+
+// ListRooms returns the room registry. Admin only.
+func (a *API) ListRooms(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	rooms, err := a.repo.ListRooms(r.Context())
+	if err != nil {
+		a.log(r).Error("list rooms", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"enforced": a.opts.RoomRegistry, "rooms": rooms})
+}
+
+// AddRoom registers a room, optionally with its floor. It answers 201 for a
+// new room and 200 if it was already registered (updating the floor when
+// one is given). Admin only.
+func (a *API) AddRoom(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	var req AddRoomReq
+	if err := httpjson.Decode(r, &req, true); err != nil {
+		writeErr(w, httpjson.Status(err), httpjson.ErrorMessage(err))
+		return
+	}
+	room := strings.TrimSpace(req.Room)
+	if room == "" {
+		writeErr(w, http.StatusBadRequest, "room is required")
+		return
+	}
+	created, err := a.repo.AddRoom(r.Context(), room, req.Floor)
+	if err != nil {
+		a.log(r).Error("add room", "room", room, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, map[string]any{"room": room, "floor": req.Floor})
+}
+
+// DeleteRoom removes the {room} path parameter from the registry. Admin only.
+func (a *API) DeleteRoom(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	room := strings.TrimSpace(chi.URLParam(r, "room"))
+	err := a.repo.DeleteRoom(r.Context(), room)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		a.log(r).Error("delete room", "room", room, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"room": room})
+}
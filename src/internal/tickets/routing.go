@@ -0,0 +1,52 @@
+package tickets
+
+import (
+	"net/http"
+
+	"src/internal/authclient"
+)
+
+// Type-change routing policies: what happens when a guest corrects the type
+// of an assigned ticket and the assignee isn't in the department named
+// after the new type (plumbing tickets go to the plumbing department).
+const (
+	RoutingOff     = "off"     // leave the assignee alone
+	RoutingSuggest = "suggest" // keep the assignee; record and announce a reassign_suggested event
+	RoutingAuto    = "auto"    // reassign to the specialist and record it
+)
+
+// specialistFor picks who should take t now that its type is typ: the
+// least-loaded staffer of typ's department. It returns nil when routing is
+// off, t is unassigned, its assignee is already in that department, or the
+// department has no staff. Lookup failures are logged and treated as "no
+// specialist", so they never block the edit itself.
+func (a *API) specialistFor(r *http.Request, t Ticket, typ string) *authclient.User {
+	switch a.opts.TypeChangeRouting {
+	case RoutingSuggest, RoutingAuto:
+	default:
+		return nil
+	}
+	if a.auth == nil || t.AssignedToUserID == nil {
+		return nil
+	}
+	staff, err := a.auth.ListUsersByRoleAndDepartment(r.Context(), authclient.RoleStaff, typ)
+	if err != nil {
+		a.log(r).Warn("type change routing: staff unavailable", "ticket_id", t.ID, "err", err)
+		return nil
+	}
+	if len(staff) == 0 {
+		return nil
+	}
+	for _, s := range staff {
+		if s.ID == *t.AssignedToUserID {
+			return nil
+		}
+	}
+	load, err := a.repo.CountOpenByStaff(r.Context())
+	if err != nil {
+		a.log(r).Warn("type change routing: workload unavailable", "ticket_id", t.ID, "err", err)
+		return nil
+	}
+	pick := leastLoaded(staff, load)
+	return &pick
+}
@@ -0,0 +1,111 @@
+package tickets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"src/internal/authclient"
+	"src/internal/mq"
+)
+
+func TestUpdateTicketTypeChangeRouting(t *testing.T) {
+	guest := authclient.User{ID: 50, Role: authclient.RoleGuest, Room: "101"}
+	staff := []authclient.User{
+		{ID: 1, Role: authclient.RoleStaff, Department: "cleaning"},
+		{ID: 2, Role: authclient.RoleStaff, Department: "plumbing"},
+		{ID: 3, Role: authclient.RoleStaff, Department: "Plumbing"},
+	}
+
+	tests := []struct {
+		name         string
+		mode         string
+		assignee     int64
+		wantAssignee int64
+		wantEvent    string // recorded and published; "" for none
+	}{
+		// Plumbers 2 and 3 are equally loaded, so the lower ID is picked.
+		{"auto reassigns to a plumber", RoutingAuto, 1, 2, ActionAssigned},
+		{"suggest keeps the assignee", RoutingSuggest, 1, 1, ActionSuggested},
+		{"off keeps the assignee", RoutingOff, 1, 1, ""},
+		{"assignee already a plumber", RoutingAuto, 3, 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, broker := newTestAPI(t, Options{TypeChangeRouting: tt.mode})
+			a.auth = newFakeAuth(t, staff)
+			tk := mustCreate(t, a.repo, Ticket{Type: "other", CreatedByUserID: guest.ID, AssignedToUserID: &tt.assignee})
+
+			w := httptest.NewRecorder()
+			a.UpdateTicket(w, request(http.MethodPatch, "/api/tickets/1", `{"type":"plumbing"}`, "id", strconv.FormatInt(tk.ID, 10)), guest)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			got, err := a.repo.Get(context.Background(), tk.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Type != "plumbing" {
+				t.Errorf("type = %q, want plumbing", got.Type)
+			}
+			if got.AssignedToUserID == nil || *got.AssignedToUserID != tt.wantAssignee {
+				t.Errorf("assignee = %v, want %d", got.AssignedToUserID, tt.wantAssignee)
+			}
+
+			events, err := a.repo.ListEventsByActor(context.Background(), guest.ID, time.Time{}, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var recorded string
+			for _, e := range events {
+				if e.Action == ActionAssigned || e.Action == ActionSuggested {
+					recorded = e.Action
+					if want := "staff_user_id=2 (type changed to plumbing)"; e.Detail != want {
+						t.Errorf("detail = %q, want %q", e.Detail, want)
+					}
+				}
+			}
+			if recorded != tt.wantEvent {
+				t.Errorf("recorded %q, want %q", recorded, tt.wantEvent)
+			}
+
+			// Assignments go out on the assigned topic, suggestions on their
+			// own, naming the specialist as suggested_to only.
+			var published string
+			for _, p := range broker.Published() {
+				if p.Topic != mq.TopicTicketAssigned && p.Topic != mq.TopicTicketReassignSuggested {
+					continue
+				}
+				ev, err := mq.Decode(p.Payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var payload EventPayload
+				if err := json.Unmarshal(ev.Data, &payload); err != nil {
+					t.Fatal(err)
+				}
+				published = payload.Event
+				want, other := payload.AssignedTo, payload.SuggestedTo
+				wantTopic := mq.TopicTicketAssigned
+				if payload.Event == ActionSuggested {
+					want, other = other, want
+					wantTopic = mq.TopicTicketReassignSuggested
+				}
+				if p.Topic != wantTopic {
+					t.Errorf("%s published on %s, want %s", payload.Event, p.Topic, wantTopic)
+				}
+				if want == nil || want.ID != 2 || other != nil {
+					t.Errorf("assigned_to = %v, suggested_to = %v; want only the %s one set to staff 2", payload.AssignedTo, payload.SuggestedTo, payload.Event)
+				}
+			}
+			if published != tt.wantEvent {
+				t.Errorf("published %q, want %q", published, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package tickets
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"src/internal/sqlitedb"
+)
+
+// preFKSchema is the ticket schema as it stood before the child tables
+// referenced tickets(id).
+const preFKSchema = `
+CREATE TABLE tickets (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  type TEXT NOT NULL,
+  room TEXT NOT NULL,
+  description TEXT NOT NULL,
+  status TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  created_by_user_id INTEGER NOT NULL DEFAULT 0,
+  assigned_to_user_id INTEGER NULL
+);
+CREATE TABLE chat_messages (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ticket_id INTEGER NOT NULL,
+  from_user_id INTEGER NOT NULL,
+  from_username TEXT NOT NULL,
+  from_role TEXT NOT NULL,
+  message TEXT NOT NULL,
+  sent_at TEXT NOT NULL
+);
+CREATE TABLE ticket_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ticket_id INTEGER NOT NULL,
+  actor_user_id INTEGER NOT NULL,
+  actor_role TEXT NOT NULL,
+  action TEXT NOT NULL,
+  detail TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL
+);
+CREATE TABLE ticket_assignments (
+  ticket_id INTEGER NOT NULL,
+  staff_user_id INTEGER NOT NULL,
+  assigned_at TEXT NOT NULL,
+  PRIMARY KEY (ticket_id, staff_user_id)
+);
+
+INSERT INTO tickets(id, type, room, description, status, created_at, assigned_to_user_id) VALUES
+  (1, 'plumbing', '101', 'leak', 'OPEN', '2026-01-01T00:00:00Z', 7),
+  (2, 'other', '102', 'noise', 'OPEN', '2026-01-01T00:00:00Z', NULL);
+INSERT INTO chat_messages(id, ticket_id, from_user_id, from_username, from_role, message, sent_at) VALUES
+  (1, 1, 1, 'admin', 'ADMIN', 'hello', '2026-01-01T00:01:00Z'),
+  (2, 1, 7, 'sam', 'STAFF', 'on it', '2026-01-01T00:02:00Z'),
+  (3, 2, 1, 'admin', 'ADMIN', 'checking', '2026-01-01T00:03:00Z'),
+  (4, 99, 1, 'admin', 'ADMIN', 'orphan', '2026-01-01T00:04:00Z'),
+  (5, 2, 1, 'admin', 'ADMIN', 'removed', '2026-01-01T00:05:00Z');
+DELETE FROM chat_messages WHERE id = 5;
+INSERT INTO ticket_events(ticket_id, actor_user_id, actor_role, action, detail, created_at) VALUES
+  (1, 1, 'ADMIN', 'assigned', 'staff_user_id=7', '2026-01-01T00:00:30Z'),
+  (2, 50, 'GUEST', 'created', '', '2026-01-01T00:00:00Z');
+INSERT INTO ticket_assignments(ticket_id, staff_user_id, assigned_at) VALUES
+  (1, 7, '2026-01-01T00:00:30Z'),
+  (2, 8, '2026-01-01T00:00:40Z');
+`
+
+func TestInitSchemaAddsTicketForeignKeys(t *testing.T) {
+	db, err := sqlitedb.Open(filepath.Join(t.TempDir(), "tickets.db"), sqlitedb.Pool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(preFKSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitSchema(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	for _, tbl := range ticketChildTables {
+		if ok, err := hasTicketFK(db, tbl.name); err != nil || !ok {
+			t.Errorf("%s: cascading ticket FK = %v, %v", tbl.name, ok, err)
+		}
+	}
+
+	// Every row of a live ticket survives; the orphan is dropped.
+	wantCounts := map[string]int{
+		"chat_messages":      3,
+		"ticket_events":      2,
+		"ticket_assignments": 2,
+	}
+	for tbl, want := range wantCounts {
+		if got := countRows(t, db, tbl, ""); got != want {
+			t.Errorf("%s rows after migration = %d, want %d", tbl, got, want)
+		}
+	}
+
+	repo := NewRepository(db)
+	ctx := context.Background()
+	msgs, _, err := repo.ListChatMessages(ctx, 1, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != 1 || msgs[0].Message != "hello" || msgs[1].FromUsername != "sam" {
+		t.Errorf("ticket 1 chat after migration = %+v", msgs)
+	}
+
+	// The AUTOINCREMENT sequence carries over, so the deleted id 5 is not
+	// handed out again.
+	m, err := repo.InsertChatMessage(ctx, ChatMessage{TicketID: 2, FromUserID: 1, Message: "next", SentAt: time.Now()}, ChatCap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 6 {
+		t.Errorf("next chat id = %d, want 6", m.ID)
+	}
+
+	// A second run leaves the migrated tables alone.
+	if err := InitSchema(db); err != nil {
+		t.Fatalf("second InitSchema: %v", err)
+	}
+	if got := countRows(t, db, "chat_messages", ""); got != 4 {
+		t.Errorf("chat_messages rows after second run = %d, want 4", got)
+	}
+}
+
+// A legacy database ends up with exactly the child tables a fresh one gets:
+// same columns in the same order, same indexes, same foreign keys.
+func TestMigratedSchemaMatchesFresh(t *testing.T) {
+	open := func() *sql.DB {
+		db, err := sqlitedb.Open(filepath.Join(t.TempDir(), "tickets.db"), sqlitedb.Pool{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	fresh, legacy := open(), open()
+	if err := InitSchema(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(preFKSchema); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitSchema(legacy); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	for _, tbl := range ticketChildTables {
+		want, got := tableShape(t, fresh, tbl.name), tableShape(t, legacy, tbl.name)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s after migration:\n got %q\nwant %q", tbl.name, got, want)
+		}
+	}
+}
+
+// tableShape describes table's columns, indexes and foreign keys.
+func tableShape(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+	var out []string
+	for _, q := range []string{
+		`SELECT name || ' ' || type || ' notnull=' || "notnull" || ' default=' || COALESCE(dflt_value, 'NULL') || ' pk=' || pk FROM pragma_table_info(?) ORDER BY cid`,
+		`SELECT 'index ' || name FROM pragma_index_list(?) ORDER BY name`,
+		`SELECT 'fk ' || "from" || ' -> ' || "table" || '(' || "to" || ') on delete ' || on_delete FROM pragma_foreign_key_list(?)`,
+	} {
+		rows, err := db.Query(q, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, line)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+// Status changes logged before ticket_events had to_status get it from
+// their detail text.
+func TestInitSchemaFillsEventToStatus(t *testing.T) {
+	db, err := sqlitedb.Open(filepath.Join(t.TempDir(), "tickets.db"), sqlitedb.Pool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(preFKSchema + `
+INSERT INTO ticket_events(id, ticket_id, actor_user_id, actor_role, action, detail, created_at) VALUES
+  (10, 1, 7, 'STAFF', 'status_updated', 'OPEN -> IN_PROGRESS', '2026-01-01T01:00:00Z'),
+  (11, 1, 7, 'STAFF', 'status_updated', 'IN_PROGRESS -> RESOLVED', '2026-01-01T02:00:00Z'),
+  (12, 1, 50, 'GUEST', 'reopened', 'RESOLVED -> IN_PROGRESS: still leaking -> worse', '2026-01-01T03:00:00Z'),
+  (13, 2, 50, 'GUEST', 'cancelled', '', '2026-01-01T04:00:00Z'),
+  (14, 2, 1, 'ADMIN', 'edited', 'type: other -> plumbing', '2026-01-01T05:00:00Z');
+`); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitSchema(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	want := map[int64]string{
+		10: StatusInProgress,
+		11: StatusResolved,
+		12: StatusInProgress,
+		13: StatusCancelled,
+		14: "",
+	}
+	for id, status := range want {
+		var got string
+		if err := db.QueryRow(`SELECT COALESCE(to_status, '') FROM ticket_events WHERE id=?`, id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != status {
+			t.Errorf("event %d to_status = %q, want %q", id, got, status)
+		}
+	}
+}
+
+func TestDeletingTicketCascades(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	staff := int64(7)
+	gone := mustCreate(t, repo, Ticket{CreatedByUserID: 50})
+	kept := mustCreate(t, repo, Ticket{CreatedByUserID: 50})
+
+	for _, tk := range []Ticket{gone, kept} {
+		mustChat(t, repo, tk.ID, "hello")
+		if _, err := repo.MarkChatRead(ctx, tk.ID, staff, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.InsertEvent(ctx, TicketEvent{TicketID: tk.ID, ActorUserID: 1, ActorRole: "ADMIN", Action: ActionAssigned, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Assign(ctx, tk.ID, staff); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := repo.db.ExecContext(ctx, `DELETE FROM tickets WHERE id=?`, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tbl := range []string{"chat_messages", "chat_reads", "ticket_events", "ticket_assignments"} {
+		if n := countRows(t, repo.db, tbl, "WHERE ticket_id = ?", gone.ID); n != 0 {
+			t.Errorf("%s: %d rows left for the deleted ticket", tbl, n)
+		}
+		if n := countRows(t, repo.db, tbl, "WHERE ticket_id = ?", kept.ID); n == 0 {
+			t.Errorf("%s: rows of the other ticket were deleted too", tbl)
+		}
+	}
+}
+
+func countRows(t *testing.T, db dbtx, table, where string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM `+table+` `+where, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,93 @@
+package tickets
+
+import (
+	"net/http"
+
+	"src/internal/authclient"
+)
+
+// StaffWorkload is a staff user with the number of unresolved tickets
+// currently assigned to them.
+type StaffWorkload struct {
+	authclient.User
+	OpenTickets int `json:"open_tickets"`
+}
+
+// ListWorked returns every ticket the staffer has been assigned to, with its
+// current status, even after it moved to someone else or was closed. Staff
+// only.
+func (a *API) ListWorked(w http.ResponseWriter, r *http.Request, u authclient.User) {
+	if u.Role != authclient.RoleStaff {
+		writeErr(w, http.StatusForbidden, "staff only")
+		return
+	}
+	loc, ok := a.location(w, r)
+	if !ok {
+		return
+	}
+	items, err := a.repo.ListWorkedBy(r.Context(), u.ID)
+	if err != nil {
+		a.log(r).Error("list worked tickets", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	a.enrich(r.Context(), items)
+	localizeTickets(items, loc)
+	if items == nil {
+		items = []Ticket{}
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// ListStaffWorkload answers the admin staff list, adding each staffer's
+// open ticket count so the assign dropdown can show who is busy. staff comes
+// from the auth service. Admin only.
+func (a *API) ListStaffWorkload(w http.ResponseWriter, r *http.Request, u authclient.User, staff []authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	load, err := a.repo.CountOpenByStaff(r.Context())
+	if err != nil {
+		a.log(r).Error("staff workload", "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	out := make([]StaffWorkload, len(staff))
+	for i, s := range staff {
+		out[i] = StaffWorkload{User: s, OpenTickets: load[s.ID]}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"users": out})
+}
+
+// StaffSummary is the shift-review view of one staffer.
+type StaffSummary struct {
+	User                 authclient.User `json:"user"`
+	Assigned             int             `json:"assigned"`
+	Resolved             int             `json:"resolved"`
+	Open                 int             `json:"open"`
+	AvgResolutionSeconds int64           `json:"avg_resolution_seconds"`
+}
+
+// StaffSummary answers the admin per-staffer summary. staff is the staffer
+// as looked up in the auth service. Admin only.
+func (a *API) StaffSummary(w http.ResponseWriter, r *http.Request, u authclient.User, staff authclient.User) {
+	if u.Role != authclient.RoleAdmin {
+		writeErr(w, http.StatusForbidden, "admin only")
+		return
+	}
+	c, err := a.repo.StaffCounts(r.Context(), staff.ID)
+	if err != nil {
+		a.log(r).Error("staff summary", "staff_id", staff.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, StaffSummary{
+		User:                 staff,
+		Assigned:             c.Assigned,
+		Resolved:             c.Resolved,
+		Open:                 c.Open,
+		AvgResolutionSeconds: c.AvgResolutionSeconds,
+	})
+}
@@ -0,0 +1,62 @@
+package tickets
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	// Embedded zone data, so ?tz= works on hosts without /usr/share/zoneinfo.
+	_ "time/tzdata"
+)
+
+// location reads the optional ?tz= IANA zone that list and get endpoints
+// render timestamps in, writing a 400 itself for an unknown zone. It returns
+// nil when none was asked for; responses then stay in UTC, which is also how
+// every timestamp is stored.
+func (a *API) location(w http.ResponseWriter, r *http.Request) (*time.Location, bool) {
+	name := strings.TrimSpace(r.URL.Query().Get("tz"))
+	if name == "" {
+		return nil, true
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid tz (IANA name, e.g. Europe/London)")
+		return nil, false
+	}
+	return loc, true
+}
+
+// localizeTickets moves the timestamps of items into loc. The instants are
+// unchanged; only the offset they are written with differs. A nil loc is a
+// no-op.
+func localizeTickets(items []Ticket, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	for i := range items {
+		t := &items[i]
+		t.CreatedAt = t.CreatedAt.In(loc)
+		t.DeletedAt = inLocation(t.DeletedAt, loc)
+	}
+}
+
+// localizeChat is localizeTickets for chat messages.
+func localizeChat(msgs []ChatMessage, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	for i := range msgs {
+		m := &msgs[i]
+		m.SentAt = m.SentAt.In(loc)
+		m.EditedAt = inLocation(m.EditedAt, loc)
+		m.DeletedAt = inLocation(m.DeletedAt, loc)
+	}
+}
+
+func inLocation(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	lt := t.In(loc)
+	return &lt
+}